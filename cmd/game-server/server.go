@@ -1,36 +1,109 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
 	"github.com/bstchow/go-chess-server/internal/api"
 	"github.com/bstchow/go-chess-server/internal/env"
 	"github.com/bstchow/go-chess-server/internal/models"
 	"github.com/bstchow/go-chess-server/pkg/agent"
+	"github.com/bstchow/go-chess-server/pkg/health"
 	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
 
 	"go.uber.org/zap"
 )
 
+// How long the servers get to close their connections once draining is over
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	if !env.ValidateExpectedEnv() {
 		logging.Fatal("missing expected environment variables")
 	}
+	if err := tracing.InitFromEnv(); err != nil {
+		logging.Fatal("tracing failed to start", zap.Error(err))
+	}
+	defer tracing.Shutdown()
 
 	agent := agent.NewAgent()
 	models.InitDB()
 	defer models.CloseDB()
 
+	health.AddCheck("database", models.Ping)
+	health.AddCheck("websocket", func(ctx context.Context) error {
+		if !agent.Listening() {
+			return errors.New("listener not bound")
+		}
+		return nil
+	})
+
 	go func() {
 		if err := agent.StartGameServer(); err != nil {
 			logging.Fatal("game server failed to start", zap.Error(err))
 		}
 	}()
 
+	RESTPort := env.GetEnv("REST_PORT")
+	restServer := api.NewRESTServer(RESTPort, agent)
 	go func() {
-		RESTPort := env.GetEnv("REST_PORT")
-		if err := api.StartRESTServer(RESTPort, agent); err != nil {
+		logging.Info("rest server started", zap.String("port", RESTPort))
+		if err := restServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
 			logging.Fatal("rest server failed to start", zap.Error(err))
 		}
 	}()
 
-	select {}
+	// On SIGTERM, fail readiness so load balancers stop sending new clients
+	// and refuse new games, then give the games being played DRAIN_TIMEOUT
+	// seconds to finish before shutting both servers down
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	received := <-stop
+	drainTimeout, _ := strconv.Atoi(env.GetEnv("DRAIN_TIMEOUT"))
+	logging.Info("draining",
+		zap.String("signal", received.String()),
+		zap.Int("seconds", drainTimeout),
+		zap.Int("live_sessions", agent.LiveGames()),
+	)
+	health.SetDraining(true)
+	waitForGames(agent, time.Duration(drainTimeout)*time.Second, stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := restServer.Shutdown(ctx); err != nil {
+		logging.Warn("rest server didn't shut down in time", zap.Error(err))
+		restServer.Close()
+	}
+	if err := agent.Shutdown(ctx); err != nil {
+		logging.Warn("game server didn't shut down in time", zap.Error(err))
+	}
+	logging.Info("stopped")
+}
+
+/*
+Wait until no games are being played or saved, the timeout passed or a second signal
+asked to stop at once
+*/
+func waitForGames(a *agent.Agent, timeout time.Duration, stop <-chan os.Signal) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for a.LiveGames() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			logging.Warn("drain timed out, live games are lost", zap.Int("live_sessions", a.LiveGames()))
+			return
+		case <-stop:
+			logging.Info("stopping without draining", zap.Int("live_sessions", a.LiveGames()))
+			return
+		}
+	}
 }
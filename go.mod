@@ -4,6 +4,7 @@ go 1.22.3
 
 require (
 	github.com/go-chi/chi/v5 v5.1.0
+	github.com/go-chi/cors v1.2.1
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/notnil/chess v1.9.0
@@ -12,16 +13,13 @@ require (
 	gorm.io/gorm v1.25.11
 )
 
-require (
-	github.com/go-chi/cors v1.2.1 // indirect
-	golang.org/x/crypto v0.26.0 // indirect
-)
+require golang.org/x/crypto v0.26.0 // indirect
 
 require (
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.5.5 // indirect
+	github.com/jackc/pgx/v5 v5.5.5
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bstchow/go-chess-server/pkg/auth"
+)
+
+/*
+Authenticate the request by its "Authorization: Bearer <token>" header,
+returning the user id. Responds with 401 if it can't.
+*/
+func authenticatedUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		auth.RecordFailure(auth.FailureNoToken)
+		respondWithError(w, http.StatusUnauthorized, "No token provided")
+		return "", false
+	}
+	claims, err := auth.ValidateServerTokenDefault(token)
+	if err != nil {
+		auth.RecordFailure(auth.FailureInvalidToken)
+		respondWithError(w, http.StatusUnauthorized, "Invalid token")
+		return "", false
+	}
+	return claims.UserId, true
+}
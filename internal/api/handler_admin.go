@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/agent"
+	"github.com/bstchow/go-chess-server/pkg/auth"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/go-chi/chi/v5"
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// Admin actions, as recorded in the audit log
+const (
+	adminListSessions    = "list_sessions"
+	adminEndSession      = "end_session"
+	adminViewQueues      = "view_queues"
+	adminListConnections = "list_connections"
+	adminKickConnection  = "kick_connection"
+	adminApplySanction   = "apply_sanction"
+	adminLiftSanction    = "lift_sanction"
+	adminListReports     = "list_reports"
+	adminViewReport      = "view_report"
+	adminResolveReport   = "resolve_report"
+	adminViewAudit       = "view_audit"
+)
+
+type adminContextKey struct{}
+
+/*
+Register the admin API. Every request needs HTTP basic auth with the
+ADMIN_PASSWORD, the user name is recorded as the admin in the audit log.
+The API is off while ADMIN_PASSWORD is unset.
+*/
+func adminRoutes(r chi.Router, a *agent.Agent) {
+	if env.GetEnv("ADMIN_PASSWORD") == "" {
+		logging.Info("admin API disabled, ADMIN_PASSWORD is not set")
+	}
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(adminAuth)
+		r.Get("/sessions", injectHandlerAdminSessions(a))
+		r.Post("/sessions/{id}/end", handlerAdminEndSession)
+		r.Get("/queues", injectHandlerAdminQueues(a))
+		r.Get("/connections", injectHandlerAdminConnections(a))
+		r.Post("/connections/{id}/kick", injectHandlerAdminKick(a))
+		r.Put("/users/{id}/sanctions/{kind}", injectHandlerAdminSanction(a))
+		r.Delete("/users/{id}/sanctions/{kind}", handlerAdminLiftSanction)
+		r.Get("/reports", handlerAdminReports)
+		r.Get("/reports/{id}", handlerAdminReport)
+		r.Post("/reports/{id}/resolve", handlerAdminResolveReport)
+		r.Get("/audit", handlerAdminAudit)
+	})
+}
+
+/*
+Middleware letting only requests with the admin password through, none
+while ADMIN_PASSWORD is unset
+*/
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		password := env.GetEnv("ADMIN_PASSWORD")
+		name, given, ok := r.BasicAuth()
+		if !ok || password == "" || subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+			logging.Info("admin request rejected", zap.String("remote_address", r.RemoteAddr))
+			auth.RecordFailure(auth.FailureAdminCredentials)
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			respondWithError(w, http.StatusUnauthorized, "Invalid admin credentials")
+			return
+		}
+		if name == "" {
+			name = "admin"
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, name)))
+	})
+}
+
+/*
+Record the admin action before it is carried out. Actions that can't be
+recorded aren't carried out, the response is sent and false returned.
+*/
+func audit(w http.ResponseWriter, r *http.Request, action, target string, details interface{}) bool {
+	entry := &models.AdminAudit{
+		Action:     action,
+		Target:     target,
+		RemoteAddr: r.RemoteAddr,
+	}
+	entry.Admin, _ = r.Context().Value(adminContextKey{}).(string)
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't record admin action")
+			return false
+		}
+		entry.Details = string(data)
+	}
+	if err := models.CreateAdminAudit(r.Context(), entry); err != nil {
+		logging.Error("couldn't record admin action", zap.String("action", action), zap.Error(err))
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record admin action")
+		return false
+	}
+	logging.Info("admin action",
+		zap.String("admin", entry.Admin),
+		zap.String("action", action),
+		zap.String("target", target),
+	)
+	return true
+}
+
+type adminPlayerResponse struct {
+	ID     string `json:"id"`
+	Bot    bool   `json:"bot"`
+	ConnID string `json:"conn_id,omitempty"` // Empty while the player is disconnected
+}
+
+func newAdminPlayerResponse(player session.Player) adminPlayerResponse {
+	response := adminPlayerResponse{ID: player.ID, Bot: player.Bot}
+	if player.Conn != nil {
+		response.ConnID = player.ConnID
+	}
+	return response
+}
+
+type adminSessionResponse struct {
+	SessionID   string               `json:"session_id"`
+	White       adminPlayerResponse  `json:"white"`
+	Black       adminPlayerResponse  `json:"black"`
+	TimeControl string               `json:"time_control"`
+	Variant     string               `json:"variant"`
+	Rated       bool                 `json:"rated"`
+	StartedAt   time.Time            `json:"started_at"`
+	Fen         string               `json:"fen"`
+	Moves       int                  `json:"moves"`
+	Clock       *protocol.ClockState `json:"clock,omitempty"`
+}
+
+type adminSessionsResponse struct {
+	Sessions []adminSessionResponse `json:"sessions"`
+}
+
+/*
+HTTP Handler listing the games being played, oldest first
+*/
+func injectHandlerAdminSessions(a *agent.Agent) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !audit(w, r, adminListSessions, "", nil) {
+			return
+		}
+		infos := a.LiveSessions()
+		sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.Before(infos[j].StartedAt) })
+		sessions := make([]adminSessionResponse, len(infos))
+		for i, info := range infos {
+			sessions[i] = adminSessionResponse{
+				SessionID:   info.ID,
+				White:       newAdminPlayerResponse(info.White),
+				Black:       newAdminPlayerResponse(info.Black),
+				TimeControl: info.Pool,
+				Variant:     info.Variant,
+				Rated:       info.Rated,
+				StartedAt:   info.StartedAt,
+				Fen:         info.State.Fen,
+				Moves:       len(info.State.Moves),
+				Clock:       info.Clock,
+			}
+		}
+		respondWithJSON(w, http.StatusOK, adminSessionsResponse{Sessions: sessions})
+	}
+}
+
+type adminEndSessionRequest struct {
+	Result string `json:"result"` // "1-0", "0-1", "1/2-1/2", or "*" to abort the game
+	Reason string `json:"reason"`
+}
+
+/*
+HTTP Handler ending a live game with the result the admin chose. Decisive
+results and draws count like any other finished game, "*" aborts the game.
+*/
+func handlerAdminEndSession(w http.ResponseWriter, r *http.Request) {
+	var req adminEndSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	outcome := chess.Outcome(req.Result)
+	switch outcome {
+	case chess.WhiteWon, chess.BlackWon, chess.Draw, chess.NoOutcome:
+	default:
+		respondWithError(w, http.StatusBadRequest, "Unknown result "+req.Result)
+		return
+	}
+
+	sessionID := chi.URLParam(r, "id")
+	if !audit(w, r, adminEndSession, sessionID, req) {
+		return
+	}
+	err := session.ForceEnd(sessionID, outcome)
+	if errors.Is(err, session.ErrUnknownSession) || errors.Is(err, session.ErrSessionClosed) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, okResponse{Ok: true})
+}
+
+type adminQueuesResponse struct {
+	Queues []adminQueueResponse `json:"queues"`
+}
+
+type adminQueueResponse struct {
+	TimeControl string                `json:"time_control"`
+	HumanOnly   bool                  `json:"human_only"`
+	Players     []adminPlayerResponse `json:"players"`
+}
+
+/*
+HTTP Handler showing who waits for an opponent in each pool
+*/
+func injectHandlerAdminQueues(a *agent.Agent) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !audit(w, r, adminViewQueues, "", nil) {
+			return
+		}
+		queues := a.Queues()
+		response := adminQueuesResponse{Queues: make([]adminQueueResponse, len(queues))}
+		for i, queue := range queues {
+			response.Queues[i] = adminQueueResponse{
+				TimeControl: queue.Pool,
+				HumanOnly:   queue.HumanOnly,
+				Players:     make([]adminPlayerResponse, len(queue.Players)),
+			}
+			for j, player := range queue.Players {
+				response.Queues[i].Players[j] = newAdminPlayerResponse(player)
+			}
+		}
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+type adminConnectionsResponse struct {
+	Connections []agent.Connection `json:"connections"`
+}
+
+/*
+HTTP Handler listing the open websocket connections, oldest first
+*/
+func injectHandlerAdminConnections(a *agent.Agent) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !audit(w, r, adminListConnections, "", nil) {
+			return
+		}
+		connections := a.Connections()
+		sort.Slice(connections, func(i, j int) bool {
+			return connections[i].ConnectedAt.Before(connections[j].ConnectedAt)
+		})
+		respondWithJSON(w, http.StatusOK, adminConnectionsResponse{Connections: connections})
+	}
+}
+
+type adminKickRequest struct {
+	Reason string `json:"reason"`
+}
+
+/*
+HTTP Handler closing a websocket connection. The body with a reason is optional.
+*/
+func injectHandlerAdminKick(a *agent.Agent) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req adminKickRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+		connID := chi.URLParam(r, "id")
+		if !audit(w, r, adminKickConnection, connID, req) {
+			return
+		}
+		if !a.Kick(connID) {
+			respondWithError(w, http.StatusNotFound, "Connection not found")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, okResponse{Ok: true})
+	}
+}
+
+type adminAuditResponse struct {
+	Entries []models.AdminAudit `json:"entries"`
+}
+
+/*
+HTTP Handler for the latest admin actions, newest first
+*/
+func handlerAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit, err := intParam(r, "limit", defaultAuditLimit)
+	if err != nil || limit == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	if !audit(w, r, adminViewAudit, "", nil) {
+		return
+	}
+	entries, err := models.GetAdminAudits(r.Context(), min(limit, maxAuditLimit))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load admin actions")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, adminAuditResponse{Entries: entries})
+}
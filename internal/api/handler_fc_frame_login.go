@@ -3,10 +3,14 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/bstchow/go-chess-server/internal/env"
 	"github.com/bstchow/go-chess-server/internal/id"
+	"github.com/bstchow/go-chess-server/internal/models"
 	"github.com/bstchow/go-chess-server/pkg/auth"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"go.uber.org/zap"
 )
 
 type FcFrameLoginResponse struct {
@@ -38,6 +42,7 @@ func handlerFcFrameLogin(w http.ResponseWriter, r *http.Request) {
 		ok, err := auth.ValidateFrameMessage(params.Message)
 
 		if err != nil || !ok {
+			auth.RecordFailure(auth.FailureInvalidFrame)
 			respondWithError(w, http.StatusUnauthorized, "Invalid Frame Request Signature")
 			return
 		}
@@ -45,6 +50,16 @@ func handlerFcFrameLogin(w http.ResponseWriter, r *http.Request) {
 
 	signingAddress := params.Message.Signer
 	id := id.ConstructId(auth.FC_SIGNER_ADDRESS_USER_ID_SOURCE, signingAddress)
+	// Remember when the user joined
+	user, err := models.FindOrCreateUser(r.Context(), id)
+	if err != nil {
+		logging.Warn("couldn't save user", zap.String("id", id), zap.Error(err))
+	} else if err := user.Restriction(time.Now()); err != nil {
+		// Banned and suspended users don't get tokens
+		auth.RecordFailure(auth.FailureSanctioned)
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	token, err := auth.CreateServerToken(id)
 
 	if err != nil {
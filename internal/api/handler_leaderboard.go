@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/leaderboard"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+	defaultAroundSize      = 5 // Users shown above and below
+	maxAroundSize          = 50
+)
+
+type leaderboardResponse struct {
+	TimeControl string               `json:"time_control"`
+	Users       []leaderboard.Ranked `json:"users"`
+}
+
+type leaderboardsResponse struct {
+	Leaderboards []leaderboardResponse `json:"leaderboards"`
+}
+
+/*
+Read an optional non-negative integer parameter
+*/
+func intParam(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+	return n, nil
+}
+
+/*
+The leaderboard filter from the min_games and inactive_days parameters,
+LEADERBOARD_MIN_GAMES and LEADERBOARD_IDLE_DAYS by default
+*/
+func leaderboardFilter(r *http.Request) (leaderboard.Filter, error) {
+	defaultMinGames, _ := strconv.Atoi(env.GetEnv("LEADERBOARD_MIN_GAMES"))
+	defaultInactiveDays, _ := strconv.Atoi(env.GetEnv("LEADERBOARD_IDLE_DAYS"))
+	minGames, err := intParam(r, "min_games", defaultMinGames)
+	if err != nil {
+		return leaderboard.Filter{}, err
+	}
+	inactiveDays, err := intParam(r, "inactive_days", defaultInactiveDays)
+	if err != nil {
+		return leaderboard.Filter{}, err
+	}
+
+	filter := leaderboard.Filter{MinGames: minGames}
+	// Zero days keeps everyone however long ago they played
+	if inactiveDays > 0 {
+		filter.ActiveSince = time.Now().AddDate(0, 0, -inactiveDays)
+	}
+	return filter, nil
+}
+
+/*
+HTTP Handler for the top users of every pool
+*/
+func injectHandlerLeaderboards(board *leaderboard.Board) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := leaderboardFilter(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response := leaderboardsResponse{Leaderboards: []leaderboardResponse{}}
+		for _, pool := range board.Pools() {
+			response.Leaderboards = append(response.Leaderboards, leaderboardResponse{
+				TimeControl: pool,
+				Users:       board.Top(pool, filter, defaultLeaderboardSize),
+			})
+		}
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+/*
+HTTP Handler for the top users of a pool, as many as the limit parameter asks for
+*/
+func injectHandlerLeaderboard(board *leaderboard.Board) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := leaderboardFilter(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, err := intParam(r, "limit", defaultLeaderboardSize)
+		if err != nil || limit == 0 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+
+		pool := chi.URLParam(r, "pool")
+		respondWithJSON(w, http.StatusOK, leaderboardResponse{
+			TimeControl: pool,
+			Users:       board.Top(pool, filter, min(limit, maxLeaderboardSize)),
+		})
+	}
+}
+
+/*
+HTTP Handler for the authenticated user's place in a pool, with n users
+above and below them
+*/
+func injectHandlerLeaderboardAroundMe(board *leaderboard.Board) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := authenticatedUserID(w, r)
+		if !ok {
+			return
+		}
+		filter, err := leaderboardFilter(r)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		n, err := intParam(r, "n", defaultAroundSize)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		pool := chi.URLParam(r, "pool")
+		users, err := board.Around(pool, userID, filter, min(n, maxAroundSize))
+		if errors.Is(err, leaderboard.ErrNotRanked) {
+			respondWithError(w, http.StatusNotFound, "You aren't ranked in this pool")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, leaderboardResponse{TimeControl: pool, Users: users})
+	}
+}
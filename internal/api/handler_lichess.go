@@ -0,0 +1,277 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/auth"
+	"github.com/bstchow/go-chess-server/pkg/lichess"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Lichess sends an empty line this often so clients can tell the stream is alive
+const streamKeepAlive = 6 * time.Second
+
+/*
+Register the subset of the Lichess Bot and Board APIs that bots need to play,
+so bots written against Lichess can play here. Tokens are server JWTs, sent
+as "Authorization: Bearer <token>". The /api/bot routes are for bot accounts,
+the /api/board routes for everyone else.
+*/
+func lichessRoutes(r chi.Router, hub *lichess.Hub) {
+	r.Get("/api/account", handlerLichessAccount)
+	r.Post("/api/bot/account/upgrade", injectHandlerLichessUpgrade(hub))
+	r.Get("/api/stream/event", injectHandlerLichessEvents(hub))
+
+	for _, api := range []struct {
+		prefix string
+		bot    bool
+	}{{"/api/bot", true}, {"/api/board", false}} {
+		r.Get(api.prefix+"/game/stream/{gameId}", injectHandlerLichessGameStream(hub, api.bot))
+		r.Post(api.prefix+"/game/{gameId}/move/{move}", injectHandlerLichessMove(api.bot))
+		r.Post(api.prefix+"/game/{gameId}/resign", injectHandlerLichessGameAction(api.bot, session.Resign))
+		r.Post(api.prefix+"/game/{gameId}/abort", injectHandlerLichessGameAction(api.bot, session.Abort))
+		r.Post(api.prefix+"/game/{gameId}/chat", injectHandlerLichessChat(api.bot))
+	}
+}
+
+type lichessAccountResponse struct {
+	ID       string  `json:"id"`
+	Username string  `json:"username"`
+	Title    *string `json:"title,omitempty"`
+}
+
+type lichessOkResponse struct {
+	Ok bool `json:"ok"`
+}
+
+/*
+Authenticate the request by its bearer token, returning the account. Users
+that were never saved are humans. Banned and suspended users are refused.
+*/
+func lichessAccount(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return models.User{}, false
+	}
+	user, err := models.GetUserById(r.Context(), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{Id: userID}, true
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load account")
+		return models.User{}, false
+	}
+	if err := user.Restriction(time.Now()); err != nil {
+		auth.RecordFailure(auth.FailureSanctioned)
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return models.User{}, false
+	}
+	return user, true
+}
+
+/*
+Authenticate the request for the bot API when bot is set, or the board API
+*/
+func lichessPlayer(w http.ResponseWriter, r *http.Request, bot bool) (models.User, bool) {
+	user, ok := lichessAccount(w, r)
+	if !ok {
+		return user, false
+	}
+	if user.Bot != bot {
+		if bot {
+			respondWithError(w, http.StatusUnauthorized, "This endpoint can only be used with a Bot account")
+		} else {
+			respondWithError(w, http.StatusUnauthorized, "This endpoint can't be used with a Bot account")
+		}
+		return user, false
+	}
+	return user, true
+}
+
+func handlerLichessAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := lichessAccount(w, r)
+	if !ok {
+		return
+	}
+	response := lichessAccountResponse{ID: user.Id, Username: user.Id}
+	if user.Bot {
+		title := "BOT"
+		response.Title = &title
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+/*
+HTTP Handler turning the account into a bot account. Like on Lichess, only
+accounts without any games can be upgraded.
+*/
+func injectHandlerLichessUpgrade(hub *lichess.Hub) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessAccount(w, r)
+		if !ok {
+			return
+		}
+		if user.Bot {
+			respondWithJSON(w, http.StatusOK, lichessOkResponse{Ok: true})
+			return
+		}
+		games, err := models.QuerySessions(r.Context(), models.SessionQuery{PlayerID: user.Id, Limit: 1})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't load games")
+			return
+		}
+		if len(games.Sessions) > 0 || hub.Playing(user.Id) {
+			respondWithError(w, http.StatusBadRequest, "Accounts that have played games can't become bots")
+			return
+		}
+		if _, err := models.UpgradeToBot(r.Context(), user.Id); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't upgrade account")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, lichessOkResponse{Ok: true})
+	}
+}
+
+/*
+HTTP Handler streaming the account's game starts and finishes as NDJSON. The
+account is matched with opponents from the "pool" query parameter, or the
+default pool, for as long as the stream is open.
+*/
+func injectHandlerLichessEvents(hub *lichess.Hub) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessAccount(w, r)
+		if !ok {
+			return
+		}
+		lines, stop, err := hub.StreamEvents(user.Id, user.Bot, r.URL.Query().Get("pool"))
+		if err != nil {
+			respondWithError(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer stop()
+		streamNDJSON(w, r, nil, lines)
+	}
+}
+
+func injectHandlerLichessGameStream(hub *lichess.Hub, bot bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessPlayer(w, r, bot)
+		if !ok {
+			return
+		}
+		full, lines, stop, err := hub.StreamGame(user.Id, user.Bot, chi.URLParam(r, "gameId"))
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		defer stop()
+		streamNDJSON(w, r, full, lines)
+	}
+}
+
+/*
+Write the first line and then every line from lines until it's closed or the
+client goes away, keeping the connection alive in between
+*/
+func streamNDJSON(w http.ResponseWriter, r *http.Request, first []byte, lines <-chan []byte) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	if first != nil {
+		w.Write(first)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func injectHandlerLichessMove(bot bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessPlayer(w, r, bot)
+		if !ok {
+			return
+		}
+		gameID := chi.URLParam(r, "gameId")
+		err := session.SubmitMove(gameID, user.Id, session.MoveSubmission{
+			Move:     chi.URLParam(r, "move"),
+			Notation: protocol.NotationUCI,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if offer := r.URL.Query().Get("offeringDraw"); offer == "true" {
+			session.Draw(gameID, user.Id, protocol.DrawOffer)
+		}
+		respondWithJSON(w, http.StatusOK, lichessOkResponse{Ok: true})
+	}
+}
+
+func injectHandlerLichessGameAction(bot bool, action func(sessionID, playerID string) error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessPlayer(w, r, bot)
+		if !ok {
+			return
+		}
+		if err := action(chi.URLParam(r, "gameId"), user.Id); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, lichessOkResponse{Ok: true})
+	}
+}
+
+/*
+HTTP Handler for chat messages, posted as a form with "room" and "text" like
+on Lichess. Only the player room exists here.
+*/
+func injectHandlerLichessChat(bot bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := lichessPlayer(w, r, bot)
+		if !ok {
+			return
+		}
+		if room := r.FormValue("room"); room != "player" {
+			respondWithError(w, http.StatusBadRequest, "Unknown chat room "+room)
+			return
+		}
+		if err := user.ChatRestriction(time.Now()); err != nil {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err := session.Chat(chi.URLParam(r, "gameId"), user.Id, r.FormValue("text")); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, lichessOkResponse{Ok: true})
+	}
+}
@@ -3,10 +3,14 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/bstchow/go-chess-server/internal/env"
 	"github.com/bstchow/go-chess-server/internal/id"
+	"github.com/bstchow/go-chess-server/internal/models"
 	"github.com/bstchow/go-chess-server/pkg/auth"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"go.uber.org/zap"
 )
 
 type PrivyLoginResponse struct {
@@ -34,6 +38,7 @@ func handlerPrivyLogin(w http.ResponseWriter, r *http.Request) {
 	if env.GetEnv("VALIDATE_PRIVY_JWT") == "true" {
 		claims, err := auth.PrivyAppValidateToken(params.PrivyJWTToken)
 		if err != nil {
+			auth.RecordFailure(auth.FailureInvalidPrivyJWT)
 			respondWithError(w, http.StatusUnauthorized, "Invalid Privy JWT")
 			return
 		}
@@ -43,6 +48,16 @@ func handlerPrivyLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userId := id.ConstructId(auth.PRIVY_DID_USER_ID_SOURCE, userPrivyDid)
+	// Remember when the user joined
+	user, err := models.FindOrCreateUser(r.Context(), userId)
+	if err != nil {
+		logging.Warn("couldn't save user", zap.String("id", userId), zap.Error(err))
+	} else if err := user.Restriction(time.Now()); err != nil {
+		// Banned and suspended users don't get tokens
+		auth.RecordFailure(auth.FailureSanctioned)
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	token, err := auth.CreateServerToken(userId)
 
 	if err != nil {
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/leaderboard"
+)
+
+type peakResponse struct {
+	Rating    int       `json:"rating"`
+	SessionID string    `json:"session_id"`
+	At        time.Time `json:"at"`
+}
+
+type poolHistoryResponse struct {
+	TimeControl string               `json:"time_control"`
+	Rating      int                  `json:"rating"`
+	Games       int                  `json:"games"`
+	Peak        peakResponse         `json:"peak"`
+	Percentile  *float64             `json:"percentile,omitempty"` // Of users rated lower, bots aren't ranked
+	History     []models.RatingPoint `json:"history"`
+}
+
+type ratingHistoryResponse struct {
+	ID       string                `json:"id"`
+	Interval string                `json:"interval"`
+	Pools    []poolHistoryResponse `json:"pools"`
+}
+
+/*
+HTTP Handler for a user's rating history in each pool, or the one in the pool
+parameter, with a point per day, week or month as the interval parameter says
+*/
+func injectHandlerRatingHistory(board *leaderboard.Board) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := loadUser(w, r)
+		if !ok {
+			return
+		}
+		interval := r.URL.Query().Get("interval")
+		if interval == "" {
+			interval = "day"
+		}
+		if !models.ValidRatingInterval(interval) {
+			respondWithError(w, http.StatusBadRequest, models.ErrInvalidInterval.Error())
+			return
+		}
+		ratings, err := models.GetRatings(r.Context(), user.Id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't load ratings")
+			return
+		}
+
+		response := ratingHistoryResponse{ID: user.Id, Interval: interval, Pools: []poolHistoryResponse{}}
+		for _, rating := range ratings {
+			if pool := r.URL.Query().Get("pool"); pool != "" && pool != rating.Pool {
+				continue
+			}
+			history, err := models.GetRatingHistory(r.Context(), user.Id, rating.Pool, interval)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't load rating history")
+				return
+			}
+			peak, err := models.GetPeakRating(r.Context(), user.Id, rating.Pool)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't load peak rating")
+				return
+			}
+
+			pool := poolHistoryResponse{
+				TimeControl: rating.Pool,
+				Rating:      rating.Rating,
+				Games:       rating.Games,
+				Peak: peakResponse{
+					Rating:    peak.Rating,
+					SessionID: peak.SessionID,
+					At:        peak.CreatedAt,
+				},
+				History: history,
+			}
+			if percentile, ok := board.Percentile(rating.Pool, user.Id); ok {
+				pool.Percentile = &percentile
+			}
+			response.Pools = append(response.Pools, pool)
+		}
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	maxReportLength    = 2000
+	defaultReportLimit = 20
+	maxReportLimit     = 100
+	fairPlayHistory    = 5 // Fair-play reviews of the reported user shown with a report
+)
+
+type createReportRequest struct {
+	SessionID string `json:"session_id"`
+	Category  string `json:"category"`
+	Text      string `json:"text"`
+}
+
+/*
+A report as its reporter sees it
+*/
+type ownReportResponse struct {
+	ID         uint       `json:"id"`
+	SessionID  string     `json:"session_id"`
+	ReportedID string     `json:"reported_id"`
+	Category   string     `json:"category"`
+	Text       string     `json:"text"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+type ownReportsResponse struct {
+	Reports []ownReportResponse `json:"reports"`
+}
+
+func newOwnReportResponse(report models.Report) ownReportResponse {
+	return ownReportResponse{
+		ID:         report.ID,
+		SessionID:  report.SessionID,
+		ReportedID: report.ReportedID,
+		Category:   report.Category,
+		Text:       report.Text,
+		Status:     report.Status,
+		Resolution: report.Resolution,
+		CreatedAt:  report.CreatedAt,
+		ResolvedAt: report.ResolvedAt,
+	}
+}
+
+/*
+A reported game with what moderators need to judge it
+*/
+type reportGameResponse struct {
+	gameResponse
+	Live       bool                 `json:"live"`
+	MoveTimes  []int64              `json:"move_times"`     // Unix milliseconds each move was played at
+	ThinkTimes []int64              `json:"think_times_ms"` // Time taken for each move
+	Chat       []models.ChatMessage `json:"chat"`
+}
+
+/*
+Milliseconds between each move and the one before, or the start of the game
+for the first move
+*/
+func thinkTimes(startedAt int64, moveTimes []int64) []int64 {
+	times := make([]int64, len(moveTimes))
+	last := startedAt
+	for i, at := range moveTimes {
+		times[i] = at - last
+		last = at
+	}
+	return times
+}
+
+/*
+The reported game, live or saved, nil if there's no such game
+*/
+func loadReportGame(ctx context.Context, sessionID string) (*reportGameResponse, error) {
+	if info, err := session.GetInfo(sessionID); err == nil {
+		game := &reportGameResponse{
+			gameResponse: gameResponse{
+				SessionID:   info.ID,
+				White:       gamePlayerResponse{ID: info.White.ID},
+				Black:       gamePlayerResponse{ID: info.Black.ID},
+				Moves:       make([]string, len(info.State.Moves)),
+				Outcome:     info.State.Outcome,
+				Variant:     info.Variant,
+				TimeControl: info.Pool,
+				Rated:       info.Rated,
+				StartedAt:   info.StartedAt,
+			},
+			Live:      true,
+			MoveTimes: make([]int64, len(info.MoveTimes)),
+			Chat:      make([]models.ChatMessage, len(info.Chat)),
+		}
+		for i, move := range info.State.Moves {
+			game.Moves[i] = move.Uci
+		}
+		for i, at := range info.MoveTimes {
+			game.MoveTimes[i] = at.UnixMilli()
+		}
+		for i, message := range info.Chat {
+			game.Chat[i] = models.ChatMessage{UserID: message.From, Text: message.Text, SentAt: time.UnixMilli(message.SentAt)}
+		}
+		game.ThinkTimes = thinkTimes(info.StartedAt.UnixMilli(), game.MoveTimes)
+		return game, nil
+	}
+
+	saved, err := models.GetSessionByID(ctx, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	chat, err := models.GetChatMessages(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	game := &reportGameResponse{
+		gameResponse: newGameResponse(saved),
+		MoveTimes:    saved.MoveTimes,
+		ThinkTimes:   thinkTimes(saved.StartedAt.UnixMilli(), saved.MoveTimes),
+		Chat:         chat,
+	}
+	if game.MoveTimes == nil {
+		game.MoveTimes = []int64{}
+	}
+	return game, nil
+}
+
+/*
+HTTP Handler for a player reporting their opponent in a live or finished game
+*/
+func handlerCreateReport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	var req createReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if !models.ValidReportCategory(req.Category) {
+		respondWithError(w, http.StatusBadRequest, "Unknown category "+req.Category)
+		return
+	}
+	if len(req.Text) > maxReportLength {
+		respondWithError(w, http.StatusBadRequest, "Report text too long")
+		return
+	}
+
+	game, err := loadReportGame(r.Context(), req.SessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session")
+		return
+	}
+	if game == nil {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	var reportedID string
+	switch userID {
+	case game.White.ID:
+		reportedID = game.Black.ID
+	case game.Black.ID:
+		reportedID = game.White.ID
+	default:
+		respondWithError(w, http.StatusForbidden, "Only players of a game can report it")
+		return
+	}
+
+	report := models.Report{
+		ReporterID: userID,
+		SessionID:  req.SessionID,
+		ReportedID: reportedID,
+		Category:   req.Category,
+		Text:       req.Text,
+	}
+	err = models.CreateReport(r.Context(), &report)
+	if errors.Is(err, models.ErrDuplicateReport) {
+		respondWithError(w, http.StatusConflict, "You already reported this game")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save report")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, newOwnReportResponse(report))
+}
+
+/*
+HTTP Handler for the reports the authenticated user filed and how they were resolved
+*/
+func handlerOwnReports(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	limit, err := intParam(r, "limit", defaultReportLimit)
+	if err != nil || limit == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	reports, err := models.GetReportsByReporter(r.Context(), userID, min(limit, maxReportLimit))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load reports")
+		return
+	}
+	response := ownReportsResponse{Reports: make([]ownReportResponse, len(reports))}
+	for i, report := range reports {
+		response.Reports[i] = newOwnReportResponse(report)
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+type adminReportsResponse struct {
+	Reports []models.Report `json:"reports"`
+}
+
+/*
+HTTP Handler for the moderation queue, oldest report first. Open reports are
+listed unless another status is asked for.
+*/
+func handlerAdminReports(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	status := params.Get("status")
+	if status == "" {
+		status = models.ReportOpen
+	}
+	category := params.Get("category")
+	if category != "" && !models.ValidReportCategory(category) {
+		respondWithError(w, http.StatusBadRequest, "Unknown category "+category)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultReportLimit)
+	if err != nil || limit == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+	if !audit(w, r, adminListReports, "", map[string]string{"status": status, "category": category}) {
+		return
+	}
+	reports, err := models.GetReports(r.Context(), status, category, min(limit, maxReportLimit))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load reports")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, adminReportsResponse{Reports: reports})
+}
+
+type adminReportResponse struct {
+	Report          models.Report           `json:"report"`
+	Game            *reportGameResponse     `json:"game"`                 // Nil if the game is gone
+	OpenReports     int64                   `json:"open_reports_against"` // Including this one while it's open
+	FairPlayReviews []models.FairPlayReview `json:"fair_play_reviews"`    // The reported user's latest
+}
+
+/*
+The report id in the id parameter, responding with 404 if it isn't one
+*/
+func reportIDParam(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Report not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+/*
+HTTP Handler for a report with the game, its chat and move timings, and the
+reported user's record
+*/
+func handlerAdminReport(w http.ResponseWriter, r *http.Request) {
+	id, ok := reportIDParam(w, r)
+	if !ok {
+		return
+	}
+	if !audit(w, r, adminViewReport, chi.URLParam(r, "id"), nil) {
+		return
+	}
+	report, err := models.GetReport(r.Context(), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load report")
+		return
+	}
+
+	response := adminReportResponse{Report: report}
+	if response.Game, err = loadReportGame(r.Context(), report.SessionID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session")
+		return
+	}
+	if response.OpenReports, err = models.CountOpenReports(r.Context(), report.ReportedID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count reports")
+		return
+	}
+	if response.FairPlayReviews, err = models.GetUserFairPlayReviews(r.Context(), report.ReportedID, fairPlayHistory); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load fair-play reviews")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+type resolveReportRequest struct {
+	Status     string `json:"status"`     // models.ReportActioned or models.ReportDismissed
+	Resolution string `json:"resolution"` // Shown to the reporter
+}
+
+/*
+HTTP Handler closing an open report. Sanctions are applied separately.
+*/
+func handlerAdminResolveReport(w http.ResponseWriter, r *http.Request) {
+	id, ok := reportIDParam(w, r)
+	if !ok {
+		return
+	}
+	var req resolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Status != models.ReportActioned && req.Status != models.ReportDismissed {
+		respondWithError(w, http.StatusBadRequest, "Unknown status "+req.Status)
+		return
+	}
+	if !audit(w, r, adminResolveReport, chi.URLParam(r, "id"), req) {
+		return
+	}
+	admin, _ := r.Context().Value(adminContextKey{}).(string)
+	report, err := models.ResolveReport(r.Context(), id, req.Status, req.Resolution, admin)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(w, http.StatusNotFound, "Report not found")
+		return
+	}
+	if errors.Is(err, models.ErrReportResolved) {
+		respondWithError(w, http.StatusConflict, "Report already resolved")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve report")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, report)
+}
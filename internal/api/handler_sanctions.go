@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/agent"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+/*
+The sanctions in effect on a user, nil for the kinds that aren't
+*/
+type sanctionsResponse struct {
+	ID         string           `json:"id"`
+	Ban        *models.Sanction `json:"ban"`
+	Suspension *models.Sanction `json:"suspension"`
+	Mute       *models.Sanction `json:"mute"`
+}
+
+func newSanctionsResponse(user models.User) sanctionsResponse {
+	now := time.Now()
+	response := sanctionsResponse{ID: user.Id}
+	for _, s := range []struct {
+		sanction models.Sanction
+		field    **models.Sanction
+	}{
+		{user.Ban, &response.Ban},
+		{user.Suspension, &response.Suspension},
+		{user.Mute, &response.Mute},
+	} {
+		if s.sanction.InEffect(now) {
+			sanction := s.sanction
+			*s.field = &sanction
+		}
+	}
+	return response
+}
+
+/*
+HTTP Handler for the sanctions in effect on the authenticated user
+*/
+func handlerOwnSanctions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	user, err := models.GetUserById(r.Context(), userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{Id: userID}
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load user")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newSanctionsResponse(user))
+}
+
+type sanctionRequest struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+/*
+HTTP Handler putting a ban, suspension or mute on a user. A ban or
+suspension takes effect right away: the user's game in progress is resigned
+and their connections are closed.
+*/
+func injectHandlerAdminSanction(a *agent.Agent) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req sanctionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Reason == "" {
+			respondWithError(w, http.StatusBadRequest, "A reason is required")
+			return
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "The expiry must be in the future")
+			return
+		}
+
+		userID, kind := chi.URLParam(r, "id"), chi.URLParam(r, "kind")
+		if !models.ValidSanctionKind(kind) {
+			respondWithError(w, http.StatusBadRequest, "Unknown sanction "+kind)
+			return
+		}
+		if !audit(w, r, adminApplySanction, userID, struct {
+			Kind string `json:"kind"`
+			sanctionRequest
+		}{kind, req}) {
+			return
+		}
+		admin, _ := r.Context().Value(adminContextKey{}).(string)
+		user, err := models.SetSanction(r.Context(), userID, kind, models.Sanction{
+			Reason:    req.Reason,
+			ExpiresAt: req.ExpiresAt,
+			By:        admin,
+		})
+		if errors.Is(err, models.ErrInvalidSanction) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save sanction")
+			return
+		}
+		if kind != models.SanctionMute {
+			a.EnforceRestriction(userID)
+		}
+		respondWithJSON(w, http.StatusOK, newSanctionsResponse(user))
+	}
+}
+
+type liftSanctionRequest struct {
+	Reason string `json:"reason"`
+}
+
+/*
+HTTP Handler lifting a sanction from a user. The body with a reason is optional.
+*/
+func handlerAdminLiftSanction(w http.ResponseWriter, r *http.Request) {
+	var req liftSanctionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	userID, kind := chi.URLParam(r, "id"), chi.URLParam(r, "kind")
+	if !models.ValidSanctionKind(kind) {
+		respondWithError(w, http.StatusBadRequest, "Unknown sanction "+kind)
+		return
+	}
+	if !audit(w, r, adminLiftSanction, userID, struct {
+		Kind string `json:"kind"`
+		liftSanctionRequest
+	}{kind, req}) {
+		return
+	}
+	user, err := models.LiftSanction(r.Context(), userID, kind)
+	if errors.Is(err, models.ErrInvalidSanction) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lift sanction")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newSanctionsResponse(user))
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/analysis"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+/*
+HTTP Handler for the engine analysis of a saved session. Responds with 202
+while the analysis is still queued or running.
+*/
+func handlerSessionAnalysis(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+	result, err := models.GetAnalysisBySessionID(r.Context(), sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(w, http.StatusNotFound, "No analysis for this session")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load analysis")
+		return
+	}
+
+	code := http.StatusOK
+	if result.Status == models.AnalysisPending {
+		code = http.StatusAccepted
+	}
+	respondWithJSON(w, code, result)
+}
+
+/*
+HTTP Handler for the PGN export of a saved session, with the analysis as
+comments once it's done
+*/
+func handlerSessionPGN(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+	session, err := models.GetSessionByID(r.Context(), sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session")
+		return
+	}
+
+	var gameAnalysis *models.Analysis
+	if result, err := models.GetAnalysisBySessionID(r.Context(), sessionID); err == nil {
+		gameAnalysis = &result
+	}
+	pgn, err := analysis.PGN(session, gameAnalysis)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't export session")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-chess-pgn")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+sessionID+`.pgn"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(pgn))
+}
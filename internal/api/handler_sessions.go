@@ -0,0 +1,165 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type gamePlayerResponse struct {
+	ID         string `json:"id"`
+	Rating     *int   `json:"rating,omitempty"` // Before the game, only for rated games
+	RatingDiff *int   `json:"rating_diff,omitempty"`
+}
+
+type gameResponse struct {
+	SessionID   string             `json:"session_id"`
+	White       gamePlayerResponse `json:"white"`
+	Black       gamePlayerResponse `json:"black"`
+	Moves       []string           `json:"moves"`
+	Outcome     string             `json:"outcome"`
+	Method      string             `json:"method"`
+	Variant     string             `json:"variant"`
+	TimeControl string             `json:"time_control"`
+	ECO         string             `json:"eco,omitempty"`
+	Opening     string             `json:"opening,omitempty"`
+	Rated       bool               `json:"rated"`
+	StartedAt   time.Time          `json:"started_at"`
+	EndedAt     time.Time          `json:"ended_at"`
+}
+
+type gamesResponse struct {
+	Games      []gameResponse `json:"games"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func newGameResponse(s models.Session) gameResponse {
+	game := gameResponse{
+		SessionID:   s.SessionID,
+		White:       gamePlayerResponse{ID: s.Player1ID},
+		Black:       gamePlayerResponse{ID: s.Player2ID},
+		Moves:       s.Moves,
+		Outcome:     s.Outcome,
+		Method:      s.Method,
+		Variant:     s.Variant,
+		TimeControl: s.Pool,
+		ECO:         s.ECO,
+		Opening:     s.Opening,
+		Rated:       s.Rated,
+		StartedAt:   s.StartedAt,
+		EndedAt:     s.CreatedAt,
+	}
+	if game.Moves == nil {
+		game.Moves = []string{}
+	}
+	if s.Rated {
+		game.White.Rating, game.White.RatingDiff = &s.WhiteRating, &s.WhiteRatingDiff
+		game.Black.Rating, game.Black.RatingDiff = &s.BlackRating, &s.BlackRatingDiff
+	}
+	return game
+}
+
+/*
+Parse a date filter, either a day as 2006-01-02 or an RFC 3339 time. Days
+given as an upper bound include the whole day.
+*/
+func parseDateParam(value string, until bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		if until {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+/*
+HTTP Handler for the game history of the authenticated user, or of the user
+in the player parameter. Newest games come first, the next_cursor of a page
+is passed as the cursor parameter for the next one.
+*/
+func handlerSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+
+	q := models.SessionQuery{
+		PlayerID:    userID,
+		OpponentID:  params.Get("opponent"),
+		Result:      params.Get("result"),
+		Color:       params.Get("color"),
+		Variant:     params.Get("variant"),
+		TimeControl: params.Get("time_control"),
+		Cursor:      params.Get("cursor"),
+	}
+	if player := params.Get("player"); player != "" {
+		q.PlayerID = player
+	}
+	var err error
+	if limit := params.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+	if q.Since, err = parseDateParam(params.Get("since"), false); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid since date")
+		return
+	}
+	if q.Until, err = parseDateParam(params.Get("until"), true); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid until date")
+		return
+	}
+
+	page, err := models.QuerySessions(r.Context(), q)
+	if errors.Is(err, models.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if errors.Is(err, models.ErrInvalidQuery) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load games")
+		return
+	}
+
+	games := make([]gameResponse, len(page.Sessions))
+	for i, s := range page.Sessions {
+		games[i] = newGameResponse(s)
+	}
+	respondWithJSON(w, http.StatusOK, gamesResponse{
+		Games:      games,
+		NextCursor: page.NextCursor,
+	})
+}
+
+/*
+HTTP Handler for a single saved game
+*/
+func handlerSession(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticatedUserID(w, r); !ok {
+		return
+	}
+	session, err := models.GetSessionByID(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load session")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newGameResponse(session))
+}
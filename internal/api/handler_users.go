@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Openings listed per color in a user's stats
+const favouriteOpenings = 5
+
+var displayNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,30}$`)
+
+type ratingResponse struct {
+	TimeControl  string    `json:"time_control"`
+	Rating       int       `json:"rating"`
+	Games        int       `json:"games"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+type userResponse struct {
+	ID          string           `json:"id"`
+	DisplayName string           `json:"display_name"`
+	Bot         bool             `json:"bot"`
+	JoinedAt    time.Time        `json:"joined_at"`
+	Ratings     []ratingResponse `json:"ratings"`
+}
+
+type streaksResponse struct {
+	Current     int `json:"current"` // Wins in a row when positive, losses when negative
+	LongestWin  int `json:"longest_win"`
+	LongestLoss int `json:"longest_loss"`
+}
+
+type openingsResponse struct {
+	White []models.UserOpening `json:"white"`
+	Black []models.UserOpening `json:"black"`
+}
+
+type userStatsResponse struct {
+	ID                string           `json:"id"`
+	Overall           models.Record    `json:"overall"`
+	White             models.Record    `json:"white"`
+	Black             models.Record    `json:"black"`
+	Streaks           streaksResponse  `json:"streaks"`
+	FavouriteOpenings openingsResponse `json:"favourite_openings"`
+	RecentForm        string           `json:"recent_form"`
+	LastGameAt        *time.Time       `json:"last_game_at,omitempty"`
+}
+
+/*
+Load the user in the id parameter, responding with 404 if there's no such user
+*/
+func loadUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, err := models.GetUserById(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return user, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load user")
+		return user, false
+	}
+	return user, true
+}
+
+func newUserResponse(ctx context.Context, user models.User) (userResponse, error) {
+	ratings, err := models.GetRatings(ctx, user.Id)
+	if err != nil {
+		return userResponse{}, err
+	}
+
+	response := userResponse{
+		ID:          user.Id,
+		DisplayName: user.Name(),
+		Bot:         user.Bot,
+		JoinedAt:    user.CreatedAt,
+		Ratings:     make([]ratingResponse, len(ratings)),
+	}
+	for i, rating := range ratings {
+		response.Ratings[i] = ratingResponse{
+			TimeControl:  rating.Pool,
+			Rating:       rating.Rating,
+			Games:        rating.Games,
+			LastPlayedAt: rating.LastPlayedAt,
+		}
+	}
+	return response, nil
+}
+
+/*
+HTTP Handler for a user's profile with their current rating in each time control
+*/
+func handlerUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadUser(w, r)
+	if !ok {
+		return
+	}
+	response, err := newUserResponse(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load ratings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+/*
+HTTP Handler for a user's results, streaks, favourite openings and recent form
+*/
+func handlerUserStats(w http.ResponseWriter, r *http.Request) {
+	user, ok := loadUser(w, r)
+	if !ok {
+		return
+	}
+	stats, err := models.GetUserStats(r.Context(), user.Id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load stats")
+		return
+	}
+	white, err := models.GetFavouriteOpenings(r.Context(), user.Id, models.ColorWhite, favouriteOpenings)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load openings")
+		return
+	}
+	black, err := models.GetFavouriteOpenings(r.Context(), user.Id, models.ColorBlack, favouriteOpenings)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load openings")
+		return
+	}
+
+	response := userStatsResponse{
+		ID:      user.Id,
+		Overall: stats.Overall,
+		White:   stats.White,
+		Black:   stats.Black,
+		Streaks: streaksResponse{
+			Current:     stats.CurrentStreak,
+			LongestWin:  stats.LongestWinStreak,
+			LongestLoss: stats.LongestLossStreak,
+		},
+		FavouriteOpenings: openingsResponse{White: white, Black: black},
+		RecentForm:        stats.RecentForm,
+	}
+	if !stats.LastGameAt.IsZero() {
+		response.LastGameAt = &stats.LastGameAt
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+/*
+HTTP Handler for the authenticated user to pick their display name
+*/
+func handlerUpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticatedUserID(w, r)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		DisplayName string `json:"display_name"`
+	}
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if !displayNamePattern.MatchString(params.DisplayName) {
+		respondWithError(w, http.StatusBadRequest, "Display names are 3 to 30 letters, digits, _ or -")
+		return
+	}
+
+	user, err := models.SetDisplayName(r.Context(), userID, params.DisplayName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
+		return
+	}
+	response, err := newUserResponse(r.Context(), user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load ratings")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
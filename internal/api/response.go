@@ -6,6 +6,10 @@ import (
 	"net/http"
 )
 
+type okResponse struct {
+	Ok bool `json:"ok"`
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	if code > 499 {
 		log.Printf("Responding with 5XX error: %s", msg)
@@ -4,20 +4,22 @@ import (
 	"net/http"
 
 	"github.com/bstchow/go-chess-server/pkg/agent"
-	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/health"
+	"github.com/bstchow/go-chess-server/pkg/lichess"
+	"github.com/bstchow/go-chess-server/pkg/metrics"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"go.uber.org/zap"
 )
 
-// Start REST server
-func StartRESTServer(port string, agent *agent.Agent) error {
+// Create the REST server, started with ListenAndServe and stopped with Shutdown
+func NewRESTServer(port string, agent *agent.Agent) *http.Server {
 
 	r := chi.NewRouter()
 
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
+	r.Use(traceRequests)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -35,7 +37,25 @@ func StartRESTServer(port string, agent *agent.Agent) error {
 	r.Post("/api/privyLogin", handlerPrivyLogin)
 	r.Post("/api/fcFrameLogin", handlerFcFrameLogin)
 	r.Get("/api/sessionCount", injectHandlerSessionCount(agent))
-	logging.Info("rest server started", zap.String("port", port))
-
-	return http.ListenAndServe(":"+port, r)
+	r.Put("/api/users/me", handlerUpdateUser)
+	r.Get("/api/users/me/sanctions", handlerOwnSanctions)
+	r.Get("/api/users/{id}", handlerUser)
+	r.Get("/api/users/{id}/stats", handlerUserStats)
+	r.Get("/api/users/{id}/rating-history", injectHandlerRatingHistory(agent.Leaderboard()))
+	r.Get("/api/leaderboards", injectHandlerLeaderboards(agent.Leaderboard()))
+	r.Get("/api/leaderboards/{pool}", injectHandlerLeaderboard(agent.Leaderboard()))
+	r.Get("/api/leaderboards/{pool}/around-me", injectHandlerLeaderboardAroundMe(agent.Leaderboard()))
+	r.Post("/api/reports", handlerCreateReport)
+	r.Get("/api/reports", handlerOwnReports)
+	r.Get("/api/sessions", handlerSessions)
+	r.Get("/api/sessions/{id}", handlerSession)
+	r.Get("/api/sessions/{id}/analysis", handlerSessionAnalysis)
+	r.Get("/api/sessions/{id}/pgn", handlerSessionPGN)
+	lichessRoutes(r, lichess.NewHub(agent))
+	adminRoutes(r, agent)
+	r.Handle("/metrics", metrics.Handler())
+	r.Handle("/healthz", health.LiveHandler())
+	r.Handle("/readyz", health.ReadyHandler())
+
+	return &http.Server{Addr: ":" + port, Handler: r}
 }
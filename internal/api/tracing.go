@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+/*
+Middleware handling each request in a span named after its route, which
+handlers can continue with the request context
+*/
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), r.Method+" "+r.URL.Path,
+			tracing.String("transport", "http"),
+			tracing.String("http.method", r.Method),
+			tracing.String("http.target", r.URL.Path),
+			tracing.String("remote_address", r.RemoteAddr),
+			tracing.String("request_id", middleware.GetReqID(r.Context())),
+		)
+		defer span.End()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil && routeContext.RoutePattern() != "" {
+			span.SetName(r.Method + " " + routeContext.RoutePattern())
+		}
+		span.SetAttributes(tracing.Int("http.status_code", ww.Status()))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetAttributes(tracing.Bool("error", true))
+		}
+	})
+}
@@ -15,8 +15,29 @@ var EXPECTED_ENV = map[string](EnvValue){
 	"ENV":                    {"string", "production"},
 	"WS_PORT":                {"int", "7201"},
 	"REST_PORT":              {"int", "7202"},
-	"ADMIN_PASSWORD":         {"string", "123"},
+	"DRAIN_TIMEOUT":          {"int", "600"},
+	"WS_SEND_BUFFER":         {"int", "64"}, // Outbound messages queued per connection before it is dropped
+	"WS_WRITE_TIMEOUT":       {"int", "10"}, // Seconds
+	"WS_PING_INTERVAL":       {"int", "20"}, // Seconds between keepalive pings
+	"WS_PONG_TIMEOUT":        {"int", "45"}, // Seconds without a pong before a connection is considered dead
+	"ADMIN_PASSWORD":         {"string", ""},
 	"MATCHING_TIMEOUT":       {"int", "3600"}, // 1 Hour timeout for matchmaking
+	"SESSION_EVENT_LOG_SIZE": {"int", "256"},  // Events retained per session for replay to reconnecting players
+	"TIME_CONTROL":           {"string", ""},  // "<minutes>+<increment seconds>", empty for untimed games
+	"MATCH_POOLS":            {"string", ""},  // Time controls to queue for, e.g. "5+3,10+5/humans", empty for TIME_CONTROL only
+	"BOT_LEVEL":              {"int", "3"},    // Strength of bot opponents, 1 to 5
+	"BOT_FALLBACK_WAIT":      {"int", "0"},    // Seconds in the queue before a bot is matched instead, 0 never does
+	"ENGINE_PATH":            {"string", ""},  // UCI engine binary for bots, empty to use the built-in engine
+	"ENGINE_POOL_SIZE":       {"int", "2"},    // Engine processes run at most at once
+	"ANALYSIS_WORKERS":       {"int", "1"},    // Games analysed at once, each on its own engine process
+	"ANALYSIS_QUEUE_SIZE":    {"int", "100"},  // Finished games waiting for analysis before new ones are skipped
+	"ANALYSIS_MAX_ATTEMPTS":  {"int", "3"},
+	"ANALYSIS_DEPTH":         {"int", "14"},
+	"ANALYSIS_MOVE_TIME":     {"int", "1000"}, // Milliseconds per position at most
+	"LEADERBOARD_MIN_GAMES":  {"int", "10"},   // Rated games in a pool before a user is ranked in it
+	"LEADERBOARD_IDLE_DAYS":  {"int", "30"},   // Days without a rated game in a pool before a user drops out of its ranking
+	"TRACE_EXPORTER":         {"string", ""},
+	"TRACE_FILE":             {"string", "traces.jsonl"},
 	"DATABASE_USER":          {"string", "postgres"},
 	"DATABASE_PASSWORD":      {"string", "postgres"},
 	"DATABASE_HOST":          {"string", "localhost"},
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+/*
+An action taken through the admin API. Details is the JSON of the request
+parameters that matter for the action, e.g. the result a game was ended with.
+*/
+type AdminAudit struct {
+	gorm.Model
+	Admin      string `json:"admin"`
+	Action     string `json:"action" gorm:"index"`
+	Target     string `json:"target" gorm:"index"` // Session, connection or user the action was taken on
+	Details    string `json:"details" gorm:"type:jsonb"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+func CreateAdminAudit(ctx context.Context, audit *AdminAudit) error {
+	if audit.Details == "" {
+		audit.Details = "{}"
+	}
+	return gormDbWrapper.WithContext(ctx).Create(audit).Error
+}
+
+/*
+The latest audited actions, newest first
+*/
+func GetAdminAudits(ctx context.Context, limit int) (audits []AdminAudit, err error) {
+	audits = []AdminAudit{}
+	result := gormDbWrapper.WithContext(ctx).Order("created_at DESC").Limit(limit).Find(&audits)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+const (
+	AnalysisPending = "pending"
+	AnalysisDone    = "done"
+	AnalysisFailed  = "failed"
+)
+
+/*
+Engine analysis of a saved session, see pkg/analysis
+*/
+type Analysis struct {
+	gorm.Model
+	SessionID     string        `json:"session_id" gorm:"uniqueIndex"`
+	Status        string        `json:"status"`
+	Attempts      int           `json:"attempts"`
+	Error         string        `json:"error,omitempty"`
+	WhiteAccuracy float64       `json:"white_accuracy"`
+	BlackAccuracy float64       `json:"black_accuracy"`
+	Plies         []AnalysisPly `json:"plies"`
+}
+
+/*
+One analysed move. Evaluations are from white's point of view, after the move.
+*/
+type AnalysisPly struct {
+	ID             uint    `json:"-" gorm:"primarykey"`
+	AnalysisID     uint    `json:"-" gorm:"index"`
+	Ply            int     `json:"ply"`
+	Move           string  `json:"move"` // UCI
+	San            string  `json:"san"`
+	EvalCP         int     `json:"eval_cp"`
+	Mate           int     `json:"mate"`      // Moves until mate, negative when black mates
+	BestMove       string  `json:"best_move"` // The engine's choice instead of Move, UCI
+	CPLoss         int     `json:"cp_loss"`
+	Accuracy       float64 `json:"accuracy"`
+	Classification string  `json:"classification,omitempty"`
+}
+
+/*
+Record that the session is queued for analysis, resetting an earlier analysis
+*/
+func CreatePendingAnalysis(ctx context.Context, sessionID string) error {
+	return gormDbWrapper.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var analysis Analysis
+		if err := tx.FirstOrCreate(&analysis, Analysis{SessionID: sessionID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("analysis_id = ?", analysis.ID).Delete(&AnalysisPly{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&analysis).Updates(map[string]interface{}{
+			"status":         AnalysisPending,
+			"attempts":       0,
+			"error":          "",
+			"white_accuracy": 0,
+			"black_accuracy": 0,
+		}).Error
+	})
+}
+
+/*
+Store a finished analysis of the session
+*/
+func SaveAnalysis(ctx context.Context, sessionID string, attempts int, whiteAccuracy, blackAccuracy float64, plies []AnalysisPly) error {
+	return gormDbWrapper.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var analysis Analysis
+		if err := tx.FirstOrCreate(&analysis, Analysis{SessionID: sessionID}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("analysis_id = ?", analysis.ID).Delete(&AnalysisPly{}).Error; err != nil {
+			return err
+		}
+		for i := range plies {
+			plies[i].ID = 0
+			plies[i].AnalysisID = analysis.ID
+		}
+		if len(plies) > 0 {
+			if err := tx.Create(&plies).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&analysis).Updates(map[string]interface{}{
+			"status":         AnalysisDone,
+			"attempts":       attempts,
+			"error":          "",
+			"white_accuracy": whiteAccuracy,
+			"black_accuracy": blackAccuracy,
+		}).Error
+	})
+}
+
+/*
+Record that analysing the session failed for good
+*/
+func FailAnalysis(ctx context.Context, sessionID string, attempts int, cause error) error {
+	return gormDbWrapper.WithContext(ctx).Model(&Analysis{}).Where("session_id = ?", sessionID).Updates(map[string]interface{}{
+		"status":   AnalysisFailed,
+		"attempts": attempts,
+		"error":    cause.Error(),
+	}).Error
+}
+
+func GetAnalysisBySessionID(ctx context.Context, sessionID string) (analysis Analysis, err error) {
+	result := gormDbWrapper.WithContext(ctx).
+		Preload("Plies", func(db *gorm.DB) *gorm.DB { return db.Order("ply") }).
+		First(&analysis, Analysis{SessionID: sessionID})
+	if err = result.Error; err != nil {
+		return analysis, err
+	}
+
+	return analysis, nil
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+/*
+A chat message of a saved session
+*/
+type ChatMessage struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	SessionID string    `json:"-" gorm:"index"`
+	UserID    string    `json:"user_id"`
+	Text      string    `json:"text"`
+	SentAt    time.Time `json:"sent_at"`
+}
+
+/*
+The chat of a saved session, oldest message first
+*/
+func GetChatMessages(ctx context.Context, sessionID string) (messages []ChatMessage, err error) {
+	messages = []ChatMessage{}
+	result := gormDbWrapper.WithContext(ctx).Where("session_id = ?", sessionID).Order("sent_at, id").Find(&messages)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
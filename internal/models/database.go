@@ -1,7 +1,9 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 
@@ -30,9 +32,16 @@ func InitDB() {
 	if err != nil {
 		logging.Fatal("database connection failure", zap.Error(err))
 	}
+	if err := registerQueryInstrumentation(gormDbWrapper); err != nil {
+		logging.Warn("database queries won't be timed or traced", zap.Error(err))
+	}
 
 	gormDbWrapper.AutoMigrate(&Session{})
 	gormDbWrapper.AutoMigrate(&User{})
+	gormDbWrapper.AutoMigrate(&Analysis{}, &AnalysisPly{})
+	gormDbWrapper.AutoMigrate(&Rating{}, &RatingHistory{}, &FairPlayReview{})
+	gormDbWrapper.AutoMigrate(&UserStats{}, &UserOpening{})
+	gormDbWrapper.AutoMigrate(&AdminAudit{}, &ChatMessage{}, &Report{})
 
 	db, err = gormDbWrapper.DB()
 
@@ -52,6 +61,16 @@ func InitDB() {
 	db.SetMaxIdleConns(25)
 }
 
+/*
+Check the database is reachable, for readiness checks
+*/
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database not connected")
+	}
+	return db.PingContext(ctx)
+}
+
 func CloseDB() {
 	db.Close()
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"testing"
 )
@@ -12,20 +13,20 @@ func TestUser(t *testing.T) {
 		return
 	}
 
-	newUser, err := FindOrCreateUser("tester2")
+	newUser, err := FindOrCreateUser(context.Background(), "tester2")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	fmt.Println(newUser)
 
-	user, err := GetUserByPrivyDid(newUser.PrivyDid)
+	user, err := GetUserById(context.Background(), newUser.Id)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	if user.PrivyDid != newUser.PrivyDid {
-		t.Errorf("get user: got %v, want %v", user.PrivyDid, newUser.PrivyDid)
+	if user.Id != newUser.Id {
+		t.Errorf("get user: got %v, want %v", user.Id, newUser.Id)
 		return
 	}
 	fmt.Println(user)
@@ -39,19 +40,25 @@ func TestSession(t *testing.T) {
 		t.Error("nil db")
 	}
 
-	newSession, err := InsertSession("1234", "fd9a179f-c035-4e50-82f5-5d1efc844316", "0046bb25-3f06-44f8-84e2-d84e2fff42e9", []string{"e2-e4"})
+	newSession, err := InsertSession(context.Background(), Session{
+		SessionID: "1234",
+		Player1ID: "fd9a179f-c035-4e50-82f5-5d1efc844316",
+		Player2ID: "0046bb25-3f06-44f8-84e2-d84e2fff42e9",
+		Moves:     []string{"e2e4"},
+		Outcome:   "*",
+	}, nil)
 	if err != nil {
 		t.Error(err)
 	}
 	fmt.Println(newSession)
 
-	sessions, err := GetSessionsByPlayerID(newSession.Player1ID)
+	page, err := QuerySessions(context.Background(), SessionQuery{PlayerID: newSession.Player1ID})
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	session, err := GetSessionByID(sessions[0].SessionID)
+	session, err := GetSessionByID(context.Background(), page.Sessions[0].SessionID)
 	if err != nil {
 		t.Error(err)
 		return
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+const (
+	ReviewOpen      = "open"
+	ReviewCleared   = "cleared"
+	ReviewConfirmed = "confirmed"
+)
+
+/*
+An account flagged by the fair-play checks after a rated game, waiting for a
+moderator. Evidence is the JSON the checks produced, see pkg/fairplay.
+*/
+type FairPlayReview struct {
+	gorm.Model
+	UserID    string `json:"user_id" gorm:"index"`
+	SessionID string `json:"session_id" gorm:"index"`
+	Pool      string `json:"pool"`
+	Status    string `json:"status" gorm:"index"`
+	Signals   string `json:"signals"` // Comma separated names of the signals that triggered
+	Evidence  string `json:"evidence" gorm:"type:jsonb"`
+}
+
+func CreateFairPlayReview(ctx context.Context, review *FairPlayReview) error {
+	if review.Status == "" {
+		review.Status = ReviewOpen
+	}
+	return gormDbWrapper.WithContext(ctx).Create(review).Error
+}
+
+/*
+Reviews in the status, oldest first so the queue is worked in order
+*/
+func GetFairPlayReviews(ctx context.Context, status string, limit int) (reviews []FairPlayReview, err error) {
+	result := gormDbWrapper.WithContext(ctx).Where("status = ?", status).Order("created_at").Limit(limit).Find(&reviews)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+/*
+The user's latest reviews in any status, newest first
+*/
+func GetUserFairPlayReviews(ctx context.Context, userID string, limit int) (reviews []FairPlayReview, err error) {
+	reviews = []FairPlayReview{}
+	result := gormDbWrapper.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&reviews)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+	"gorm.io/gorm"
+)
+
+const (
+	queryStartKey = "instrumentation:query_start"
+	querySpanKey  = "instrumentation:query_span"
+)
+
+var queryLatency = metrics.NewHistogram("chess_db_query_seconds",
+	"Time taken by database queries, by operation", metrics.DefBuckets, "operation")
+
+/*
+Time a query gorm doesn't run and trace it as a child of the span in ctx.
+The returned function finishes both with the query's error.
+*/
+func startQuery(ctx context.Context, operation, statement string) func(error) {
+	start := time.Now()
+	_, span := tracing.Start(ctx, "db."+operation, tracing.String("db.statement", statement))
+	return func(err error) {
+		queryLatency.Observe(time.Since(start).Seconds(), operation)
+		span.RecordError(err)
+		span.End()
+	}
+}
+
+type callbackRegisterer interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+/*
+Time and trace every statement gorm runs, from the first of its callbacks to
+the last. Spans are children of the span in the statement's context.
+*/
+func registerQueryInstrumentation(gormDB *gorm.DB) error {
+	callbacks := gormDB.Callback()
+	for _, c := range []struct {
+		operation     string
+		before, after callbackRegisterer
+	}{
+		{"create", callbacks.Create().Before("*"), callbacks.Create().After("*")},
+		{"query", callbacks.Query().Before("*"), callbacks.Query().After("*")},
+		{"update", callbacks.Update().Before("*"), callbacks.Update().After("*")},
+		{"delete", callbacks.Delete().Before("*"), callbacks.Delete().After("*")},
+		{"row", callbacks.Row().Before("*"), callbacks.Row().After("*")},
+		{"raw", callbacks.Raw().Before("*"), callbacks.Raw().After("*")},
+	} {
+		operation := c.operation
+		err := c.before.Register("instrumentation:before_"+operation, func(tx *gorm.DB) {
+			tx.InstanceSet(queryStartKey, time.Now())
+			_, span := tracing.Start(tx.Statement.Context, "db."+operation)
+			tx.InstanceSet(querySpanKey, span)
+		})
+		if err != nil {
+			return err
+		}
+		err = c.after.Register("instrumentation:after_"+operation, func(tx *gorm.DB) {
+			if start, ok := tx.InstanceGet(queryStartKey); ok {
+				queryLatency.Observe(time.Since(start.(time.Time)).Seconds(), operation)
+			}
+			if span, ok := tx.InstanceGet(querySpanKey); ok {
+				span := span.(*tracing.Span)
+				span.SetAttributes(
+					tracing.String("db.table", tx.Statement.Table),
+					tracing.String("db.statement", tx.Statement.SQL.String()),
+					tracing.Int("db.rows_affected", int(tx.Statement.RowsAffected)),
+				)
+				span.RecordError(tx.Error)
+				span.End()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/rating"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+A user's rating in one matching pool
+*/
+type Rating struct {
+	gorm.Model
+	UserID       string    `json:"user_id" gorm:"uniqueIndex:idx_ratings_user_pool"`
+	Pool         string    `json:"pool" gorm:"uniqueIndex:idx_ratings_user_pool"`
+	Rating       int       `json:"rating"`
+	Games        int       `json:"games"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+/*
+The user's rating in the pool, the default rating if they haven't played
+any rated game in it
+*/
+func GetRating(ctx context.Context, userID, pool string) (Rating, error) {
+	var r Rating
+	err := gormDbWrapper.WithContext(ctx).Where(Rating{UserID: userID, Pool: pool}).First(&r).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Rating{UserID: userID, Pool: pool, Rating: rating.Default}, nil
+	}
+	return r, err
+}
+
+/*
+The user's ratings in every pool they played rated games in
+*/
+func GetRatings(ctx context.Context, userID string) (ratings []Rating, err error) {
+	result := gormDbWrapper.WithContext(ctx).Where("user_id = ?", userID).Order("pool").Find(&ratings)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return ratings, nil
+}
+
+/*
+A user's rating in a pool after a rated game
+*/
+type RatingHistory struct {
+	ID        uint      `json:"-" gorm:"primarykey"`
+	UserID    string    `json:"user_id" gorm:"index:idx_rating_histories_user_pool"`
+	Pool      string    `json:"pool" gorm:"index:idx_rating_histories_user_pool"`
+	SessionID string    `json:"session_id"`
+	Rating    int       `json:"rating"`
+	Diff      int       `json:"diff"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_rating_histories_user_pool"`
+}
+
+/*
+Load and lock both players' ratings for the rest of the transaction. Rows are
+locked in a fixed order so concurrent games of the same players can't deadlock.
+*/
+func lockRatings(tx *gorm.DB, whiteID, blackID, pool string) (white, black Rating, err error) {
+	first, second := &white, &black
+	firstID, secondID := whiteID, blackID
+	if blackID < whiteID {
+		first, second = second, first
+		firstID, secondID = secondID, firstID
+	}
+	if *first, err = lockRating(tx, firstID, pool); err != nil {
+		return
+	}
+	*second, err = lockRating(tx, secondID, pool)
+	return
+}
+
+func lockRating(tx *gorm.DB, userID, pool string) (Rating, error) {
+	r := Rating{UserID: userID, Pool: pool, Rating: rating.Default}
+	// Create the row first so there is one to lock
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&r).Error
+	if err != nil {
+		return r, err
+	}
+	r = Rating{}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND pool = ?", userID, pool).First(&r).Error
+	return r, err
+}
+
+/*
+Store the user's new rating after the session, keeping the old one in their history
+*/
+func saveRating(tx *gorm.DB, r Rating, newRating int, sessionID string) error {
+	now := time.Now()
+	err := tx.Model(&r).Updates(map[string]interface{}{
+		"rating":         newRating,
+		"games":          r.Games + 1,
+		"last_played_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Create(&RatingHistory{
+		UserID:    r.UserID,
+		Pool:      r.Pool,
+		SessionID: sessionID,
+		Rating:    newRating,
+		Diff:      newRating - r.Rating,
+		CreatedAt: now,
+	}).Error
+}
+
+/*
+Ratings of everyone but bot accounts, by pool
+*/
+func GetHumanRatings(ctx context.Context) (map[string][]Rating, error) {
+	var ratings []Rating
+	result := gormDbWrapper.WithContext(ctx).Where("user_id NOT IN (?)", gormDbWrapper.Model(&User{}).Select("id").Where("bot")).Find(&ratings)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	pools := map[string][]Rating{}
+	for _, r := range ratings {
+		pools[r.Pool] = append(pools[r.Pool], r)
+	}
+	return pools, nil
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidInterval = errors.New("interval must be day, week or month")
+
+/*
+A user's rating over one day, week or month: the rating after their last
+game in it and the lowest and highest ratings after games in it
+*/
+type RatingPoint struct {
+	Date   time.Time `json:"date"` // Start of the interval, UTC
+	Rating int       `json:"rating"`
+	Min    int       `json:"min"`
+	Max    int       `json:"max"`
+}
+
+func ValidRatingInterval(interval string) bool {
+	return interval == "day" || interval == "week" || interval == "month"
+}
+
+/*
+The user's rating history in the pool, oldest first, one point per interval
+they played rated games in
+*/
+func GetRatingHistory(ctx context.Context, userID, pool, interval string) ([]RatingPoint, error) {
+	if !ValidRatingInterval(interval) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidInterval, interval)
+	}
+
+	points := []RatingPoint{}
+	result := gormDbWrapper.WithContext(ctx).Raw(`SELECT date_trunc(?, created_at AT TIME ZONE 'UTC') AS date,
+		(array_agg(rating ORDER BY created_at DESC, id DESC))[1] AS rating,
+		MIN(rating) AS min, MAX(rating) AS max
+		FROM rating_histories WHERE user_id = ? AND pool = ?
+		GROUP BY 1 ORDER BY 1`, interval, userID, pool).Scan(&points)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	for i := range points {
+		// date_trunc drops the time zone along with the time
+		d := points[i].Date
+		points[i].Date = time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return points, nil
+}
+
+/*
+The user's highest rating in the pool after a game, and when they reached it
+first. Not found if they haven't played a rated game in the pool.
+*/
+func GetPeakRating(ctx context.Context, userID, pool string) (RatingHistory, error) {
+	var peak RatingHistory
+	err := gormDbWrapper.WithContext(ctx).Where("user_id = ? AND pool = ?", userID, pool).
+		Order("rating DESC, created_at").First(&peak).Error
+	return peak, err
+}
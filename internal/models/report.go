@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons to report an opponent
+const (
+	ReportCheating = "cheating"
+	ReportAbuse    = "abuse"
+	ReportStalling = "stalling"
+)
+
+// Report statuses
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"  // A moderator took action against the reported user
+	ReportDismissed = "dismissed" // A moderator found nothing to act on
+)
+
+var (
+	ErrDuplicateReport = errors.New("session already reported")
+	ErrReportResolved  = errors.New("report already resolved")
+)
+
+/*
+A player's report of their opponent in a session, waiting in the moderation
+queue until a moderator resolves it. The resolution is shown to the reporter.
+*/
+type Report struct {
+	gorm.Model
+	ReporterID string     `json:"reporter_id" gorm:"uniqueIndex:idx_reports_reporter_session"`
+	SessionID  string     `json:"session_id" gorm:"uniqueIndex:idx_reports_reporter_session;index"`
+	ReportedID string     `json:"reported_id" gorm:"index"`
+	Category   string     `json:"category"`
+	Text       string     `json:"text"`
+	Status     string     `json:"status" gorm:"index"`
+	Resolution string     `json:"resolution"` // The moderator's note to the reporter
+	ResolvedBy string     `json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+func ValidReportCategory(category string) bool {
+	return category == ReportCheating || category == ReportAbuse || category == ReportStalling
+}
+
+/*
+Queue a new report. A player can report each session once.
+*/
+func CreateReport(ctx context.Context, report *Report) error {
+	report.Status = ReportOpen
+	result := gormDbWrapper.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "reporter_id"}, {Name: "session_id"}},
+		DoNothing: true,
+	}).Create(report)
+	if err := result.Error; err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return ErrDuplicateReport
+	}
+	return nil
+}
+
+func GetReport(ctx context.Context, id uint) (report Report, err error) {
+	err = gormDbWrapper.WithContext(ctx).First(&report, id).Error
+	return report, err
+}
+
+/*
+Reports in the status, oldest first so the queue is worked in order. An
+empty category matches all of them.
+*/
+func GetReports(ctx context.Context, status, category string, limit int) (reports []Report, err error) {
+	reports = []Report{}
+	query := gormDbWrapper.WithContext(ctx).Where("status = ?", status)
+	if category != "" {
+		query = query.Where("category = ?", category)
+	}
+	if err = query.Order("created_at, id").Limit(limit).Find(&reports).Error; err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+/*
+The reports the player filed, newest first
+*/
+func GetReportsByReporter(ctx context.Context, reporterID string, limit int) (reports []Report, err error) {
+	reports = []Report{}
+	result := gormDbWrapper.WithContext(ctx).Where("reporter_id = ?", reporterID).Order("created_at DESC, id DESC").Limit(limit).Find(&reports)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+/*
+Open reports against the user, to tell moderators about repeat offenders
+*/
+func CountOpenReports(ctx context.Context, reportedID string) (count int64, err error) {
+	err = gormDbWrapper.WithContext(ctx).Model(&Report{}).Where("reported_id = ? AND status = ?", reportedID, ReportOpen).Count(&count).Error
+	return count, err
+}
+
+/*
+Close an open report with the moderator's decision
+*/
+func ResolveReport(ctx context.Context, id uint, status, resolution, moderator string) (Report, error) {
+	if status != ReportActioned && status != ReportDismissed {
+		return Report{}, errors.New("unknown report resolution " + status)
+	}
+	now := time.Now()
+	result := gormDbWrapper.WithContext(ctx).Model(&Report{}).Where("id = ? AND status = ?", id, ReportOpen).Updates(map[string]interface{}{
+		"status":      status,
+		"resolution":  resolution,
+		"resolved_by": moderator,
+		"resolved_at": now,
+	})
+	if err := result.Error; err != nil {
+		return Report{}, err
+	}
+	report, err := GetReport(ctx, id)
+	if err != nil {
+		return report, err
+	}
+	if result.RowsAffected == 0 {
+		return report, ErrReportResolved
+	}
+	return report, nil
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds of sanctions moderators can put on a user
+const (
+	SanctionBan        = "ban"        // Keeps the user out until lifted
+	SanctionSuspension = "suspension" // Keeps the user out until it expires
+	SanctionMute       = "mute"       // Keeps the user out of chat
+)
+
+var ErrInvalidSanction = errors.New("invalid sanction")
+
+/*
+A sanction put on a user by a moderator. It is in effect while it is active
+and hasn't expired.
+*/
+type Sanction struct {
+	Active    bool       `json:"-"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Nil until lifted
+	By        string     `json:"-"`                    // The moderator who applied it
+	At        time.Time  `json:"since"`
+}
+
+func ValidSanctionKind(kind string) bool {
+	return kind == SanctionBan || kind == SanctionSuspension || kind == SanctionMute
+}
+
+func (s Sanction) InEffect(now time.Time) bool {
+	return s.Active && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+/*
+Why a sanctioned user can't do something
+*/
+type SanctionError struct {
+	Kind     string
+	Sanction Sanction
+}
+
+func (e *SanctionError) Error() string {
+	msg := map[string]string{
+		SanctionBan:        "account banned",
+		SanctionSuspension: "account suspended",
+		SanctionMute:       "muted",
+	}[e.Kind]
+	if e.Sanction.ExpiresAt != nil {
+		msg += " until " + e.Sanction.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if e.Sanction.Reason != "" {
+		msg += ": " + e.Sanction.Reason
+	}
+	return msg
+}
+
+/*
+A *SanctionError if the user is banned or suspended, nil if they may log in and play
+*/
+func (u User) Restriction(now time.Time) error {
+	if u.Ban.InEffect(now) {
+		return &SanctionError{Kind: SanctionBan, Sanction: u.Ban}
+	}
+	if u.Suspension.InEffect(now) {
+		return &SanctionError{Kind: SanctionSuspension, Sanction: u.Suspension}
+	}
+	return nil
+}
+
+/*
+A *SanctionError if the user may not chat, which includes users who may not play
+*/
+func (u User) ChatRestriction(now time.Time) error {
+	if err := u.Restriction(now); err != nil {
+		return err
+	}
+	if u.Mute.InEffect(now) {
+		return &SanctionError{Kind: SanctionMute, Sanction: u.Mute}
+	}
+	return nil
+}
+
+/*
+Put a sanction of the kind on the user, replacing any earlier one of the same
+kind. Bans don't expire, suspensions must.
+*/
+func SetSanction(ctx context.Context, userID, kind string, sanction Sanction) (user User, err error) {
+	if kind == SanctionBan && sanction.ExpiresAt != nil {
+		return user, fmt.Errorf("%w: bans don't expire, suspend the user instead", ErrInvalidSanction)
+	}
+	if kind == SanctionSuspension && sanction.ExpiresAt == nil {
+		return user, fmt.Errorf("%w: suspensions need an expiry", ErrInvalidSanction)
+	}
+	sanction.Active = true
+	sanction.At = time.Now()
+	return saveSanction(ctx, userID, kind, sanction)
+}
+
+/*
+Lift the user's sanction of the kind
+*/
+func LiftSanction(ctx context.Context, userID, kind string) (User, error) {
+	return saveSanction(ctx, userID, kind, Sanction{})
+}
+
+func saveSanction(ctx context.Context, userID, kind string, sanction Sanction) (user User, err error) {
+	if !ValidSanctionKind(kind) {
+		return user, fmt.Errorf("%w: unknown kind %q", ErrInvalidSanction, kind)
+	}
+	prefix := kind + "_"
+	if user, err = FindOrCreateUser(ctx, userID); err != nil {
+		return user, err
+	}
+	err = gormDbWrapper.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		prefix + "active":     sanction.Active,
+		prefix + "reason":     sanction.Reason,
+		prefix + "expires_at": sanction.ExpiresAt,
+		prefix + "by":         sanction.By,
+		prefix + "at":         sanction.At,
+	}).Error
+	if err != nil {
+		return user, err
+	}
+
+	return GetUserById(ctx, userID)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestriction(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		user     User
+		restrict string // Kind keeping the user from playing, empty if they may
+		chat     string // Kind keeping the user from chatting
+	}{
+		{"no sanctions", User{}, "", ""},
+		{"banned", User{Ban: Sanction{Active: true}}, SanctionBan, SanctionBan},
+		{"lifted ban", User{Ban: Sanction{Reason: "spam"}}, "", ""},
+		{"suspended", User{Suspension: Sanction{Active: true, ExpiresAt: &future}}, SanctionSuspension, SanctionSuspension},
+		{"expired suspension", User{Suspension: Sanction{Active: true, ExpiresAt: &past}}, "", ""},
+		{"muted", User{Mute: Sanction{Active: true}}, "", SanctionMute},
+		{"expired mute", User{Mute: Sanction{Active: true, ExpiresAt: &past}}, "", ""},
+		{"banned and muted", User{Ban: Sanction{Active: true}, Mute: Sanction{Active: true}}, SanctionBan, SanctionBan},
+	}
+	kind := func(err error) string {
+		var sanctionErr *SanctionError
+		if errors.As(err, &sanctionErr) {
+			return sanctionErr.Kind
+		}
+		return ""
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kind(tt.user.Restriction(now)); got != tt.restrict {
+				t.Errorf("restriction: got %q, want %q", got, tt.restrict)
+			}
+			if got := kind(tt.user.ChatRestriction(now)); got != tt.chat {
+				t.Errorf("chat restriction: got %q, want %q", got, tt.chat)
+			}
+		})
+	}
+}
+
+func TestSanctionErrorMessage(t *testing.T) {
+	until := time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC)
+	err := &SanctionError{Kind: SanctionSuspension, Sanction: Sanction{Reason: "sandbagging", ExpiresAt: &until}}
+	if want := "account suspended until 2024-06-10T08:00:00Z: sandbagging"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
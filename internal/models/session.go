@@ -1,33 +1,79 @@
 package models
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
 
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+	"github.com/jackc/pgx/v5/pgtype"
 	"gorm.io/gorm"
 )
 
+// Scans array columns into slices
+var pgTypes = pgtype.NewMap()
+
+/*
+A slice passed to the driver as one array value. gorm expands plain slice
+arguments of raw SQL into tuples, "($2,$3)", which array columns don't take.
+*/
+type pgArray[T any] []T
+
+func (a pgArray[T]) Value() (driver.Value, error) {
+	if a == nil {
+		return []T{}, nil
+	}
+	return []T(a), nil
+}
+
 // TODO: Migrate to using GORM for all database interactions.
 type Session struct {
 	gorm.Model
-	SessionID string   `json:"session_id" gorm:"unique"`
-	Player1ID string   `json:"player1_id" gorm:"index"`
-	Player2ID string   `json:"player2_id" gorm:"index"`
-	Moves     []string `json:"moves" gorm:"type:text[]"`
+	SessionID       string    `json:"session_id" gorm:"unique"`
+	Player1ID       string    `json:"player1_id" gorm:"index"`  // White
+	Player2ID       string    `json:"player2_id" gorm:"index"`  // Black
+	Moves           []string  `json:"moves" gorm:"type:text[]"` // UCI, whatever notation the players used
+	Outcome         string    `json:"outcome"`                  // "1-0", "0-1", "1/2-1/2" or "*"
+	Method          string    `json:"method"`
+	Pool            string    `json:"pool" gorm:"index"` // The time control, see matcher.Pool
+	Variant         string    `json:"variant"`
+	ECO             string    `json:"eco"` // Opening code, empty if the game left the book at once
+	Opening         string    `json:"opening"`
+	Rated           bool      `json:"rated"`
+	WhiteRating     int       `json:"white_rating"` // Before the game, zero for unrated games
+	BlackRating     int       `json:"black_rating"`
+	WhiteRatingDiff int       `json:"white_rating_diff"`
+	BlackRatingDiff int       `json:"black_rating_diff"`
+	StartedAt       time.Time `json:"started_at"`
+	MoveTimes       []int64   `json:"move_times" gorm:"type:bigint[]"` // Unix milliseconds each move was played at
+
+	Chat []ChatMessage `json:"-" gorm:"-"` // Saved along with the session, see GetChatMessages
 }
 
-func GetSessionByID(sessionID string) (Session, error) {
-	var session Session
-	query := `SELECT session_id, player1_id, player2_id, moves FROM sessions WHERE session_id = $1`
-	row := db.QueryRow(query, sessionID)
+const sessionColumns = `id, session_id, player1_id, player2_id, moves, outcome, method, pool, variant, eco, opening, rated,
+	white_rating, black_rating, white_rating_diff, black_rating_diff, started_at, move_times, created_at`
 
-	var moveJSON string
-	err := row.Scan(&session.SessionID, &session.Player1ID, &session.Player2ID, &moveJSON)
-	if err != nil {
-		return Session{}, err
-	}
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (Session, error) {
+	var session Session
+	var startedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.SessionID, &session.Player1ID, &session.Player2ID, pgTypes.SQLScanner(&session.Moves),
+		&session.Outcome, &session.Method, &session.Pool, &session.Variant, &session.ECO, &session.Opening, &session.Rated,
+		&session.WhiteRating, &session.BlackRating, &session.WhiteRatingDiff, &session.BlackRatingDiff,
+		&startedAt, pgTypes.SQLScanner(&session.MoveTimes), &session.CreatedAt)
+	session.StartedAt = startedAt.Time
+	return session, err
+}
 
-	err = json.Unmarshal([]byte(moveJSON), &session.Moves)
+func GetSessionByID(ctx context.Context, sessionID string) (Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE session_id = $1`
+	done := startQuery(ctx, "query", query)
+	session, err := scanSession(db.QueryRowContext(ctx, query, sessionID))
+	done(err)
 	if err != nil {
 		return Session{}, err
 	}
@@ -35,26 +81,30 @@ func GetSessionByID(sessionID string) (Session, error) {
 	return session, nil
 }
 
-func GetSessionsByPlayerID(playerID string) ([]Session, error) {
-	var sessions []Session
+/*
+The player's latest rated games in the pool, newest first
+*/
+func GetRecentRatedSessions(ctx context.Context, playerID, pool string, limit int) ([]Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+		WHERE (player1_id = $1 OR player2_id = $1) AND pool = $2 AND rated
+		ORDER BY created_at DESC LIMIT $3`
+	return querySessions(ctx, query, playerID, pool, limit)
+}
 
-	query := `SELECT session_id, player1_id, player2_id, moves FROM sessions WHERE player1_id = $1 OR player2_id = $1 ORDER BY session_id DESC LIMIT 5`
-	rows, err := db.Query(query, playerID)
+func querySessions(ctx context.Context, query string, args ...any) (sessions []Session, err error) {
+	done := startQuery(ctx, "query", query)
+	defer func() { done(err) }()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var session Session
-		var movesJSON string
-		err := rows.Scan(&session.SessionID, &session.Player1ID, &session.Player2ID, &movesJSON)
+		session, err := scanSession(rows)
 		if err != nil {
 			return nil, err
 		}
-		if err := json.Unmarshal([]byte(movesJSON), &session.Moves); err != nil {
-			return nil, err
-		}
 		sessions = append(sessions, session)
 	}
 
@@ -65,8 +115,8 @@ func GetSessionsByPlayerID(playerID string) ([]Session, error) {
 	return sessions, nil
 }
 
-func GetSessionsById(playerId string) (sessions []Session, err error) {
-	result := gormDbWrapper.Joins("JOIN users ON users.id = sessions.player1_id OR users.id = sessions.player2_id").Where("users.id = ?", playerId).Find(&sessions)
+func GetSessionsById(ctx context.Context, playerId string) (sessions []Session, err error) {
+	result := gormDbWrapper.WithContext(ctx).Joins("JOIN users ON users.id = sessions.player1_id OR users.id = sessions.player2_id").Where("users.id = ?", playerId).Find(&sessions)
 	if err = result.Error; err != nil {
 		return nil, err
 	}
@@ -74,27 +124,59 @@ func GetSessionsById(playerId string) (sessions []Session, err error) {
 	return sessions, nil
 }
 
-func InsertSession(sessionID, player1ID, player2ID string, moves []string) (Session, error) {
-	movesJSON, err := json.Marshal(moves)
-	if err != nil {
-		log.Fatal(err)
-	}
+func insertSessionRow(tx *gorm.DB, session Session) *gorm.DB {
+	return tx.Exec(`INSERT INTO sessions (session_id, player1_id, player2_id, moves, outcome, method, pool, variant, eco, opening, rated,
+		white_rating, black_rating, white_rating_diff, black_rating_diff, started_at, move_times, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), NOW())`,
+		session.SessionID, session.Player1ID, session.Player2ID, pgArray[string](session.Moves), session.Outcome, session.Method,
+		session.Pool, session.Variant, session.ECO, session.Opening, session.Rated, session.WhiteRating, session.BlackRating,
+		session.WhiteRatingDiff, session.BlackRatingDiff, session.StartedAt, pgArray[int64](session.MoveTimes),
+	)
+}
 
-	ist, err := db.Prepare("INSERT INTO sessions (session_id, player1_id, player2_id, moves) VALUES ($1, $2, $3, $4)")
-	if err != nil {
-		return Session{}, err
-	}
-	defer ist.Close()
+/*
+Save a finished session and its chat. For rated sessions rate is given both
+players' ratings in the pool and returns their new ones, which are stored
+along with the session and the players' stats in the same transaction. Its
+queries are traced as children of the span in ctx.
+*/
+func InsertSession(ctx context.Context, session Session, rate func(white, black Rating) (int, int)) (Session, error) {
+	ctx, span := tracing.Start(ctx, "InsertSession", tracing.String("session_id", session.SessionID))
+	defer span.End()
+	err := gormDbWrapper.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if session.Rated {
+			white, black, err := lockRatings(tx, session.Player1ID, session.Player2ID, session.Pool)
+			if err != nil {
+				return err
+			}
+			newWhite, newBlack := rate(white, black)
+			session.WhiteRating, session.BlackRating = white.Rating, black.Rating
+			session.WhiteRatingDiff, session.BlackRatingDiff = newWhite-white.Rating, newBlack-black.Rating
+			if err := saveRating(tx, white, newWhite, session.SessionID); err != nil {
+				return err
+			}
+			if err := saveRating(tx, black, newBlack, session.SessionID); err != nil {
+				return err
+			}
+		}
 
-	_, err = ist.Exec(sessionID, player1ID, player2ID, movesJSON)
+		if err := insertSessionRow(tx, session).Error; err != nil {
+			return err
+		}
+		if len(session.Chat) > 0 {
+			for i := range session.Chat {
+				session.Chat[i].SessionID = session.SessionID
+			}
+			if err := tx.Create(&session.Chat).Error; err != nil {
+				return err
+			}
+		}
+		return recordStats(tx, session)
+	})
 	if err != nil {
+		span.RecordError(err)
 		return Session{}, err
 	}
 
-	return Session{
-		SessionID: sessionID,
-		Player1ID: player1ID,
-		Player2ID: player2ID,
-		Moves:     moves,
-	}, nil
+	return session, nil
 }
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidQuery  = errors.New("invalid query")
+)
+
+const (
+	ResultWin  = "win"
+	ResultLoss = "loss"
+	ResultDraw = "draw"
+
+	ColorWhite = "white"
+	ColorBlack = "black"
+)
+
+const (
+	DefaultSessionLimit = 20
+	MaxSessionLimit     = 100
+)
+
+/*
+Filters of a player's saved sessions. Results and colors are from the
+player's point of view, zero values don't filter.
+*/
+type SessionQuery struct {
+	PlayerID    string
+	OpponentID  string
+	Result      string // ResultWin, ResultLoss or ResultDraw
+	Color       string // ColorWhite or ColorBlack
+	Variant     string
+	TimeControl string // The pool, e.g. "5+3"
+	Since       time.Time
+	Until       time.Time // Exclusive
+	Cursor      string    // From the previous page, empty for the first
+	Limit       int
+}
+
+/*
+A page of sessions, newest first, and the cursor of the next page, which is
+empty on the last one
+*/
+type SessionPage struct {
+	Sessions   []Session `json:"sessions"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+/*
+The position after a session in the newest first order. Sessions ended at the
+same time are ordered by id.
+*/
+type sessionCursor struct {
+	createdAt time.Time
+	id        uint
+}
+
+func (c sessionCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.createdAt.UnixMicro(), c.id)))
+}
+
+func decodeSessionCursor(s string) (sessionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return sessionCursor{}, ErrInvalidCursor
+	}
+	micros, id, found := strings.Cut(string(data), ":")
+	if !found {
+		return sessionCursor{}, ErrInvalidCursor
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return sessionCursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return sessionCursor{}, ErrInvalidCursor
+	}
+	return sessionCursor{createdAt: time.UnixMicro(us), id: uint(n)}, nil
+}
+
+/*
+Builds the WHERE clause of a query with numbered placeholders
+*/
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+/*
+Add a clause, in which each ? stands for the next argument
+*/
+func (c *conditions) add(clause string, args ...any) {
+	for _, arg := range args {
+		c.args = append(c.args, arg)
+		clause = strings.Replace(clause, "?", "$"+strconv.Itoa(len(c.args)), 1)
+	}
+	c.clauses = append(c.clauses, clause)
+}
+
+func (c *conditions) String() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+func (q SessionQuery) conditions() (*conditions, error) {
+	c := &conditions{}
+	c.add("(player1_id = ? OR player2_id = ?)", q.PlayerID, q.PlayerID)
+
+	switch q.Color {
+	case "":
+	case ColorWhite:
+		c.add("player1_id = ?", q.PlayerID)
+	case ColorBlack:
+		c.add("player2_id = ?", q.PlayerID)
+	default:
+		return nil, fmt.Errorf("%w: unknown color %q", ErrInvalidQuery, q.Color)
+	}
+	if q.OpponentID != "" {
+		c.add("(player1_id = ? OR player2_id = ?)", q.OpponentID, q.OpponentID)
+	}
+
+	switch q.Result {
+	case "":
+	case ResultDraw:
+		c.add("outcome = '1/2-1/2'")
+	case ResultWin:
+		c.add("((player1_id = ? AND outcome = '1-0') OR (player2_id = ? AND outcome = '0-1'))", q.PlayerID, q.PlayerID)
+	case ResultLoss:
+		c.add("((player1_id = ? AND outcome = '0-1') OR (player2_id = ? AND outcome = '1-0'))", q.PlayerID, q.PlayerID)
+	default:
+		return nil, fmt.Errorf("%w: unknown result %q", ErrInvalidQuery, q.Result)
+	}
+
+	if q.Variant != "" {
+		c.add("variant = ?", q.Variant)
+	}
+	if q.TimeControl != "" {
+		c.add("pool = ?", q.TimeControl)
+	}
+	if !q.Since.IsZero() {
+		c.add("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		c.add("created_at < ?", q.Until)
+	}
+	if q.Cursor != "" {
+		cursor, err := decodeSessionCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		c.add("(created_at, id) < (?, ?)", cursor.createdAt, cursor.id)
+	}
+	c.add("deleted_at IS NULL")
+	return c, nil
+}
+
+/*
+One page of the player's sessions matching the query
+*/
+func QuerySessions(ctx context.Context, q SessionQuery) (SessionPage, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultSessionLimit
+	}
+	q.Limit = min(q.Limit, MaxSessionLimit)
+	c, err := q.conditions()
+	if err != nil {
+		return SessionPage{}, err
+	}
+
+	// One more than asked for tells whether there is a next page
+	query := `SELECT ` + sessionColumns + ` FROM sessions` + c.String() +
+		` ORDER BY created_at DESC, id DESC LIMIT ` + strconv.Itoa(q.Limit+1)
+	sessions, err := querySessions(ctx, query, c.args...)
+	if err != nil {
+		return SessionPage{}, err
+	}
+
+	page := SessionPage{Sessions: sessions}
+	if page.Sessions == nil {
+		page.Sessions = []Session{}
+	}
+	if len(sessions) > q.Limit {
+		page.Sessions = sessions[:q.Limit]
+		last := page.Sessions[q.Limit-1]
+		page.NextCursor = sessionCursor{createdAt: last.CreatedAt, id: last.ID}.encode()
+	}
+	return page, nil
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionQueryConditions(t *testing.T) {
+	cursor := sessionCursor{createdAt: time.UnixMicro(1718000000123456), id: 42}
+	q := SessionQuery{
+		PlayerID: "alice",
+		Result:   ResultWin,
+		Color:    ColorBlack,
+		Cursor:   cursor.encode(),
+	}
+	c, err := q.conditions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ` WHERE (player1_id = $1 OR player2_id = $2) AND player2_id = $3` +
+		` AND ((player1_id = $4 AND outcome = '1-0') OR (player2_id = $5 AND outcome = '0-1'))` +
+		` AND (created_at, id) < ($6, $7) AND deleted_at IS NULL`
+	if got := c.String(); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+	if len(c.args) != 7 || c.args[5] != cursor.createdAt || c.args[6] != cursor.id {
+		t.Errorf("got args %v", c.args)
+	}
+
+	if _, err := (SessionQuery{PlayerID: "alice", Cursor: "nope"}).conditions(); err != ErrInvalidCursor {
+		t.Errorf("got %v for a bad cursor, want %v", err, ErrInvalidCursor)
+	}
+	if _, err := (SessionQuery{PlayerID: "alice", Result: "won"}).conditions(); err == nil {
+		t.Error("unknown result accepted")
+	}
+}
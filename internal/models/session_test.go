@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+/*
+A gorm DB that renders statements without a database to run them on
+*/
+func dryRunDB(t *testing.T) *gorm.DB {
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gormDB
+}
+
+func TestInsertSessionRowPassesArrays(t *testing.T) {
+	stmt := insertSessionRow(dryRunDB(t), Session{
+		SessionID: "1234",
+		Moves:     []string{"e2e4", "e7e5"},
+		MoveTimes: []int64{1, 2},
+	}).Statement
+
+	sql := stmt.SQL.String()
+	if !strings.Contains(sql, "$16, $17, NOW()") || strings.Contains(sql, ", ($") {
+		t.Errorf("slices were expanded: %s", sql)
+	}
+	if len(stmt.Vars) != 17 {
+		t.Fatalf("got %d vars, want 17", len(stmt.Vars))
+	}
+
+	for _, tt := range []struct {
+		value driver.Valuer
+		oid   uint32
+		want  string
+	}{
+		{stmt.Vars[3].(driver.Valuer), pgtype.TextArrayOID, `{e2e4,e7e5}`},
+		{stmt.Vars[16].(driver.Valuer), pgtype.Int8ArrayOID, `{1,2}`},
+		{pgArray[string](nil), pgtype.TextArrayOID, `{}`},
+	} {
+		value, err := tt.value.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := pgTypes.Encode(tt.oid, pgtype.TextFormatCode, value, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != tt.want {
+			t.Errorf("got %s, want %s", encoded, tt.want)
+		}
+	}
+}
@@ -1,17 +1,34 @@
 package models
 
 import (
+	"context"
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	Id string `json:"id" gorm:"uniqueIndex"`
+	Id          string `json:"id" gorm:"uniqueIndex"`
+	DisplayName string `json:"display_name"` // Empty until the user picks one
+	Bot         bool   `json:"bot"`          // Bot accounts play through the bot API and are kept out of human-only pools
+
+	Ban        Sanction `json:"-" gorm:"embedded;embeddedPrefix:ban_"`
+	Suspension Sanction `json:"-" gorm:"embedded;embeddedPrefix:suspension_"`
+	Mute       Sanction `json:"-" gorm:"embedded;embeddedPrefix:mute_"`
 }
 
-func GetUserById(id string) (user User, err error) {
+/*
+The name to show for the user, their id until they picked one
+*/
+func (u User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Id
+}
+
+func GetUserById(ctx context.Context, id string) (user User, err error) {
 	user = User{}
-	result := gormDbWrapper.First(&user, User{Id: id})
+	result := gormDbWrapper.WithContext(ctx).First(&user, User{Id: id})
 	if err = result.Error; err != nil {
 		return user, err
 	}
@@ -19,12 +36,40 @@ func GetUserById(id string) (user User, err error) {
 	return user, nil
 }
 
-func FindOrCreateUser(id string) (user User, err error) {
+func FindOrCreateUser(ctx context.Context, id string) (user User, err error) {
 	user = User{}
-	result := gormDbWrapper.FirstOrCreate(&user, User{Id: id})
+	result := gormDbWrapper.WithContext(ctx).FirstOrCreate(&user, User{Id: id})
 	if err = result.Error; err != nil {
 		return user, err
 	}
 
 	return user, nil
 }
+
+/*
+Turn the user into a bot account, creating it if needed. Bot accounts can't
+be turned back into regular ones.
+*/
+func UpgradeToBot(ctx context.Context, id string) (user User, err error) {
+	user, err = FindOrCreateUser(ctx, id)
+	if err != nil {
+		return user, err
+	}
+	if err = gormDbWrapper.WithContext(ctx).Model(&user).Update("bot", true).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+func SetDisplayName(ctx context.Context, id string, name string) (user User, err error) {
+	user, err = FindOrCreateUser(ctx, id)
+	if err != nil {
+		return user, err
+	}
+	if err = gormDbWrapper.WithContext(ctx).Model(&user).Update("display_name", name).Error; err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Results kept for a user's recent form
+const recentFormLength = 10
+
+/*
+Game results of a user with one color, or both
+*/
+type Record struct {
+	Games  int `json:"games"`
+	Wins   int `json:"wins"`
+	Draws  int `json:"draws"`
+	Losses int `json:"losses"`
+}
+
+func (r *Record) add(score float64) {
+	r.Games++
+	switch score {
+	case 1:
+		r.Wins++
+	case 0:
+		r.Losses++
+	default:
+		r.Draws++
+	}
+}
+
+/*
+Aggregates over a user's finished games. The row is updated along with every
+saved game, so reading it never needs to scan the user's sessions.
+*/
+type UserStats struct {
+	UserID            string    `json:"user_id" gorm:"primarykey"`
+	Overall           Record    `json:"overall" gorm:"embedded"`
+	White             Record    `json:"white" gorm:"embedded;embeddedPrefix:white_"`
+	Black             Record    `json:"black" gorm:"embedded;embeddedPrefix:black_"`
+	CurrentStreak     int       `json:"current_streak"` // Wins in a row when positive, losses when negative
+	LongestWinStreak  int       `json:"longest_win_streak"`
+	LongestLossStreak int       `json:"longest_loss_streak"`
+	RecentForm        string    `json:"recent_form"` // Latest results first, "W", "D" or "L" each
+	FirstGameAt       time.Time `json:"first_game_at"`
+	LastGameAt        time.Time `json:"last_game_at"`
+	UpdatedAt         time.Time `json:"-"`
+}
+
+/*
+A user's results in one opening with one color
+*/
+type UserOpening struct {
+	ID     uint   `json:"-" gorm:"primarykey"`
+	UserID string `json:"-" gorm:"uniqueIndex:idx_user_openings"`
+	Color  string `json:"color" gorm:"uniqueIndex:idx_user_openings"`
+	ECO    string `json:"eco" gorm:"uniqueIndex:idx_user_openings"`
+	Name   string `json:"name"`
+	Record `gorm:"embedded"`
+}
+
+/*
+White's score in a finished game, false for aborted and unfinished ones
+*/
+func whiteScore(outcome string) (float64, bool) {
+	switch outcome {
+	case "1-0":
+		return 1, true
+	case "0-1":
+		return 0, true
+	case "1/2-1/2":
+		return 0.5, true
+	}
+	return 0, false
+}
+
+/*
+The user's color and score in a finished session
+*/
+func userResult(session Session, userID string) (string, float64, bool) {
+	score, finished := whiteScore(session.Outcome)
+	if !finished {
+		return "", 0, false
+	}
+	if session.Player1ID == userID {
+		return ColorWhite, score, true
+	}
+	return ColorBlack, 1 - score, true
+}
+
+/*
+Add a finished game, given in the order the user played them
+*/
+func (s *UserStats) record(color string, score float64, endedAt time.Time) {
+	s.Overall.add(score)
+	if color == ColorWhite {
+		s.White.add(score)
+	} else {
+		s.Black.add(score)
+	}
+
+	result := "D"
+	switch score {
+	case 1:
+		result = "W"
+		s.CurrentStreak = max(s.CurrentStreak, 0) + 1
+		s.LongestWinStreak = max(s.LongestWinStreak, s.CurrentStreak)
+	case 0:
+		result = "L"
+		s.CurrentStreak = min(s.CurrentStreak, 0) - 1
+		s.LongestLossStreak = max(s.LongestLossStreak, -s.CurrentStreak)
+	default:
+		s.CurrentStreak = 0
+	}
+	s.RecentForm = result + s.RecentForm
+	if len(s.RecentForm) > recentFormLength {
+		s.RecentForm = s.RecentForm[:recentFormLength]
+	}
+
+	if s.FirstGameAt.IsZero() {
+		s.FirstGameAt = endedAt
+	}
+	s.LastGameAt = endedAt
+}
+
+/*
+Add the saved session to both players' stats. A user without a stats row
+yet gets one computed from all their saved sessions, so games played before
+the table existed count too.
+*/
+func recordStats(tx *gorm.DB, session Session) error {
+	if _, finished := whiteScore(session.Outcome); !finished {
+		return nil
+	}
+	// Rows are locked in a fixed order so concurrent games can't deadlock
+	userIDs := []string{session.Player1ID, session.Player2ID}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		// Create the row first so there is one to lock
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserStats{UserID: userID}).Error
+		if err != nil {
+			return err
+		}
+		var stats UserStats
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stats, "user_id = ?", userID).Error
+		if err != nil {
+			return err
+		}
+		if stats.Overall.Games == 0 {
+			err = rebuildStats(tx, userID)
+		} else {
+			err = addToStats(tx, stats, session)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addToStats(tx *gorm.DB, stats UserStats, session Session) error {
+	color, score, _ := userResult(session, stats.UserID)
+	stats.record(color, score, time.Now())
+	if err := tx.Save(&stats).Error; err != nil {
+		return err
+	}
+	if session.ECO == "" {
+		return nil
+	}
+
+	opening := UserOpening{UserID: stats.UserID, Color: color, ECO: session.ECO, Name: session.Opening}
+	opening.Record.add(score)
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "color"}, {Name: "eco"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"name":   session.Opening,
+			"games":  gorm.Expr("user_openings.games + ?", opening.Games),
+			"wins":   gorm.Expr("user_openings.wins + ?", opening.Wins),
+			"draws":  gorm.Expr("user_openings.draws + ?", opening.Draws),
+			"losses": gorm.Expr("user_openings.losses + ?", opening.Losses),
+		}),
+	}).Create(&opening).Error
+}
+
+/*
+Recompute the user's stats and openings from their saved sessions
+*/
+func rebuildStats(tx *gorm.DB, userID string) error {
+	rows, err := tx.Raw(`SELECT `+sessionColumns+` FROM sessions
+		WHERE (player1_id = ? OR player2_id = ?) AND deleted_at IS NULL
+		ORDER BY created_at, id`, userID, userID).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	stats := UserStats{UserID: userID}
+	openings := map[[2]string]*UserOpening{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return err
+		}
+		color, score, finished := userResult(session, userID)
+		if !finished {
+			continue
+		}
+		stats.record(color, score, session.CreatedAt)
+		if session.ECO == "" {
+			continue
+		}
+		key := [2]string{color, session.ECO}
+		if openings[key] == nil {
+			openings[key] = &UserOpening{UserID: userID, Color: color, ECO: session.ECO, Name: session.Opening}
+		}
+		openings[key].Record.add(score)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := tx.Save(&stats).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&UserOpening{}).Error; err != nil {
+		return err
+	}
+	for _, opening := range openings {
+		if err := tx.Create(opening).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+The user's stats, or empty ones if they haven't finished a game yet
+*/
+func GetUserStats(ctx context.Context, userID string) (UserStats, error) {
+	var stats UserStats
+	err := gormDbWrapper.WithContext(ctx).First(&stats, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return UserStats{UserID: userID}, nil
+	}
+	return stats, err
+}
+
+/*
+The openings the user played most with the color
+*/
+func GetFavouriteOpenings(ctx context.Context, userID, color string, limit int) (openings []UserOpening, err error) {
+	openings = []UserOpening{}
+	result := gormDbWrapper.WithContext(ctx).Where("user_id = ? AND color = ?", userID, color).
+		Order("games DESC, wins DESC, eco").Limit(limit).Find(&openings)
+	if err = result.Error; err != nil {
+		return nil, err
+	}
+
+	return openings, nil
+}
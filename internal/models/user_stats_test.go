@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserStatsRecord(t *testing.T) {
+	var stats UserStats
+	results := []struct {
+		color string
+		score float64
+	}{
+		{ColorWhite, 1}, {ColorBlack, 1}, {ColorWhite, 1}, {ColorBlack, 0.5},
+		{ColorWhite, 0}, {ColorWhite, 0}, {ColorBlack, 1},
+	}
+	start := time.Unix(0, 0)
+	for i, result := range results {
+		stats.record(result.color, result.score, start.Add(time.Duration(i)*time.Hour))
+	}
+
+	if want := (Record{Games: 7, Wins: 4, Draws: 1, Losses: 2}); stats.Overall != want {
+		t.Errorf("overall: got %+v, want %+v", stats.Overall, want)
+	}
+	if want := (Record{Games: 4, Wins: 2, Losses: 2}); stats.White != want {
+		t.Errorf("white: got %+v, want %+v", stats.White, want)
+	}
+	if stats.CurrentStreak != 1 || stats.LongestWinStreak != 3 || stats.LongestLossStreak != 2 {
+		t.Errorf("streaks: got %d, %d, %d, want 1, 3, 2", stats.CurrentStreak, stats.LongestWinStreak, stats.LongestLossStreak)
+	}
+	if stats.RecentForm != "WLLDWWW" {
+		t.Errorf("recent form: got %s, want WLLDWWW", stats.RecentForm)
+	}
+	if !stats.FirstGameAt.Equal(start) || !stats.LastGameAt.Equal(start.Add(6*time.Hour)) {
+		t.Errorf("got games from %v to %v", stats.FirstGameAt, stats.LastGameAt)
+	}
+
+	for i := 0; i < 5; i++ {
+		stats.record(ColorWhite, 0.5, start)
+	}
+	if stats.RecentForm != "DDDDDWLLDW" {
+		t.Errorf("recent form: got %s, want the latest 10 results", stats.RecentForm)
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/matcher"
+	"github.com/bstchow/go-chess-server/pkg/session"
+)
+
+/*
+An open websocket connection and the game its user is in, if any
+*/
+type Connection struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id,omitempty"` // Empty until the client authenticates
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	SessionID   string    `json:"session_id,omitempty"`
+}
+
+/*
+The sessions being played. Sessions ending while they are listed are left out.
+*/
+func (a *Agent) LiveSessions() []session.Info {
+	var infos []session.Info
+	for _, id := range session.SessionIDs() {
+		info, err := session.GetInfo(id)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+/*
+The players waiting for an opponent in each pool
+*/
+func (a *Agent) Queues() []matcher.Queue {
+	return a.matcher.Queues()
+}
+
+/*
+The open websocket connections
+*/
+func (a *Agent) Connections() []Connection {
+	conns := a.wsServer.Conns()
+	connections := make([]Connection, 0, len(conns))
+	for _, conn := range conns {
+		c := Connection{
+			ID:          conn.ID(),
+			UserID:      conn.UserID(),
+			RemoteAddr:  conn.RemoteAddr().String(),
+			ConnectedAt: conn.ConnectedAt(),
+		}
+		if c.UserID != "" {
+			c.SessionID, _ = a.matcher.SessionExists(c.UserID)
+		}
+		connections = append(connections, c)
+	}
+	return connections
+}
+
+/*
+Close a websocket connection as if the client had left. A player in a game
+can rejoin it. Returns false if the connection isn't open.
+*/
+func (a *Agent) Kick(connID string) bool {
+	return a.wsServer.Kick(connID)
+}
@@ -1,21 +1,36 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
 	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/analysis"
 	"github.com/bstchow/go-chess-server/pkg/auth"
 	"github.com/bstchow/go-chess-server/pkg/corenet"
+	"github.com/bstchow/go-chess-server/pkg/leaderboard"
 	"github.com/bstchow/go-chess-server/pkg/logging"
 	"github.com/bstchow/go-chess-server/pkg/matcher"
+	"github.com/bstchow/go-chess-server/pkg/openings"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/rating"
 	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
 	"github.com/bstchow/go-chess-server/pkg/utils"
 
-	"github.com/gorilla/websocket"
+	"github.com/notnil/chess"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type Agent struct {
 	wsServer *corenet.WebSocketServer
 	matcher  *matcher.Matcher
+	analyzer *analysis.Analyzer // Nil when no engine is configured
+	board    *leaderboard.Board
+	saving   atomic.Int32 // Finished games not saved yet
 }
 
 // Return an Agent object which is the center module interacting with other modules
@@ -23,9 +38,19 @@ func NewAgent() *Agent {
 	a := &Agent{
 		wsServer: corenet.NewWebSocketServer(),
 		matcher:  matcher.NewMatcher(),
+		board:    leaderboard.New(),
+	}
+	analyzer, err := analysis.NewAnalyzerFromEnv()
+	if err != nil {
+		logging.Info("games won't be analysed", zap.Error(err))
+	} else {
+		a.analyzer = analyzer
+		a.analyzer.SetAnalysedHandler(a.reviewFairPlay)
 	}
 	a.wsServer.SetMessageHandler(a.handleWebSocketMessage)
 	a.wsServer.SetConnCloseGameHandler(a.playerDisconnectHandler)
+	a.wsServer.SetAuthenticator(validateToken)
+	a.matcher.SetQueueGuard(queueGuard)
 	session.SetGameOverHandler(a.handleSessionGameOver)
 
 	return a
@@ -33,6 +58,9 @@ func NewAgent() *Agent {
 
 // Start the server for handling game session
 func (a *Agent) StartGameServer() error {
+	if err := a.loadLeaderboard(); err != nil {
+		return err
+	}
 	err := a.wsServer.Start()
 	if err != nil {
 		return err
@@ -41,8 +69,53 @@ func (a *Agent) StartGameServer() error {
 	return nil
 }
 
+/*
+Stop the websocket server, closing the connections still open
+*/
+func (a *Agent) Shutdown(ctx context.Context) error {
+	return a.wsServer.Shutdown(ctx)
+}
+
+/*
+Whether the websocket server accepts connections
+*/
+func (a *Agent) Listening() bool {
+	return a.wsServer.Listening()
+}
+
 func (a *Agent) GetSessionCount() int {
-	return len(a.matcher.SessionMap)
+	return session.Count()
+}
+
+/*
+Games being played or still being saved, which a draining server waits for
+*/
+func (a *Agent) LiveGames() int {
+	return session.Count() + int(a.saving.Load())
+}
+
+/*
+Put the player in the matching queue of the pool, as the matching action does
+for websocket clients. The player's connection gets the same messages.
+*/
+func (a *Agent) Seek(player *session.Player, pool string) {
+	ctx, span := tracing.Start(context.Background(), "seek", tracing.String("player_id", player.ID))
+	defer span.End()
+	a.matcher.EnterQueue(ctx, player, player.ConnID, pool, nil)
+}
+
+/*
+Forget a connection that isn't a websocket, e.g. a closed bot API stream
+*/
+func (a *Agent) Disconnect(connID string) {
+	a.playerDisconnectHandler(connID)
+}
+
+/*
+The session the player is in, if any
+*/
+func (a *Agent) SessionOf(playerID string) (string, bool) {
+	return a.matcher.SessionExists(playerID)
 }
 
 /*
@@ -51,35 +124,156 @@ This includes saving the session to the database, close the session
 and remove session from tracking of Matcher
 */
 func (a *Agent) handleSessionGameOver(s *session.GameSession, sessionID string) {
+	// Counted before the session closes so LiveGames never misses the game
+	a.saving.Add(1)
+	defer a.saving.Add(-1)
 	players := s.GetPlayers()
+	// Players may queue again as soon as they learn the game ended
+	session.CloseSession(sessionID)
+	a.matcher.RemoveSession(players[0].ID, players[1].ID)
+	gamesFinished.Inc(s.Method(), s.Outcome().String())
+	ctx, span := tracing.Start(tracing.ContextWithSpanContext(context.Background(), s.Trace()), "gameOver",
+		tracing.String("session_id", sessionID),
+		tracing.String("method", s.Method()),
+		tracing.String("outcome", s.Outcome().String()),
+	)
+	defer span.End()
 	for _, player := range players {
-		player.Conn.WriteJSON(struct {
-			Type string            `json:"type"`
-			Data map[string]string `json:"data"`
-		}{
-			Type: "endgame",
-			Data: map[string]string{
-				"game_outcome": s.Game.Outcome().String(),
+		if player.Conn == nil {
+			continue
+		}
+		player.Conn.WriteJSON(protocol.EndgameResponse{
+			Type: protocol.TypeEndgame,
+			Data: protocol.EndgameData{
+				GameOutcome: s.Outcome().String(),
+				Method:      s.Method(),
+				State:       s.State(),
 			},
 		})
 		player.Conn.Close()
 	}
-	gameMoves := make([]string, 0, len(s.Game.Moves()))
-	for _, move := range s.Game.Moves() {
-		gameMoves = append(gameMoves, move.String())
+	moves := s.UCIMoves()
+	aborted := s.Method() == protocol.MethodAborted
+	eco, opening := openings.Identify(moves)
+	moveTimes := make([]int64, 0, len(moves))
+	for _, at := range s.MoveTimes() {
+		moveTimes = append(moveTimes, at.UnixMilli())
 	}
-	if _, err := models.InsertSession(sessionID, players[0].ID, players[1].ID, gameMoves); err != nil {
+	var chat []models.ChatMessage
+	for _, message := range s.ChatLog() {
+		chat = append(chat, models.ChatMessage{
+			UserID: message.From,
+			Text:   message.Text,
+			SentAt: time.UnixMilli(message.SentAt),
+		})
+	}
+	saved, err := models.InsertSession(ctx, models.Session{
+		SessionID: sessionID,
+		Player1ID: players[0].ID,
+		Player2ID: players[1].ID,
+		Moves:     moves,
+		Outcome:   s.Outcome().String(),
+		Method:    s.Method(),
+		Pool:      s.Pool,
+		Variant:   s.Variant,
+		ECO:       eco,
+		Opening:   opening,
+		Rated:     s.Rated && !aborted,
+		StartedAt: s.StartedAt,
+		MoveTimes: moveTimes,
+		Chat:      chat,
+	}, rateGame(s.Outcome()))
+	if err != nil {
 		logging.Error("coulnd't save game", zap.Error(err))
+		return
+	}
+	if aborted {
+		return
+	}
+	if saved.Rated {
+		a.updateLeaderboard(ctx, saved.Pool, players)
+	}
+	// Fair-play checks of rated games wait for the analysis if there is one,
+	// the analyzer runs them without it if the analysis fails
+	review := saved.Rated
+	if a.analyzer != nil {
+		if err := a.analyzer.Enqueue(sessionID, moves); err != nil {
+			logging.Warn("couldn't queue game analysis", zap.String("session_id", sessionID), zap.Error(err))
+		} else {
+			review = false
+		}
+	}
+	if review {
+		go a.reviewFairPlay(sessionID, nil)
+	}
+}
+
+/*
+The rankings of each pool
+*/
+func (a *Agent) Leaderboard() *leaderboard.Board {
+	return a.board
+}
+
+func (a *Agent) loadLeaderboard() error {
+	pools, err := models.GetHumanRatings(context.Background())
+	if err != nil {
+		return err
+	}
+	entries := map[string][]leaderboard.Entry{}
+	for pool, ratings := range pools {
+		for _, r := range ratings {
+			entries[pool] = append(entries[pool], leaderboardEntry(r))
+		}
+	}
+	a.board.Load(entries)
+	return nil
+}
+
+/*
+Move the players of a rated game to their new ratings
+*/
+func (a *Agent) updateLeaderboard(ctx context.Context, pool string, players [2]*session.Player) {
+	for _, player := range players {
+		if player.Bot {
+			continue
+		}
+		r, err := models.GetRating(ctx, player.ID, pool)
+		if err != nil {
+			logging.Error("couldn't load rating for the leaderboard", zap.String("id", player.ID), zap.Error(err))
+			continue
+		}
+		a.board.Update(pool, leaderboardEntry(r))
+	}
+}
+
+func leaderboardEntry(r models.Rating) leaderboard.Entry {
+	return leaderboard.Entry{
+		UserID:       r.UserID,
+		Rating:       r.Rating,
+		Games:        r.Games,
+		LastPlayedAt: r.LastPlayedAt,
+	}
+}
+
+/*
+Rate a game with the outcome from both players' ratings in its pool
+*/
+func rateGame(outcome chess.Outcome) func(white, black models.Rating) (int, int) {
+	return func(white, black models.Rating) (int, int) {
+		return rating.Update(
+			rating.Player{Rating: white.Rating, Games: white.Games},
+			rating.Player{Rating: black.Rating, Games: black.Games},
+			whiteScore(outcome.String()),
+		)
 	}
-	session.CloseSession(sessionID)
-	a.matcher.RemoveSession(players[0].ID, players[1].ID)
 }
 
 /*
 Handler for when a user connection closes
 */
 func (a *Agent) playerDisconnectHandler(connID string) {
-	playerId, ok := a.matcher.ConnMap[connID]
+	playerId, ok := a.matcher.RemoveConn(connID)
 	if !ok {
 		return
 	}
@@ -89,7 +283,7 @@ func (a *Agent) playerDisconnectHandler(connID string) {
 		return
 	}
 
-	err := session.PlayerDisconnect(sessionID, playerId)
+	err := session.PlayerDisconnect(sessionID, playerId, connID)
 	if err != nil {
 		logging.Warn("player disconnected error",
 			zap.String("id", playerId),
@@ -98,8 +292,6 @@ func (a *Agent) playerDisconnectHandler(connID string) {
 		)
 	}
 
-	delete(a.matcher.ConnMap, connID)
-
 	logging.Info("player disconnected",
 		zap.String("id", playerId),
 		zap.String("session_id", sessionID),
@@ -110,78 +302,280 @@ func (a *Agent) playerDisconnectHandler(connID string) {
 
 /*
 * Handler for when user socket sends a message
-* TODO/SPIKE: Should we add explicit resignation message? Or is that a type of move? Currently, only supported by closing ws connection.
  */
-func (a *Agent) handleWebSocketMessage(conn *websocket.Conn, message *corenet.Message, connID *string) {
-	type errorResponse struct {
-		Type  string `json:"type"`
-		Error string `json:"error"`
-	}
-
-	jwtToken, ok := message.Data["jwt_token"].(string)
-	var playerId string
-	claims, authErr := auth.ValidateServerTokenDefault(jwtToken)
-	if authErr != nil {
+func (a *Agent) handleWebSocketMessage(ctx context.Context, conn *corenet.Conn, message *corenet.Message, connID *string) {
+	switch message.Action {
+	case protocol.ActionAuth, protocol.ActionReauth:
+		var req protocol.AuthRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		bindToken(ctx, conn, message.Action, req.JwtToken)
+	case protocol.ActionMatching:
+		var req protocol.MatchingRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		// Games in progress never depend on the token, but new ones need a fresh one
+		if conn.AuthExpired(time.Now()) {
+			auth.RecordFailure(auth.FailureExpired)
+			conn.WriteJSON(protocol.NewError("token expired, reauth required"))
+			return
+		}
+		*connID = utils.GenerateUUID()
 		logging.Info("attempt matchmaking",
-			zap.String("status", "rejected"),
-			zap.String("error", authErr.Error()),
+			zap.String("status", "queued"),
+			zap.String("id", playerId),
 			zap.String("remote_address", conn.RemoteAddr().String()),
 		)
-		conn.WriteJSON(errorResponse{
-			Type:  "error",
-			Error: authErr.Error(),
-		})
-		return
-	}
-	playerId = claims.UserId
-
-	switch message.Action {
-	case "matching":
-		if ok {
-			*connID = utils.GenerateUUID()
-			logging.Info("attempt matchmaking",
-				zap.String("status", "queued"),
-				zap.String("id", playerId),
-				zap.String("remote_address", conn.RemoteAddr().String()),
-			)
-			a.matcher.EnterQueue(&session.Player{
-				Conn: conn,
-				ID:   playerId,
-			}, *connID)
+		player := &session.Player{
+			Conn:   conn,
+			ConnID: *connID,
+			ID:     playerId,
+			Bot:    isBotAccount(ctx, playerId),
+		}
+		if req.Bot {
+			a.matcher.EnterBotGame(ctx, player, *connID, req.Pool, req.BotLevel, req.LastSeq)
 		} else {
-			logging.Info("attempt matchmaking",
+			a.matcher.EnterQueue(ctx, player, *connID, req.Pool, req.LastSeq)
+		}
+	case protocol.ActionMove:
+		var req protocol.MoveRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		if req.SessionID == "" || req.Move == "" {
+			logging.Info("attempt making move",
 				zap.String("status", "rejected"),
 				zap.String("error", "insufficient data"),
 				zap.String("remote_address", conn.RemoteAddr().String()),
 			)
-			conn.WriteJSON(errorResponse{
-				Type:  "error",
-				Error: "insufficient data",
-			})
-		}
-	case "move":
-		sessionID, sessionOK := message.Data["session_id"].(string)
-		move, moveOK := message.Data["move"].(string)
-		if sessionOK && moveOK {
-			logging.Info("attempt making move",
-				zap.String("status", "processing"),
-				zap.String("id", playerId),
-				zap.String("session_id", sessionID),
-				zap.String("move", move),
-				zap.String("remote_address", conn.RemoteAddr().String()),
-			)
-			session.ProcessMove(sessionID, playerId, move)
-		} else {
+			conn.WriteJSON(protocol.NewError("insufficient data"))
+			return
+		}
+		logging.Info("attempt making move",
+			zap.String("status", "processing"),
+			zap.String("id", playerId),
+			zap.String("session_id", req.SessionID),
+			zap.String("move", req.Move),
+			zap.String("remote_address", conn.RemoteAddr().String()),
+		)
+		err := session.SubmitMove(req.SessionID, playerId, session.MoveSubmission{
+			Move:     req.Move,
+			Notation: req.Notation,
+			MoveID:   req.MoveID,
+			Ply:      req.Ply,
+		})
+		if err != nil {
 			logging.Info("attempt making move",
 				zap.String("status", "rejected"),
-				zap.String("error", "insufficient data"),
-				zap.String("remote_address", conn.RemoteAddr().String()),
+				zap.String("id", playerId),
+				zap.String("session_id", req.SessionID),
+				zap.String("error", err.Error()),
 			)
-			conn.WriteJSON(errorResponse{
-				Type:  "error",
-				Error: "insufficient data",
-			})
+			// The session itself answers everything but moves for sessions that don't exist
+			var moveErr *session.MoveError
+			if errors.As(err, &moveErr) && moveErr.Reason == protocol.RejectNoSession &&
+				conn.ProtocolVersion() >= protocol.MoveRejectionVersion {
+				conn.WriteJSON(protocol.MoveRejectedResponse{
+					Type:   protocol.TypeMoveRejected,
+					MoveID: req.MoveID,
+					Reason: moveErr.Reason,
+					Error:  moveErr.Error(),
+				})
+			}
+		}
+	case protocol.ActionResign:
+		var req protocol.ResignRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		if req.SessionID == "" {
+			conn.WriteJSON(protocol.NewError("insufficient data"))
+			return
+		}
+		logging.Info("attempt resign",
+			zap.String("id", playerId),
+			zap.String("session_id", req.SessionID),
+			zap.String("remote_address", conn.RemoteAddr().String()),
+		)
+		if err := session.Resign(req.SessionID, playerId); err != nil {
+			conn.WriteJSON(protocol.NewError("couldn't resign: " + err.Error()))
+		}
+	case protocol.ActionDraw:
+		var req protocol.DrawRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		if req.SessionID == "" || req.Op == "" {
+			conn.WriteJSON(protocol.NewError("insufficient data"))
+			return
+		}
+		if err := session.Draw(req.SessionID, playerId, req.Op); err != nil {
+			conn.WriteJSON(protocol.NewError("couldn't " + req.Op + " draw: " + err.Error()))
+		}
+	case protocol.ActionPremove:
+		var req protocol.PremoveRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		if req.SessionID == "" || req.Op == "" || (req.Op == protocol.PremoveSet && req.Move == "") {
+			conn.WriteJSON(protocol.NewError("insufficient data"))
+			return
+		}
+		err := session.Premove(req.SessionID, playerId, req.Op, session.MoveSubmission{
+			Move:     req.Move,
+			Notation: req.Notation,
+			MoveID:   req.MoveID,
+		})
+		if err != nil {
+			conn.WriteJSON(protocol.NewError("couldn't " + req.Op + " premove: " + err.Error()))
+		}
+	case protocol.ActionChat:
+		var req protocol.ChatRequest
+		if !decodeRequest(conn, message, &req) {
+			return
+		}
+		playerId, ok := authenticate(ctx, conn, message.Action, req.Auth)
+		if !ok {
+			return
+		}
+		if req.SessionID == "" {
+			conn.WriteJSON(protocol.NewError("insufficient data"))
+			return
+		}
+		if err := chatRestriction(ctx, playerId); err != nil {
+			conn.WriteJSON(protocol.NewError("couldn't send chat: " + err.Error()))
+			return
+		}
+		if err := session.Chat(req.SessionID, playerId, req.Text); err != nil {
+			conn.WriteJSON(protocol.NewError("couldn't send chat: " + err.Error()))
 		}
 	default:
+		logging.Info("unknown action",
+			zap.String("action", message.Action),
+			zap.String("remote_address", conn.RemoteAddr().String()),
+		)
+		conn.WriteJSON(protocol.NewError("unknown action: " + message.Action))
+	}
+}
+
+/*
+Whether the user is a bot account. Unknown users are humans that haven't
+been saved yet.
+*/
+func isBotAccount(ctx context.Context, userId string) bool {
+	user, err := models.GetUserById(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Warn("couldn't look up user", zap.String("id", userId), zap.Error(err))
+		}
+		return false
+	}
+	return user.Bot
+}
+
+/*
+Decode the message data into the typed request, replying with an error if it doesn't fit
+*/
+func decodeRequest(conn *corenet.Conn, message *corenet.Message, req interface{}) bool {
+	if err := message.DecodeData(req); err != nil {
+		logging.Info("invalid request data",
+			zap.String("action", message.Action),
+			zap.String("error", err.Error()),
+			zap.String("remote_address", conn.RemoteAddr().String()),
+		)
+		conn.WriteJSON(protocol.NewError("invalid data for action " + message.Action))
+		return false
+	}
+	return true
+}
+
+/*
+Validate a server token, returning the user ID and the token expiry. Tokens
+of banned and suspended users are refused.
+*/
+func validateToken(ctx context.Context, jwtToken string) (string, time.Time, error) {
+	claims, err := auth.ValidateServerTokenDefault(jwtToken)
+	if err != nil {
+		auth.RecordFailure(auth.FailureInvalidToken)
+		return "", time.Time{}, err
+	}
+	if err := playRestriction(ctx, claims.UserId); err != nil {
+		auth.RecordFailure(auth.FailureSanctioned)
+		return "", time.Time{}, err
+	}
+	return claims.UserId, time.Unix(int64(claims.Expiration), 0), nil
+}
+
+/*
+Authenticate the connection with the token, or refresh its token.
+A connection stays bound to the first user it authenticated as.
+*/
+func bindToken(ctx context.Context, conn *corenet.Conn, action string, jwtToken string) bool {
+	userId, expiry, err := validateToken(ctx, jwtToken)
+	if err != nil {
+		logging.Info("attempt "+action,
+			zap.String("status", "rejected"),
+			zap.String("error", err.Error()),
+			zap.String("remote_address", conn.RemoteAddr().String()),
+		)
+		conn.WriteJSON(protocol.NewError(err.Error()))
+		return false
+	}
+	if bound := conn.UserID(); bound != "" && bound != userId {
+		auth.RecordFailure(auth.FailureWrongUser)
+		conn.WriteJSON(protocol.NewError("token belongs to a different user"))
+		return false
+	}
+
+	conn.Authenticate(userId, expiry)
+	if action == protocol.ActionAuth || action == protocol.ActionReauth {
+		conn.WriteJSON(protocol.AuthResponse{
+			Type:      protocol.TypeAuthenticated,
+			UserID:    userId,
+			ExpiresAt: expiry.Unix(),
+		})
+	}
+	return true
+}
+
+/*
+Return the user bound to the connection. Connections that haven't authenticated
+yet may do so with a token in the request data, which is then bound to the
+connection so it is only verified once.
+*/
+func authenticate(ctx context.Context, conn *corenet.Conn, action string, credentials protocol.Auth) (string, bool) {
+	if userId := conn.UserID(); userId != "" {
+		return userId, true
+	}
+	if credentials.JwtToken == "" {
+		auth.RecordFailure(auth.FailureNoToken)
+		conn.WriteJSON(protocol.NewError("not authenticated"))
+		return "", false
+	}
+	if !bindToken(ctx, conn, action, credentials.JwtToken) {
+		return "", false
 	}
+	return conn.UserID(), true
 }
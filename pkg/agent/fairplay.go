@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/analysis"
+	"github.com/bstchow/go-chess-server/pkg/fairplay"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+
+	"go.uber.org/zap"
+)
+
+// Rated games that make up a player's recent performance
+const recentGames = 10
+
+/*
+Run the fair-play checks for both players of a saved rated game, putting
+flagged accounts into the review queue. The report is the game's engine
+analysis, nil if it wasn't analysed.
+*/
+func (a *Agent) reviewFairPlay(sessionID string, report *analysis.Report) {
+	ctx := context.Background()
+	saved, err := models.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		logging.Error("couldn't load game for fair-play review", zap.String("session_id", sessionID), zap.Error(err))
+		return
+	}
+	if !saved.Rated {
+		return
+	}
+
+	var plies []analysis.Ply
+	if report != nil {
+		plies = report.Plies
+	}
+	moveTimes := make([]time.Time, len(saved.MoveTimes))
+	for i, ms := range saved.MoveTimes {
+		moveTimes[i] = time.UnixMilli(ms)
+	}
+
+	for _, white := range []bool{true, false} {
+		playerID, playerRating := saved.Player1ID, saved.WhiteRating
+		if !white {
+			playerID, playerRating = saved.Player2ID, saved.BlackRating
+		}
+		// Bot accounts are engines by definition
+		if isBotAccount(ctx, playerID) {
+			continue
+		}
+		recent, err := models.GetRecentRatedSessions(ctx, playerID, saved.Pool, recentGames)
+		if err != nil {
+			logging.Error("couldn't load recent games", zap.String("id", playerID), zap.Error(err))
+			continue
+		}
+
+		evidence, flagged := fairplay.Check(fairplay.Game{
+			White:     white,
+			Rating:    playerRating,
+			Plies:     plies,
+			MoveTimes: moveTimes,
+			StartedAt: saved.StartedAt,
+			Recent:    results(playerID, recent),
+		})
+		if !flagged {
+			continue
+		}
+		data, err := json.Marshal(evidence)
+		if err != nil {
+			logging.Error("couldn't encode fair-play evidence", zap.Error(err))
+			continue
+		}
+		err = models.CreateFairPlayReview(ctx, &models.FairPlayReview{
+			UserID:    playerID,
+			SessionID: sessionID,
+			Pool:      saved.Pool,
+			Signals:   strings.Join(evidence.Signals, ","),
+			Evidence:  string(data),
+		})
+		if err != nil {
+			logging.Error("couldn't queue fair-play review", zap.String("id", playerID), zap.Error(err))
+			continue
+		}
+		logging.Warn("account flagged for fair-play review",
+			zap.String("id", playerID),
+			zap.String("session_id", sessionID),
+			zap.Strings("signals", evidence.Signals),
+		)
+	}
+}
+
+/*
+The player's results in the games, against their opponents' ratings at the time
+*/
+func results(playerID string, sessions []models.Session) []fairplay.Result {
+	results := make([]fairplay.Result, 0, len(sessions))
+	for _, s := range sessions {
+		score := whiteScore(s.Outcome)
+		opponent := s.BlackRating
+		if s.Player2ID == playerID {
+			score, opponent = 1-score, s.WhiteRating
+		}
+		results = append(results, fairplay.Result{OpponentRating: opponent, Score: score})
+	}
+	return results
+}
+
+/*
+White's score in a game with the outcome, counting unfinished games as draws
+*/
+func whiteScore(outcome string) float64 {
+	switch outcome {
+	case "1-0":
+		return 1
+	case "0-1":
+		return 0
+	}
+	return 0.5
+}
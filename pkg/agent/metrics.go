@@ -0,0 +1,8 @@
+package agent
+
+import (
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+)
+
+var gamesFinished = metrics.NewCounter("chess_games_finished_total",
+	"Finished games, by how they ended and their result", "method", "result")
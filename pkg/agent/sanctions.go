@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/session"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/*
+Load the user to check their sanctions. Unknown users have none, and users
+that can't be loaded are let through rather than locking everyone out while
+the database is unavailable.
+*/
+func sanctionedUser(ctx context.Context, userID string) (models.User, bool) {
+	user, err := models.GetUserById(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logging.Warn("couldn't check sanctions", zap.String("id", userID), zap.Error(err))
+		}
+		return user, false
+	}
+	return user, true
+}
+
+/*
+An error if the user is banned or suspended
+*/
+func playRestriction(ctx context.Context, userID string) error {
+	user, ok := sanctionedUser(ctx, userID)
+	if !ok {
+		return nil
+	}
+	return user.Restriction(time.Now())
+}
+
+/*
+An error if the user may not chat
+*/
+func chatRestriction(ctx context.Context, userID string) error {
+	user, ok := sanctionedUser(ctx, userID)
+	if !ok {
+		return nil
+	}
+	return user.ChatRestriction(time.Now())
+}
+
+/*
+Keep the matcher from queueing banned and suspended users
+*/
+func queueGuard(ctx context.Context, player *session.Player) error {
+	return playRestriction(ctx, player.ID)
+}
+
+/*
+Apply a new ban or suspension of the user right away: their game in
+progress is resigned and their connections are closed. New connections are
+refused when they authenticate.
+*/
+func (a *Agent) EnforceRestriction(userID string) {
+	if sessionID, ok := a.matcher.SessionExists(userID); ok {
+		if err := session.Resign(sessionID, userID); err != nil {
+			logging.Warn("couldn't resign game of restricted user",
+				zap.String("id", userID),
+				zap.String("session_id", sessionID),
+				zap.Error(err),
+			)
+		}
+	}
+	for _, conn := range a.wsServer.Conns() {
+		if conn.UserID() == userID {
+			a.wsServer.Kick(conn.ID())
+		}
+	}
+}
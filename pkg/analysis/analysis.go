@@ -0,0 +1,205 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/bstchow/go-chess-server/pkg/engine"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	Inaccuracy = "inaccuracy"
+	Mistake    = "mistake"
+	Blunder    = "blunder"
+)
+
+/*
+Drops in the mover's winning chances, in percentage points, that make a move
+an inaccuracy, a mistake or a blunder
+*/
+const (
+	inaccuracyDrop = 10
+	mistakeDrop    = 20
+	blunderDrop    = 30
+)
+
+// Evaluations are capped to this when measuring centipawn loss
+const maxCP = 1000
+
+/*
+A Searcher evaluates positions, e.g. an engine.Pool
+*/
+type Searcher interface {
+	Search(ctx context.Context, fen string, moves []string, limits engine.Limits) (engine.Result, error)
+}
+
+/*
+An evaluation from white's point of view
+*/
+type Eval struct {
+	CP   int
+	Mate int // Moves until mate, negative when black mates, zero if there is none
+}
+
+/*
+The evaluation as centipawns, mates being worth more than any material
+*/
+func (e Eval) centipawns() int {
+	switch {
+	case e.Mate > 0:
+		return 100000 - e.Mate
+	case e.Mate < 0:
+		return -100000 - e.Mate
+	}
+	return e.CP
+}
+
+/*
+Chance of white winning in percent, based on lichess' model fitted on rated games
+*/
+func (e Eval) winPercent() float64 {
+	cp := math.Max(-maxCP, math.Min(maxCP, float64(e.centipawns())))
+	return 50 + 50*(2/(1+math.Exp(-0.00368208*cp))-1)
+}
+
+type Ply struct {
+	Move           string // UCI
+	San            string
+	Eval           Eval   // After the move
+	BestMove       string // The engine's choice instead of Move, UCI
+	CPLoss         int
+	Accuracy       float64
+	Classification string // Empty for good moves
+}
+
+type Report struct {
+	Plies         []Ply
+	WhiteAccuracy float64
+	BlackAccuracy float64
+}
+
+/*
+Evaluate every position of the game given as UCI moves from the standard
+starting position, then judge each move by how much it changed the mover's
+winning chances
+*/
+func Analyze(ctx context.Context, searcher Searcher, moves []string, limits engine.Limits) (*Report, error) {
+	game := chess.NewGame()
+	startFEN := game.Position().String()
+	positions := []*chess.Position{game.Position()}
+	sans := make([]string, len(moves))
+	for i, uci := range moves {
+		move, err := chess.UCINotation{}.Decode(game.Position(), uci)
+		if err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		pos := game.Position()
+		if err := game.Move(move); err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		// The played move is tagged with check and mate, unlike the decoded one
+		sans[i] = chess.AlgebraicNotation{}.Encode(pos, game.Moves()[i])
+		positions = append(positions, game.Position())
+	}
+
+	evals := make([]Eval, len(positions))
+	best := make([]string, len(positions))
+	for i, pos := range positions {
+		if len(pos.ValidMoves()) == 0 {
+			evals[i] = finalEval(pos)
+			continue
+		}
+		result, err := searcher.Search(ctx, startFEN, moves[:i], limits)
+		if err != nil {
+			return nil, fmt.Errorf("ply %d: %w", i, err)
+		}
+		evals[i], best[i] = whiteEval(result, pos.Turn()), result.BestMove
+	}
+
+	report := &Report{Plies: make([]Ply, len(moves))}
+	var total [2]float64
+	var count [2]int
+	for i, uci := range moves {
+		white := positions[i].Turn() == chess.White
+		ply := judge(evals[i], evals[i+1], white)
+		ply.Move, ply.San, ply.Eval, ply.BestMove = uci, sans[i], evals[i+1], best[i]
+		report.Plies[i] = ply
+
+		side := 1
+		if white {
+			side = 0
+		}
+		total[side] += ply.Accuracy
+		count[side]++
+	}
+	if count[0] > 0 {
+		report.WhiteAccuracy = round(total[0] / float64(count[0]))
+	}
+	if count[1] > 0 {
+		report.BlackAccuracy = round(total[1] / float64(count[1]))
+	}
+	return report, nil
+}
+
+/*
+Judge the move that changed the evaluation from before to after
+*/
+func judge(before, after Eval, white bool) Ply {
+	winBefore, winAfter := before.winPercent(), after.winPercent()
+	cpBefore, cpAfter := clamp(before.centipawns()), clamp(after.centipawns())
+	if !white {
+		winBefore, winAfter = 100-winBefore, 100-winAfter
+		cpBefore, cpAfter = -cpBefore, -cpAfter
+	}
+
+	var ply Ply
+	ply.CPLoss = max(0, cpBefore-cpAfter)
+	drop := math.Max(0, winBefore-winAfter)
+	// lichess' move accuracy
+	ply.Accuracy = round(math.Max(0, math.Min(100, 103.1668*math.Exp(-0.04354*drop)-3.1669)))
+	switch {
+	case drop >= blunderDrop:
+		ply.Classification = Blunder
+	case drop >= mistakeDrop:
+		ply.Classification = Mistake
+	case drop >= inaccuracyDrop:
+		ply.Classification = Inaccuracy
+	}
+	return ply
+}
+
+/*
+Convert an engine result for the side to move to white's point of view
+*/
+func whiteEval(result engine.Result, turn chess.Color) Eval {
+	eval := Eval{CP: result.ScoreCP, Mate: result.Mate}
+	if turn == chess.Black {
+		eval.CP, eval.Mate = -eval.CP, -eval.Mate
+	}
+	return eval
+}
+
+/*
+Evaluation of a position without legal moves, which engines can't search
+*/
+func finalEval(pos *chess.Position) Eval {
+	if pos.Status() != chess.Checkmate {
+		return Eval{}
+	}
+	// Mated in zero moves is still a mate, against the side to move
+	if pos.Turn() == chess.White {
+		return Eval{Mate: -1}
+	}
+	return Eval{Mate: 1}
+}
+
+func clamp(cp int) int {
+	return max(-maxCP, min(maxCP, cp))
+}
+
+func round(x float64) float64 {
+	return math.Round(x*10) / 10
+}
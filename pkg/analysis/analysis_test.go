@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/engine"
+)
+
+// Fool's mate, with white's second move a blunder
+var foolsMate = []string{"f2f3", "e7e5", "g2g4", "d8h4"}
+
+/*
+Scores for the side to move in each position of foolsMate, by the number of
+moves played
+*/
+type scriptedSearcher struct {
+	mu       sync.Mutex
+	failures int // Searches that fail before the script is followed
+}
+
+func (s *scriptedSearcher) Search(ctx context.Context, fen string, moves []string, limits engine.Limits) (engine.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return engine.Result{}, engine.ErrEngineExited
+	}
+	switch len(moves) {
+	case 0:
+		return engine.Result{BestMove: "e2e4", ScoreCP: 30}, nil
+	case 1:
+		return engine.Result{BestMove: "e7e5", ScoreCP: 150}, nil
+	case 2:
+		return engine.Result{BestMove: "d2d4", ScoreCP: -70}, nil
+	default:
+		return engine.Result{BestMove: "d8h4", Mate: 1}, nil
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	report, err := Analyze(context.Background(), &scriptedSearcher{}, foolsMate, engine.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		san            string
+		eval           Eval
+		classification string
+	}{
+		{"f3", Eval{CP: -150}, Inaccuracy},
+		{"e5", Eval{CP: -70}, ""},
+		{"g4", Eval{Mate: -1}, Blunder},
+		{"Qh4#", Eval{Mate: -1}, ""},
+	}
+	for i, w := range want {
+		ply := report.Plies[i]
+		if ply.San != w.san || ply.Eval != w.eval || ply.Classification != w.classification {
+			t.Errorf("ply %d: got %+v, want %+v", i+1, ply, w)
+		}
+	}
+	if report.Plies[2].BestMove != "d2d4" {
+		t.Errorf("got best move %s, want d2d4", report.Plies[2].BestMove)
+	}
+	if report.WhiteAccuracy >= report.BlackAccuracy {
+		t.Errorf("got accuracies %.1f/%.1f", report.WhiteAccuracy, report.BlackAccuracy)
+	}
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	pending []string
+	saved   chan int // Attempts of saved analyses
+	failed  chan error
+}
+
+func (s *memoryStore) Pending(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, sessionID)
+	return nil
+}
+
+func (s *memoryStore) Save(sessionID string, attempts int, report *Report) error {
+	s.saved <- attempts
+	return nil
+}
+
+func (s *memoryStore) Fail(sessionID string, attempts int, err error) error {
+	s.failed <- err
+	return nil
+}
+
+func TestAnalyzerRetries(t *testing.T) {
+	store := &memoryStore{saved: make(chan int, 1), failed: make(chan error, 1)}
+	searcher := &scriptedSearcher{failures: 1}
+	a := NewAnalyzer(searcher, store, engine.Limits{}, 1, 2, 2)
+	a.retryDelay = time.Millisecond
+	analysed := make(chan bool, 2) // Whether each handled game came with a report
+	a.SetAnalysedHandler(func(sessionID string, report *Report) {
+		analysed <- report != nil
+	})
+
+	if err := a.Enqueue("1", foolsMate); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case attempts := <-store.saved:
+		if attempts != 2 {
+			t.Errorf("saved after %d attempts, want 2", attempts)
+		}
+	case err := <-store.failed:
+		t.Fatalf("analysis failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("analysis never finished")
+	}
+	if !<-analysed {
+		t.Error("handler got no report for an analysed game")
+	}
+
+	searcher.failures = 100
+	if err := a.Enqueue("2", foolsMate); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-store.failed:
+		if !errors.Is(err, engine.ErrEngineExited) {
+			t.Errorf("got %v, want %v", err, engine.ErrEngineExited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("analysis never gave up")
+	}
+	// Fair-play reviews still run without the analysis
+	if <-analysed {
+		t.Error("handler got a report for a failed analysis")
+	}
+}
+
+func TestPGN(t *testing.T) {
+	report, err := Analyze(context.Background(), &scriptedSearcher{}, foolsMate, engine.Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plies []models.AnalysisPly
+	for _, p := range report.Plies {
+		plies = append(plies, models.AnalysisPly{
+			Move: p.Move, EvalCP: p.Eval.CP, Mate: p.Eval.Mate, BestMove: p.BestMove, Classification: p.Classification,
+		})
+	}
+	session := models.Session{Player1ID: "alice", Player2ID: "bob", Moves: foolsMate, Outcome: "0-1", Method: "Checkmate"}
+
+	pgn, err := PGN(session, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(pgn, `[Result "0-1"]`) || !strings.HasSuffix(pgn, "\n1. f3 e5 2. g4 Qh4# 0-1\n") {
+		t.Errorf("got\n%s", pgn)
+	}
+
+	pgn, err = PGN(session, &models.Analysis{Status: models.AnalysisDone, Plies: plies})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"1. f3?! { [%eval -1.50] Inaccuracy. e4 was best. }",
+		"2. g4?? { [%eval #-1] Blunder. d4 was best. }",
+		"2... Qh4# { [%eval #0] } 0-1",
+	} {
+		if !strings.Contains(strings.ReplaceAll(pgn, "\n", " "), want) {
+			t.Errorf("missing %q in\n%s", want, pgn)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bstchow/go-chess-server/internal/models"
+
+	"github.com/notnil/chess"
+)
+
+// Movetext lines are wrapped before this many characters, as the PGN standard asks
+const pgnLineWidth = 80
+
+var glyphs = map[string]string{
+	Inaccuracy: "?!",
+	Mistake:    "?",
+	Blunder:    "??",
+}
+
+/*
+Export a saved session as PGN. With a finished analysis, every move gets a
+comment with its evaluation, and bad moves are marked and name the engine's
+choice instead.
+*/
+func PGN(session models.Session, analysis *models.Analysis) (string, error) {
+	result := session.Outcome
+	if result == "" {
+		result = "*"
+	}
+	var b strings.Builder
+	tag := func(name, value string) {
+		fmt.Fprintf(&b, "[%s \"%s\"]\n", name, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value))
+	}
+	tag("Event", "Casual game")
+	tag("Site", "go-chess-server")
+	if session.CreatedAt.IsZero() {
+		tag("Date", "????.??.??")
+	} else {
+		tag("Date", session.CreatedAt.UTC().Format("2006.01.02"))
+	}
+	tag("Round", "-")
+	tag("White", session.Player1ID)
+	tag("Black", session.Player2ID)
+	tag("Result", result)
+	if session.Method != "" {
+		tag("Termination", session.Method)
+	}
+	if analysis != nil && analysis.Status == models.AnalysisDone {
+		tag("WhiteAccuracy", fmt.Sprintf("%.1f", analysis.WhiteAccuracy))
+		tag("BlackAccuracy", fmt.Sprintf("%.1f", analysis.BlackAccuracy))
+	}
+	b.WriteString("\n")
+
+	var plies []models.AnalysisPly
+	if analysis != nil && analysis.Status == models.AnalysisDone && len(analysis.Plies) == len(session.Moves) {
+		plies = analysis.Plies
+	}
+
+	game := chess.NewGame()
+	var tokens []string
+	for i, uci := range session.Moves {
+		pos := game.Position()
+		move, err := chess.UCINotation{}.Decode(pos, uci)
+		if err != nil {
+			return "", fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		if pos.Turn() == chess.White {
+			tokens = append(tokens, fmt.Sprintf("%d.", i/2+1))
+		} else if i == 0 || plies != nil {
+			// Black's move needs its number again after a comment
+			tokens = append(tokens, fmt.Sprintf("%d...", i/2+1))
+		}
+		if err := game.Move(move); err != nil {
+			return "", fmt.Errorf("ply %d: %w", i+1, err)
+		}
+		san := chess.AlgebraicNotation{}.Encode(pos, game.Moves()[i])
+		if plies == nil {
+			tokens = append(tokens, san)
+			continue
+		}
+		tokens = append(tokens, san+glyphs[plies[i].Classification])
+		mated := game.Position().Status() == chess.Checkmate
+		tokens = append(tokens, strings.Fields(comment(plies[i], pos, mated))...)
+	}
+	tokens = append(tokens, result)
+
+	line := 0
+	for i, token := range tokens {
+		if i > 0 {
+			if line+1+len(token) >= pgnLineWidth {
+				b.WriteString("\n")
+				line = 0
+			} else {
+				b.WriteString(" ")
+				line++
+			}
+		}
+		b.WriteString(token)
+		line += len(token)
+	}
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+/*
+The comment after an analysed move, which was played in pos
+*/
+func comment(ply models.AnalysisPly, pos *chess.Position, mated bool) string {
+	var eval string
+	switch {
+	case mated:
+		eval = "#0"
+	case ply.Mate != 0:
+		eval = fmt.Sprintf("#%d", ply.Mate)
+	default:
+		eval = fmt.Sprintf("%.2f", float64(ply.EvalCP)/100)
+	}
+	text := "{ [%eval " + eval + "]"
+	if ply.Classification != "" {
+		text += " " + strings.ToUpper(ply.Classification[:1]) + ply.Classification[1:] + "."
+		if best, err := (chess.UCINotation{}).Decode(pos, ply.BestMove); err == nil {
+			text += " " + chess.AlgebraicNotation{}.Encode(pos, best) + " was best."
+		}
+	}
+	return text + " }"
+}
@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/internal/models"
+	"github.com/bstchow/go-chess-server/pkg/engine"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+
+	"go.uber.org/zap"
+)
+
+var ErrQueueFull = errors.New("analysis queue is full")
+
+// Wait before retrying a failed job, multiplied by the attempts made so far
+const retryDelay = 5 * time.Second
+
+/*
+Where analyses are stored, the database unless a test says otherwise
+*/
+type Store interface {
+	Pending(sessionID string) error
+	Save(sessionID string, attempts int, report *Report) error
+	Fail(sessionID string, attempts int, err error) error
+}
+
+type job struct {
+	sessionID string
+	moves     []string
+}
+
+/*
+An Analyzer analyses finished games in the background on a fixed number of
+workers. Failed jobs are retried a few times before they are given up.
+*/
+type Analyzer struct {
+	searcher    Searcher
+	store       Store
+	limits      engine.Limits
+	maxAttempts int
+	retryDelay  time.Duration
+	jobs        chan job
+	onAnalysed  func(sessionID string, report *Report)
+}
+
+func NewAnalyzer(searcher Searcher, store Store, limits engine.Limits, workers, queueSize, maxAttempts int) *Analyzer {
+	if workers <= 0 {
+		workers = 1
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	a := &Analyzer{
+		searcher:    searcher,
+		store:       store,
+		limits:      limits,
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+		jobs:        make(chan job, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		go a.work()
+	}
+	return a
+}
+
+/*
+Return an analyzer running ANALYSIS_WORKERS searches at once on its own
+processes of ENGINE_PATH, or engine.ErrNoEngine if no engine is configured
+*/
+func NewAnalyzerFromEnv() (*Analyzer, error) {
+	path := env.GetEnv("ENGINE_PATH")
+	if path == "" {
+		return nil, engine.ErrNoEngine
+	}
+	workers, _ := strconv.Atoi(env.GetEnv("ANALYSIS_WORKERS"))
+	queueSize, _ := strconv.Atoi(env.GetEnv("ANALYSIS_QUEUE_SIZE"))
+	maxAttempts, _ := strconv.Atoi(env.GetEnv("ANALYSIS_MAX_ATTEMPTS"))
+	depth, _ := strconv.Atoi(env.GetEnv("ANALYSIS_DEPTH"))
+	moveTime, _ := strconv.Atoi(env.GetEnv("ANALYSIS_MOVE_TIME"))
+
+	limits := engine.Limits{Depth: depth, MoveTime: time.Duration(moveTime) * time.Millisecond}
+	return NewAnalyzer(engine.NewPool(path, workers), databaseStore{}, limits, workers, queueSize, maxAttempts), nil
+}
+
+/*
+Set the function called with each report once it's stored, or with a nil
+report once a game's analysis failed for good, so games are followed up
+either way. Must be set before the first game is queued.
+*/
+func (a *Analyzer) SetAnalysedHandler(handler func(sessionID string, report *Report)) {
+	a.onAnalysed = handler
+}
+
+/*
+Queue a finished game given as UCI moves for analysis. Fails with
+ErrQueueFull instead of waiting when the workers are too far behind.
+*/
+func (a *Analyzer) Enqueue(sessionID string, moves []string) error {
+	if len(a.jobs) == cap(a.jobs) {
+		return ErrQueueFull
+	}
+	if err := a.store.Pending(sessionID); err != nil {
+		return err
+	}
+	select {
+	case a.jobs <- job{sessionID: sessionID, moves: moves}:
+		return nil
+	default:
+		err := ErrQueueFull
+		a.store.Fail(sessionID, 0, err)
+		return err
+	}
+}
+
+func (a *Analyzer) work() {
+	for j := range a.jobs {
+		a.run(j)
+	}
+}
+
+func (a *Analyzer) run(j job) {
+	var err error
+	for attempt := 1; attempt <= a.maxAttempts; attempt++ {
+		var report *Report
+		report, err = Analyze(context.Background(), a.searcher, j.moves, a.limits)
+		if err == nil {
+			err = a.store.Save(j.sessionID, attempt, report)
+		}
+		if err == nil {
+			logging.Info("game analysed", zap.String("session_id", j.sessionID), zap.Int("attempts", attempt))
+			if a.onAnalysed != nil {
+				a.onAnalysed(j.sessionID, report)
+			}
+			return
+		}
+		logging.Warn("game analysis failed",
+			zap.String("session_id", j.sessionID),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		if attempt < a.maxAttempts {
+			time.Sleep(time.Duration(attempt) * a.retryDelay)
+		}
+	}
+	if err := a.store.Fail(j.sessionID, a.maxAttempts, err); err != nil {
+		logging.Error("couldn't record failed analysis", zap.String("session_id", j.sessionID), zap.Error(err))
+	}
+	if a.onAnalysed != nil {
+		a.onAnalysed(j.sessionID, nil)
+	}
+}
+
+type databaseStore struct{}
+
+func (databaseStore) Pending(sessionID string) error {
+	return models.CreatePendingAnalysis(context.Background(), sessionID)
+}
+
+func (databaseStore) Save(sessionID string, attempts int, report *Report) error {
+	plies := make([]models.AnalysisPly, len(report.Plies))
+	for i, p := range report.Plies {
+		plies[i] = models.AnalysisPly{
+			Ply:            i + 1,
+			Move:           p.Move,
+			San:            p.San,
+			EvalCP:         p.Eval.CP,
+			Mate:           p.Eval.Mate,
+			BestMove:       p.BestMove,
+			CPLoss:         p.CPLoss,
+			Accuracy:       p.Accuracy,
+			Classification: p.Classification,
+		}
+	}
+	return models.SaveAnalysis(context.Background(), sessionID, attempts, report.WhiteAccuracy, report.BlackAccuracy, plies)
+}
+
+func (databaseStore) Fail(sessionID string, attempts int, err error) error {
+	return models.FailAnalysis(context.Background(), sessionID, attempts, err)
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+)
+
+// Reasons an authentication attempt fails
+const (
+	FailureNoToken          = "no_token"
+	FailureInvalidToken     = "invalid_token"
+	FailureExpired          = "expired"
+	FailureWrongUser        = "wrong_user" // A token for another user than the one the connection is bound to
+	FailureSanctioned       = "sanctioned" // Banned or suspended
+	FailureInvalidPrivyJWT  = "invalid_privy_jwt"
+	FailureInvalidFrame     = "invalid_frame_signature"
+	FailureAdminCredentials = "admin_credentials"
+)
+
+var failures = metrics.NewCounter("chess_auth_failures_total",
+	"Rejected authentication attempts, by reason", "reason")
+
+/*
+Count a rejected authentication attempt
+*/
+func RecordFailure(reason string) {
+	failures.Inc(reason)
+}
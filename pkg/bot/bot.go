@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+const (
+	// Times the bot submits a move for one turn before waiting for a new state
+	moveAttempts = 3
+	// Delay before the first retry of a rejected move, growing with each retry
+	retryDelay = 100 * time.Millisecond
+)
+
+/*
+An Engine picks the move to play in a position
+*/
+type Engine interface {
+	BestMove(pos *chess.Position) (*chess.Move, error)
+}
+
+/*
+The parts of the session messages a bot acts on. Bots read the same JSON a
+websocket client would.
+*/
+type message struct {
+	Type        string               `json:"type"`
+	SessionID   string               `json:"session_id"`
+	State       *protocol.GameState  `json:"state"`
+	PlayerState protocol.PlayerState `json:"player_state"`
+}
+
+/*
+A Bot is a player whose moves are picked by an Engine. It takes the place of
+a websocket connection, see Player. Only the latest game state matters to a
+bot, so messages are never queued and the session is never blocked by a bot
+that is still thinking.
+*/
+type Bot struct {
+	ID     string
+	engine Engine
+
+	sessionID string
+	latest    *message      // Newest game state not acted on yet
+	wake      chan struct{} // Signalled when latest changes
+	done      chan struct{}
+	closed    bool
+	mu        sync.Mutex
+
+	lastPly int // Last ply the bot played, only used by the bot's goroutine
+}
+
+/*
+Create a bot and start its goroutine
+*/
+func New(id string, engine Engine) *Bot {
+	b := &Bot{
+		ID:     id,
+		engine: engine,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+/*
+The bot as a session player
+*/
+func (b *Bot) Player() *session.Player {
+	return &session.Player{Conn: b, ID: b.ID, Bot: true}
+}
+
+/*
+Receive a message from the session the bot plays in
+*/
+func (b *Bot) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errors.New("bot stopped")
+	}
+	// A message may bring the session id after the state it is needed for, so
+	// either one can make the bot move
+	if msg.SessionID != "" {
+		b.sessionID = msg.SessionID
+	}
+	// Messages may arrive out of order when the matcher and the session both write
+	if msg.State != nil && (b.latest == nil || len(msg.State.Moves) >= len(b.latest.State.Moves)) {
+		b.latest = &msg
+	}
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (b *Bot) ProtocolVersion() int {
+	return protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+}
+
+/*
+Stop the bot, e.g. when its game ended
+*/
+func (b *Bot) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	return nil
+}
+
+func (b *Bot) run() {
+	for {
+		select {
+		case <-b.wake:
+			b.mu.Lock()
+			msg, sessionID := b.latest, b.sessionID
+			b.mu.Unlock()
+			b.play(sessionID, msg)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+/*
+Move if it's the bot's turn in the given state and it hasn't moved in it yet.
+A rejected move brings no new state, so the bot reads the session's state
+and tries again a few times before giving up.
+*/
+func (b *Bot) play(sessionID string, msg *message) {
+	for attempt := 1; ; attempt++ {
+		err := b.move(sessionID, msg)
+		if err == nil || attempt == moveAttempts {
+			return
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * retryDelay):
+		case <-b.done:
+			return
+		}
+		state, err := session.MatchState(sessionID, b.ID)
+		if err != nil {
+			return
+		}
+		msg = &message{
+			Type:        state.Type,
+			SessionID:   state.SessionID,
+			State:       state.State,
+			PlayerState: state.PlayerState,
+		}
+	}
+}
+
+/*
+Submit the engine's move if it's the bot's turn, returning why the session
+rejected it
+*/
+func (b *Bot) move(sessionID string, msg *message) error {
+	if msg == nil || msg.State == nil || sessionID == "" {
+		return nil
+	}
+	state := msg.State
+	ply := len(state.Moves) + 1
+	if state.Status != protocol.StatusActive ||
+		state.IsWhiteTurn != msg.PlayerState.IsWhiteSide || ply <= b.lastPly {
+		return nil
+	}
+
+	fen, err := chess.FEN(state.Fen)
+	if err != nil {
+		logging.Error("bot got an invalid position", zap.String("id", b.ID), zap.Error(err))
+		return nil
+	}
+	move, err := b.engine.BestMove(chess.NewGame(fen).Position())
+	if err != nil {
+		logging.Error("bot couldn't pick a move", zap.String("id", b.ID), zap.Error(err))
+		return nil
+	}
+
+	err = session.SubmitMove(sessionID, b.ID, session.MoveSubmission{
+		Move:     move.String(),
+		Notation: protocol.NotationUCI,
+		Ply:      ply,
+	})
+	if err != nil {
+		logging.Warn("bot move rejected",
+			zap.String("id", b.ID),
+			zap.String("session_id", sessionID),
+			zap.String("move", move.String()),
+			zap.Error(err),
+		)
+		return err
+	}
+	b.lastPly = ply
+	return nil
+}
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+
+	"github.com/notnil/chess"
+)
+
+func TestBotsPlayEachOther(t *testing.T) {
+	white, black := New("white-bot", NewSearcher(MinLevel)), New("black-bot", NewSearcher(MinLevel))
+	session.InitSession("bots", white.Player(), black.Player(), session.Settings{})
+	defer session.CloseSession("bots")
+	defer white.Close()
+	defer black.Close()
+
+	for _, b := range []*Bot{white, black} {
+		state, err := session.MatchState("bots", b.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteJSON(state)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		state, err := session.MatchState("bots", white.ID)
+		if err != nil {
+			// The game ended early
+			return
+		}
+		if len(state.State.Moves) >= 6 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("bots didn't play")
+}
+
+/*
+An engine whose first move is one of black's, which the session rejects
+*/
+type rejectedFirstEngine struct {
+	Engine
+	calls int
+}
+
+func (e *rejectedFirstEngine) BestMove(pos *chess.Position) (*chess.Move, error) {
+	e.calls++
+	if e.calls == 1 {
+		black := chess.NewGame(chess.UseNotation(chess.UCINotation{}))
+		black.MoveStr("e2e4")
+		return chess.UCINotation{}.Decode(black.Position(), "e7e5")
+	}
+	return e.Engine.BestMove(pos)
+}
+
+func TestBotRetriesRejectedMove(t *testing.T) {
+	engine := &rejectedFirstEngine{Engine: NewSearcher(MinLevel)}
+	b := &Bot{ID: "bot", engine: engine}
+	session.InitSession("retry", b.Player(), &session.Player{ID: "opponent"}, session.Settings{})
+	defer session.CloseSession("retry")
+	state, err := session.MatchState("retry", b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.play("retry", &message{SessionID: "retry", State: state.State, PlayerState: state.PlayerState})
+	if engine.calls != 2 {
+		t.Errorf("engine asked %d times, want 2", engine.calls)
+	}
+	if b.lastPly != 1 {
+		t.Errorf("lastPly = %d, want 1", b.lastPly)
+	}
+	if state, _ = session.MatchState("retry", b.ID); len(state.State.Moves) != 1 {
+		t.Errorf("%d moves played, want 1", len(state.State.Moves))
+	}
+}
+
+func TestBotGivesUpWithoutSession(t *testing.T) {
+	b := &Bot{ID: "bot", engine: NewSearcher(MinLevel)}
+	b.play("missing-session", &message{
+		State: &protocol.GameState{
+			Fen:         chess.StartingPosition().String(),
+			Status:      protocol.StatusActive,
+			IsWhiteTurn: true,
+		},
+		PlayerState: protocol.PlayerState{IsWhiteSide: true},
+	})
+	if b.lastPly != 0 {
+		t.Fatalf("lastPly = %d after a rejected move, want 0", b.lastPly)
+	}
+}
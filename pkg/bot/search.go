@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+
+	"github.com/notnil/chess"
+)
+
+// Strength levels of the built-in engine
+const (
+	MinLevel = 1
+	MaxLevel = 5
+)
+
+// Search depth and root noise in centipawns, indexed by level
+var levels = [MaxLevel + 1]struct {
+	depth int
+	noise int
+}{
+	1: {depth: 1, noise: 150},
+	2: {depth: 2, noise: 80},
+	3: {depth: 2, noise: 20},
+	4: {depth: 3, noise: 0},
+	5: {depth: 4, noise: 0},
+}
+
+const (
+	infinity        = 1 << 20
+	mateScore       = 1 << 16
+	quiescenceDepth = 4
+)
+
+var pieceValues = map[chess.PieceType]int{
+	chess.Pawn:   100,
+	chess.Knight: 320,
+	chess.Bishop: 330,
+	chess.Rook:   500,
+	chess.Queen:  900,
+}
+
+var ErrNoMoves = errors.New("no legal moves")
+
+/*
+The built-in engine: a fixed depth alpha-beta search followed by a capture
+search, scoring positions by material and piece placement. Lower levels
+search shallower and add random noise to the scores of their candidate moves.
+*/
+type Searcher struct {
+	Depth int
+	Noise int // Centipawns
+}
+
+/*
+Return the built-in engine at the given strength level, clamped to
+MinLevel..MaxLevel
+*/
+func NewSearcher(level int) *Searcher {
+	level = ClampLevel(level)
+	return &Searcher{Depth: levels[level].depth, Noise: levels[level].noise}
+}
+
+func ClampLevel(level int) int {
+	if level < MinLevel {
+		return MinLevel
+	}
+	if level > MaxLevel {
+		return MaxLevel
+	}
+	return level
+}
+
+func (s *Searcher) BestMove(pos *chess.Position) (*chess.Move, error) {
+	moves := orderMoves(pos, pos.ValidMoves())
+	if len(moves) == 0 {
+		return nil, ErrNoMoves
+	}
+
+	var best *chess.Move
+	bestScore, alpha := -infinity, -infinity
+	for _, move := range moves {
+		score := -s.negamax(pos.Update(move), s.Depth-1, -infinity, -alpha, 1)
+		if s.Noise > 0 {
+			score += rand.Intn(2*s.Noise+1) - s.Noise
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = move, score
+		}
+		// Noisy searches need exact scores for every move, not just the best one
+		if s.Noise == 0 && score > alpha {
+			alpha = score
+		}
+	}
+	return best, nil
+}
+
+func (s *Searcher) negamax(pos *chess.Position, depth, alpha, beta, ply int) int {
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		return terminalScore(pos, ply)
+	}
+	if depth <= 0 {
+		return quiesce(pos, alpha, beta, quiescenceDepth)
+	}
+
+	for _, move := range orderMoves(pos, moves) {
+		score := -s.negamax(pos.Update(move), depth-1, -beta, -alpha, ply+1)
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+/*
+Only look at captures, so the search doesn't stop in the middle of an exchange
+*/
+func quiesce(pos *chess.Position, alpha, beta, depth int) int {
+	standPat := evaluate(pos)
+	if standPat >= beta {
+		return beta
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+	if depth == 0 {
+		return alpha
+	}
+
+	for _, move := range orderMoves(pos, pos.ValidMoves()) {
+		if !move.HasTag(chess.Capture) {
+			continue
+		}
+		score := -quiesce(pos.Update(move), -beta, -alpha, depth-1)
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+/*
+Score of a position without legal moves, preferring quicker mates
+*/
+func terminalScore(pos *chess.Position, ply int) int {
+	if pos.Status() == chess.Checkmate {
+		return -mateScore + ply
+	}
+	return 0
+}
+
+/*
+Sort captures first, most valuable victim and least valuable attacker first,
+so alpha-beta cuts off early
+*/
+func orderMoves(pos *chess.Position, moves []*chess.Move) []*chess.Move {
+	board := pos.Board()
+	priority := func(move *chess.Move) int {
+		p := pieceValues[move.Promo()]
+		if move.HasTag(chess.Capture) {
+			p += 10*pieceValues[board.Piece(move.S2()).Type()] - pieceValues[board.Piece(move.S1()).Type()] + 1
+		}
+		return p
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		return priority(moves[i]) > priority(moves[j])
+	})
+	return moves
+}
+
+/*
+Material and piece placement from the point of view of the side to move
+*/
+func evaluate(pos *chess.Position) int {
+	score := 0
+	for sq, piece := range pos.Board().SquareMap() {
+		value := pieceValues[piece.Type()] + placement(piece, sq)
+		if piece.Color() == pos.Turn() {
+			score += value
+		} else {
+			score -= value
+		}
+	}
+	return score
+}
+
+func placement(piece chess.Piece, sq chess.Square) int {
+	file, rank := int(sq.File()), int(sq.Rank())
+	if piece.Color() == chess.Black {
+		rank = 7 - rank
+	}
+	// 0 on the edges up to 6 on the four center squares
+	center := 7 - (abs(2*file-7)+abs(2*rank-7))/2
+
+	switch piece.Type() {
+	case chess.Pawn:
+		return (rank-1)*8 + center*2
+	case chess.Knight, chess.Bishop:
+		return center * 5
+	case chess.Queen:
+		return center * 2
+	case chess.King:
+		return -center * 5
+	}
+	return 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestSearcherBestMove(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		want string
+	}{
+		{"mate in one", "6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1", "a1a8"},
+		{"hanging queen", "rnb1kbnr/pppp1ppp/8/4p1q1/3P4/2N5/PPP1PPPP/R1BQKBNR w KQkq - 0 1", "c1g5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fen, err := chess.FEN(tt.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, level := range []int{4, MaxLevel} {
+				move, err := NewSearcher(level).BestMove(chess.NewGame(fen).Position())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if move.String() != tt.want {
+					t.Errorf("level %d: got %s, want %s", level, move, tt.want)
+				}
+			}
+		})
+	}
+}
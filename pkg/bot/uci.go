@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/engine"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+// Search limits of an external engine, indexed by level
+var uciLevels = [MaxLevel + 1]engine.Limits{
+	1: {Depth: 1, MoveTime: 100 * time.Millisecond},
+	2: {Depth: 3, MoveTime: 200 * time.Millisecond},
+	3: {Depth: 6, MoveTime: 500 * time.Millisecond},
+	4: {Depth: 10, MoveTime: time.Second},
+	5: {MoveTime: 2 * time.Second},
+}
+
+/*
+Plays with an external UCI engine from a pool. If the engine fails, the
+built-in engine at the same level picks the move so the game goes on.
+*/
+type UCIEngine struct {
+	pool     *engine.Pool
+	limits   engine.Limits
+	fallback *Searcher
+}
+
+/*
+Return the engine a bot at the given level plays with: the external engine
+if there is a pool, the built-in one otherwise
+*/
+func NewEngine(level int, pool *engine.Pool) Engine {
+	level = ClampLevel(level)
+	if pool == nil {
+		return NewSearcher(level)
+	}
+	return &UCIEngine{pool: pool, limits: uciLevels[level], fallback: NewSearcher(level)}
+}
+
+func (e *UCIEngine) BestMove(pos *chess.Position) (*chess.Move, error) {
+	result, err := e.pool.Search(context.Background(), pos.String(), nil, e.limits)
+	if err == nil {
+		var move *chess.Move
+		if move, err = (chess.UCINotation{}).Decode(pos, result.BestMove); err == nil {
+			return move, nil
+		}
+	}
+	logging.Warn("engine failed, using the built-in engine", zap.Error(err))
+	return e.fallback.BestMove(pos)
+}
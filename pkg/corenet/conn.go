@@ -0,0 +1,253 @@
+package corenet
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/utils"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrConnClosed   = errors.New("connection closed")
+	ErrSlowConsumer = errors.New("send buffer overflow")
+)
+
+/*
+Tunables for a single client connection
+*/
+type ConnConfig struct {
+	SendBuffer   int           // Outbound messages queued before the client is considered too slow
+	WriteTimeout time.Duration // Deadline for writing a single frame
+	PingInterval time.Duration // How often to ping the client, zero disables pings
+	PongTimeout  time.Duration // How long a client may stay silent before it is considered dead
+}
+
+/*
+Read the connection tunables from the environment
+*/
+func ConnConfigFromEnv() ConnConfig {
+	sendBuffer, _ := strconv.Atoi(env.GetEnv("WS_SEND_BUFFER"))
+	writeTimeout, _ := strconv.Atoi(env.GetEnv("WS_WRITE_TIMEOUT"))
+	pingInterval, _ := strconv.Atoi(env.GetEnv("WS_PING_INTERVAL"))
+	pongTimeout, _ := strconv.Atoi(env.GetEnv("WS_PONG_TIMEOUT"))
+	return ConnConfig{
+		SendBuffer:   sendBuffer,
+		WriteTimeout: time.Duration(writeTimeout) * time.Second,
+		PingInterval: time.Duration(pingInterval) * time.Second,
+		PongTimeout:  time.Duration(pongTimeout) * time.Second,
+	}
+}
+
+/*
+A Conn wraps a websocket connection with a buffered outbound queue drained by
+a single writer goroutine. gorilla/websocket connections support only one
+concurrent writer, so every server push must go through a Conn.
+A client that doesn't keep up with its queue is disconnected.
+*/
+type Conn struct {
+	id              string
+	connectedAt     time.Time
+	ws              *websocket.Conn
+	send            chan []byte
+	config          ConnConfig
+	protocolVersion int
+	userID          string
+	authExpiry      time.Time
+	closed          bool
+	mu              sync.Mutex
+}
+
+/*
+Wrap a websocket connection and start its writer goroutine
+*/
+func NewConn(ws *websocket.Conn, config ConnConfig) *Conn {
+	c := &Conn{
+		id:              utils.GenerateUUID(),
+		connectedAt:     time.Now(),
+		ws:              ws,
+		send:            make(chan []byte, config.SendBuffer),
+		config:          config,
+		protocolVersion: protocol.DefaultVersion,
+	}
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
+	c.extendReadDeadline()
+	go c.writePump()
+	return c
+}
+
+/*
+Read the next data message. Must only be called from the connection's read loop.
+*/
+func (c *Conn) ReadMessage() ([]byte, error) {
+	_, message, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	c.extendReadDeadline()
+	return message, nil
+}
+
+/*
+Queue a JSON message for the client. Never blocks; if the queue is full the
+connection is dropped and ErrSlowConsumer is returned.
+*/
+func (c *Conn) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrConnClosed
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		logging.Warn("dropping slow websocket consumer",
+			zap.String("remote_address", c.RemoteAddr().String()),
+			zap.Int("buffered", len(c.send)),
+		)
+		c.closed = true
+		close(c.send)
+		c.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ErrSlowConsumer.Error()),
+			time.Now().Add(c.config.WriteTimeout),
+		)
+		c.ws.Close()
+		return ErrSlowConsumer
+	}
+}
+
+/*
+Close the connection once the already queued messages have been written
+*/
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.send)
+	return nil
+}
+
+/*
+Protocol version negotiated with the client
+*/
+func (c *Conn) ProtocolVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocolVersion
+}
+
+func (c *Conn) SetProtocolVersion(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protocolVersion = version
+}
+
+/*
+Bind an authenticated user to the connection until the given expiry
+*/
+func (c *Conn) Authenticate(userID string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userID = userID
+	c.authExpiry = expiry
+}
+
+/*
+The user bound to the connection, or an empty string if it isn't authenticated
+*/
+func (c *Conn) UserID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userID
+}
+
+/*
+Report whether the token the connection was authenticated with has expired
+*/
+func (c *Conn) AuthExpired(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userID != "" && now.After(c.authExpiry)
+}
+
+/*
+Identifies the connection for as long as it is open
+*/
+func (c *Conn) ID() string {
+	return c.id
+}
+
+func (c *Conn) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+/*
+Expect a pong or a message within the pong timeout, otherwise reads fail and
+the connection is treated as dead
+*/
+func (c *Conn) extendReadDeadline() {
+	if c.config.PongTimeout > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.config.PongTimeout))
+	}
+}
+
+/*
+The only goroutine writing data frames to the websocket. It also sends the
+keepalive pings.
+*/
+func (c *Conn) writePump() {
+	var ping <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	defer c.ws.Close()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+			if !ok {
+				c.ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				logging.Info("ws write", zap.String("remote_address", c.RemoteAddr().String()), zap.Error(err))
+				return
+			}
+		case <-ping:
+			c.ws.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				logging.Info("ws ping", zap.String("remote_address", c.RemoteAddr().String()), zap.Error(err))
+				return
+			}
+		}
+	}
+}
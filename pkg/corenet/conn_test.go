@@ -0,0 +1,129 @@
+package corenet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestConnPair(t *testing.T, config ConnConfig) (*Conn, *websocket.Conn) {
+	connCh := make(chan *Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		connCh <- NewConn(ws, config)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return <-connCh, client
+}
+
+func TestConnConcurrentWriters(t *testing.T) {
+	conn, client := newTestConnPair(t, ConnConfig{SendBuffer: 256, WriteTimeout: time.Second})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 16; j++ {
+				if err := conn.WriteJSON(map[string]int{"n": j}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	conn.Close()
+
+	received := 0
+	for {
+		var msg map[string]int
+		if err := client.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("unexpected read error: %v", err)
+			}
+			break
+		}
+		received++
+	}
+	if received != 8*16 {
+		t.Errorf("received %d messages, want %d", received, 8*16)
+	}
+
+	if err := conn.WriteJSON("late"); err != ErrConnClosed {
+		t.Errorf("write after close: got %v, want %v", err, ErrConnClosed)
+	}
+}
+
+func TestConnDropsSlowConsumer(t *testing.T) {
+	conn, _ := newTestConnPair(t, ConnConfig{SendBuffer: 2, WriteTimeout: time.Second})
+
+	// The client never reads, so the writer stalls once the socket buffers are full
+	payload := strings.Repeat("x", 1<<20)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = conn.WriteJSON(payload)
+	}
+	if err != ErrSlowConsumer {
+		t.Errorf("got %v, want %v", err, ErrSlowConsumer)
+	}
+}
+
+func TestConnDetectsMissingPongs(t *testing.T) {
+	config := ConnConfig{
+		SendBuffer:   8,
+		WriteTimeout: time.Second,
+		PingInterval: 20 * time.Millisecond,
+		PongTimeout:  100 * time.Millisecond,
+	}
+	readErr := func(conn *Conn) chan error {
+		ch := make(chan error, 1)
+		go func() {
+			_, err := conn.ReadMessage()
+			ch <- err
+		}()
+		return ch
+	}
+
+	// A client that reads answers pings automatically and stays alive
+	alive, client := newTestConnPair(t, config)
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	// A client that never reads never answers pings
+	dead, _ := newTestConnPair(t, config)
+
+	aliveErr, deadErr := readErr(alive), readErr(dead)
+	select {
+	case err := <-deadErr:
+		if err == nil {
+			t.Error("expected read error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dead connection not detected")
+	}
+	select {
+	case err := <-aliveErr:
+		t.Fatalf("live connection timed out: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
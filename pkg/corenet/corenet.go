@@ -1,35 +1,71 @@
 package corenet
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/health"
 	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
+const (
+	// Subprotocol the server selects, so clients can send their token as a second subprotocol
+	Subprotocol             = "chess"
+	bearerSubprotocolPrefix = "bearer."
+)
+
 type WebSocketServer struct {
 	address              string
 	upgrader             websocket.Upgrader
-	messageHandler       func(*websocket.Conn, *Message, *string)
+	connConfig           ConnConfig
+	messageHandler       func(context.Context, *Conn, *Message, *string)
 	connCloseGameHandler func(string)
+	authenticator        func(context.Context, string) (string, time.Time, error)
+	conns                map[string]*Conn // Open connections by id
+	connsMu              sync.Mutex
+	listening            atomic.Bool
+	server               *http.Server
 }
 
 type Message struct {
-	Action string                 `json:"action"`
-	Data   map[string]interface{} `json:"data"`
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+}
+
+/*
+Decode the message data into the request struct for its action
+*/
+func (m *Message) DecodeData(v interface{}) error {
+	if len(m.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(m.Data, v)
 }
 
 func NewWebSocketServer() *WebSocketServer {
 	port := env.GetEnv("WS_PORT")
 	return &WebSocketServer{
-		address: "0.0.0.0:" + port,
+		address:    "0.0.0.0:" + port,
+		connConfig: ConnConfigFromEnv(),
+		conns:      map[string]*Conn{},
+		server:     &http.Server{},
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
+			Subprotocols:    []string{Subprotocol},
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins
 			},
@@ -38,9 +74,10 @@ func NewWebSocketServer() *WebSocketServer {
 }
 
 /*
-Set message handler for incoming websocket message
+Set message handler for incoming websocket message. Each message is handled
+in a span of its own, named after the action, carried by the context.
 */
-func (s *WebSocketServer) SetMessageHandler(msgHandler func(*websocket.Conn, *Message, *string)) {
+func (s *WebSocketServer) SetMessageHandler(msgHandler func(context.Context, *Conn, *Message, *string)) {
 	s.messageHandler = msgHandler
 }
 
@@ -52,26 +89,161 @@ func (s *WebSocketServer) SetConnCloseGameHandler(ccgHandler func(string)) {
 }
 
 /*
-Start the websocket server
+Set the function validating tokens presented during the upgrade. It returns
+the user ID and the token expiry, and is given the context of the upgrade's
+span.
+*/
+func (s *WebSocketServer) SetAuthenticator(authenticator func(context.Context, string) (string, time.Time, error)) {
+	s.authenticator = authenticator
+}
+
+/*
+The open connections
+*/
+func (s *WebSocketServer) Conns() []*Conn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+/*
+Close the connection with the given id once its queued messages are written.
+The close is then handled like the client going away. Returns false if no
+such connection is open.
+*/
+func (s *WebSocketServer) Kick(connID string) bool {
+	s.connsMu.Lock()
+	conn, ok := s.conns[connID]
+	s.connsMu.Unlock()
+	if !ok {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (s *WebSocketServer) addConn(conn *Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	s.conns[conn.ID()] = conn
+	openConns.Inc()
+	acceptedConns.Inc()
+}
+
+func (s *WebSocketServer) removeConn(conn *Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, conn.ID())
+	openConns.Dec()
+}
+
+/*
+Find a token presented during the upgrade, in order of preference:
+the Authorization header, a "bearer.<token>" subprotocol or the token query parameter.
+Browsers can't set headers on websocket requests, hence the alternatives.
+*/
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	for _, subprotocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(subprotocol, bearerSubprotocolPrefix) {
+			return strings.TrimPrefix(subprotocol, bearerSubprotocolPrefix)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+/*
+Negotiate the protocol version with a client that announced the versions it speaks
+*/
+func handleHello(conn *Conn, msg *Message) {
+	var req protocol.HelloRequest
+	if err := msg.DecodeData(&req); err != nil {
+		conn.WriteJSON(protocol.NewError("invalid hello"))
+		return
+	}
+	version, ok := protocol.Negotiate(req.Versions)
+	if !ok {
+		conn.WriteJSON(protocol.NewError("unsupported protocol version"))
+		return
+	}
+	conn.SetProtocolVersion(version)
+	conn.WriteJSON(protocol.NewHello(version))
+}
+
+/*
+Whether the server is bound to its address and accepting connections
+*/
+func (s *WebSocketServer) Listening() bool {
+	return s.listening.Load()
+}
+
+/*
+Start the websocket server. It serves /healthz and /readyz too, so load
+balancers can check it on its own port.
 */
 func (s *WebSocketServer) Start() error {
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := s.upgrader.Upgrade(w, r, nil)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health.LiveHandler())
+	mux.Handle("/readyz", health.ReadyHandler())
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		// Clients that still reach a draining server are sent elsewhere
+		if health.Draining() {
+			http.Error(w, "server is draining", http.StatusServiceUnavailable)
+			return
+		}
+		var userID string
+		var authExpiry time.Time
+		if token := tokenFromRequest(r); token != "" && s.authenticator != nil {
+			ctx, span := tracing.Start(r.Context(), "upgrade", tracing.String("transport", "websocket"))
+			var err error
+			userID, authExpiry, err = s.authenticator(ctx, token)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				logging.Info("websocket upgrade rejected",
+					zap.String("error", err.Error()),
+					zap.String("remote_address", r.RemoteAddr),
+				)
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ws, err := s.upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			logging.Error("failed to upgrade connection", zap.String("error", err.Error()))
 			return
 		}
+		conn := NewConn(ws, s.connConfig)
 		defer conn.Close()
+		s.addConn(conn)
+		defer s.removeConn(conn)
+		if userID != "" {
+			conn.Authenticate(userID, authExpiry)
+		}
+		hello := protocol.NewHello(protocol.DefaultVersion)
+		hello.UserID = userID
+		conn.WriteJSON(hello)
 		var connID string
 		for {
-			_, message, err := conn.ReadMessage()
+			// Reads fail once the client misses its pongs, which is handled like any other disconnect
+			message, err := conn.ReadMessage()
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseMessage, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					logging.Info("unexpected close error", zap.String("remote_address", conn.RemoteAddr().String()))
+					disconnects.Inc("unexpected_close")
 				} else if websocket.IsCloseError(err, websocket.CloseMessage, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					logging.Info("close error", zap.String("remote_address", conn.RemoteAddr().String()), zap.String("Error", err.Error()))
+					disconnects.Inc("close")
 				} else {
-					logging.Info("ws message read error", zap.String("remote_address", conn.RemoteAddr().String()))
+					logging.Info("ws message read error", zap.String("remote_address", conn.RemoteAddr().String()), zap.Error(err))
+					disconnects.Inc("error")
 				}
 				s.connCloseGameHandler(connID)
 				break
@@ -79,11 +251,46 @@ func (s *WebSocketServer) Start() error {
 
 			msg := Message{}
 			if err := json.Unmarshal(message, &msg); err != nil {
-				conn.Close()
+				conn.WriteJSON(protocol.NewError("malformed message"))
+				continue
+			}
+			if msg.Action == protocol.ActionHello {
+				handleHello(conn, &msg)
+				continue
+			}
+			ctx, span := tracing.Start(context.Background(), msg.Action,
+				tracing.String("transport", "websocket"),
+				tracing.String("conn_id", conn.ID()),
+			)
+			s.messageHandler(ctx, conn, &msg, &connID)
+			if userID := conn.UserID(); userID != "" {
+				span.SetAttributes(tracing.String("user_id", userID))
 			}
-			s.messageHandler(conn, &msg, &connID)
+			span.End()
 		}
 	})
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	s.listening.Store(true)
+	defer s.listening.Store(false)
 	logging.Info("websocket server started", zap.String("Address", s.address))
-	return http.ListenAndServe(s.address, nil)
+	s.server.Handler = mux
+	if err := s.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+/*
+Stop accepting connections and close the open ones. Websockets are taken
+over from the HTTP server, so it doesn't wait for them.
+*/
+func (s *WebSocketServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	for _, conn := range s.Conns() {
+		conn.Close()
+	}
+	return err
 }
@@ -1,24 +1,40 @@
 package corenet
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/health"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
 	"github.com/gorilla/websocket"
 )
 
 var ch = make(chan bool)
 
+// The server listens on WS_PORT, so it is started once for every run of the test
+var serverOnce sync.Once
+
 func TestWebSocketServer(t *testing.T) {
-	go setupWebSocketServer()
+	go serverOnce.Do(setupWebSocketServer)
 
 	host := env.GetEnv("WS_HOST") + ":" + env.GetEnv("WS_PORT")
 	u := url.URL{Scheme: "ws", Host: host, Path: "/ws"}
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		log.Fatal("dial:", err)
+	c := dialWhenListening(t, u.String())
+
+	var hello protocol.HelloResponse
+	if err := c.ReadJSON(&hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Type != protocol.TypeHello || hello.ProtocolVersion != protocol.DefaultVersion {
+		t.Errorf("got hello %+v", hello)
 	}
 
 	tests := []struct {
@@ -30,9 +46,7 @@ func TestWebSocketServer(t *testing.T) {
 			"Matching request",
 			Message{
 				Action: "matching",
-				Data: map[string]interface{}{
-					"id": "42",
-				},
+				Data:   json.RawMessage(`{"id": "42"}`),
 			},
 			"matching",
 		},
@@ -40,11 +54,7 @@ func TestWebSocketServer(t *testing.T) {
 			"Move request",
 			Message{
 				Action: "move",
-				Data: map[string]interface{}{
-					"session_id": "42",
-					"id":         "42",
-					"move":       "e2-e4",
-				},
+				Data:   json.RawMessage(`{"session_id": "42", "id": "42", "move": "e2-e4"}`),
 			},
 			"move",
 		},
@@ -74,6 +84,53 @@ func TestWebSocketServer(t *testing.T) {
 	}
 }
 
+func TestDrainingRefusesUpgrades(t *testing.T) {
+	go serverOnce.Do(setupWebSocketServer)
+
+	host := env.GetEnv("WS_HOST") + ":" + env.GetEnv("WS_PORT")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + host + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("healthz:", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	health.SetDraining(true)
+	defer health.SetDraining(false)
+	u := url.URL{Scheme: "ws", Host: host, Path: "/ws"}
+	c, resp, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err == nil {
+		c.Close()
+		t.Fatal("upgraded while draining")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got response %v, want 503", resp)
+	}
+}
+
+/*
+Dial the server started in the background, retrying until it listens
+*/
+func dialWhenListening(t *testing.T, url string) *websocket.Conn {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			return c
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dial:", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 func setupWebSocketServer() {
 	wsServer := NewWebSocketServer()
 	wsServer.SetMessageHandler(messageHandler)
@@ -81,42 +138,57 @@ func setupWebSocketServer() {
 	log.Fatal(wsServer.Start())
 }
 
-func messageHandler(conn *websocket.Conn, message *Message, connID *string) {
-	type errorResponse struct {
-		Type  string `json:"type"`
-		Error string `json:"error"`
-	}
+func messageHandler(ctx context.Context, conn *Conn, message *Message, connID *string) {
 	switch message.Action {
 	case "matching":
-		_, ok := message.Data["id"].(string)
-		if ok {
+		var data struct {
+			ID string `json:"id"`
+		}
+		if err := message.DecodeData(&data); err == nil && data.ID != "" {
 			conn.WriteJSON(*message)
 		} else {
-			conn.WriteJSON(errorResponse{
-				Type:  "error",
-				Error: "invalid data",
-			})
+			conn.WriteJSON(protocol.NewError("invalid data"))
 		}
 	case "move":
-		_, playerOK := message.Data["id"].(string)
-		_, sessionOK := message.Data["session_id"].(string)
-		_, moveOK := message.Data["move"].(string)
-		if playerOK && sessionOK && moveOK {
+		var data struct {
+			ID        string `json:"id"`
+			SessionID string `json:"session_id"`
+			Move      string `json:"move"`
+		}
+		if err := message.DecodeData(&data); err == nil && data.ID != "" && data.SessionID != "" && data.Move != "" {
 			conn.WriteJSON(*message)
 		} else {
-			conn.WriteJSON(errorResponse{
-				Type:  "error",
-				Error: "invalid data",
-			})
+			conn.WriteJSON(protocol.NewError("invalid data"))
 		}
 	default:
-		conn.WriteJSON(errorResponse{
-			Type:  "error",
-			Error: "invalid action",
-		})
+		conn.WriteJSON(protocol.NewError("invalid action"))
 	}
 }
 
 func connCloseGameHandler(connID string) {
 	ch <- true
 }
+
+func TestTokenFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		want   string
+	}{
+		{"none", "/ws", http.Header{}, ""},
+		{"authorization header", "/ws", http.Header{"Authorization": {"Bearer abc.def"}}, "abc.def"},
+		{"subprotocol", "/ws", http.Header{"Sec-Websocket-Protocol": {"chess, bearer.abc.def"}}, "abc.def"},
+		{"query", "/ws?token=abc.def", http.Header{}, "abc.def"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header = tt.header
+			if got := tokenFromRequest(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package corenet
+
+import (
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+)
+
+var (
+	openConns = metrics.NewGauge("chess_websocket_connections",
+		"Open websocket connections")
+	acceptedConns = metrics.NewCounter("chess_websocket_connections_total",
+		"Websocket connections accepted")
+	disconnects = metrics.NewCounter("chess_websocket_disconnects_total",
+		"Websocket connections ended, by how the read loop saw them end", "reason")
+)
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEngineExited = errors.New("engine exited")
+	ErrNoMove       = errors.New("engine found no move")
+	ErrTimeout      = errors.New("engine didn't answer in time")
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	stopGrace        = time.Second // How long a search may take to wind down after "stop"
+	quitGrace        = time.Second
+)
+
+/*
+Limits of a single search. Zero values mean no limit; a search without any
+limit only ends when its context does.
+*/
+type Limits struct {
+	Depth    int
+	MoveTime time.Duration
+}
+
+func (l Limits) goCommand() string {
+	cmd := "go"
+	if l.Depth > 0 {
+		cmd += " depth " + strconv.Itoa(l.Depth)
+	}
+	if l.MoveTime > 0 {
+		cmd += " movetime " + strconv.FormatInt(l.MoveTime.Milliseconds(), 10)
+	}
+	if l.Depth <= 0 && l.MoveTime <= 0 {
+		cmd += " infinite"
+	}
+	return cmd
+}
+
+/*
+What a search found, from the point of view of the side to move
+*/
+type Result struct {
+	BestMove string   // UCI
+	Ponder   string   // Expected reply, if the engine gave one
+	Depth    int      // Deepest completed iteration
+	ScoreCP  int      // Centipawns, only meaningful when Mate is zero
+	Mate     int      // Moves until mate, negative when getting mated
+	PV       []string // Principal variation in UCI
+}
+
+/*
+A Process is a running UCI engine. It runs one search at a time.
+*/
+type Process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string   // Output lines, closed when the engine's output ends
+	exited chan struct{} // Closed once the process is reaped
+	mu     sync.Mutex
+}
+
+/*
+Launch the engine binary at path and wait for it to be ready
+*/
+func Start(path string) (*Process, error) {
+	cmd := exec.Command(path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting engine %s: %w", path, err)
+	}
+
+	p := &Process{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan string, 64),
+		exited: make(chan struct{}),
+	}
+	go p.readOutput(stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := p.handshake(ctx); err != nil {
+		p.Kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Process) readOutput(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		p.lines <- strings.TrimSpace(scanner.Text())
+	}
+	close(p.lines)
+	p.cmd.Wait()
+	close(p.exited)
+}
+
+func (p *Process) handshake(ctx context.Context) error {
+	if err := p.send("uci"); err != nil {
+		return err
+	}
+	if err := p.waitFor(ctx, "uciok"); err != nil {
+		return err
+	}
+	if err := p.send("isready"); err != nil {
+		return err
+	}
+	return p.waitFor(ctx, "readyok")
+}
+
+func (p *Process) send(line string) error {
+	_, err := io.WriteString(p.stdin, line+"\n")
+	return err
+}
+
+func (p *Process) waitFor(ctx context.Context, want string) error {
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return ErrEngineExited
+			}
+			if line == want {
+				return nil
+			}
+		case <-ctx.Done():
+			return ErrTimeout
+		}
+	}
+}
+
+/*
+Search the position given as a FEN, after the given UCI moves. When ctx ends
+before the engine is done, the search is stopped and its best move so far is
+returned. An engine that doesn't even answer "stop" fails with ErrTimeout and
+should be killed.
+*/
+func (p *Process) Search(ctx context.Context, fen string, moves []string, limits Limits) (Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	position := "position fen " + fen
+	if len(moves) > 0 {
+		position += " moves " + strings.Join(moves, " ")
+	}
+	if err := p.send(position); err != nil {
+		return Result{}, err
+	}
+	if err := p.send(limits.goCommand()); err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	done := ctx.Done()
+	var stopDeadline <-chan time.Time
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return Result{}, ErrEngineExited
+			}
+			switch {
+			case strings.HasPrefix(line, "info "):
+				parseInfo(line, &result)
+			case strings.HasPrefix(line, "bestmove"):
+				fields := strings.Fields(line)
+				if len(fields) < 2 || fields[1] == "(none)" || fields[1] == "0000" {
+					return result, ErrNoMove
+				}
+				result.BestMove = fields[1]
+				if len(fields) >= 4 && fields[2] == "ponder" {
+					result.Ponder = fields[3]
+				}
+				return result, nil
+			}
+		case <-done:
+			done = nil
+			if err := p.send("stop"); err != nil {
+				return Result{}, err
+			}
+			stopDeadline = time.After(stopGrace)
+		case <-stopDeadline:
+			return Result{}, ErrTimeout
+		}
+	}
+}
+
+/*
+Parse the fields of an "info" line this package cares about into result
+*/
+func parseInfo(line string, result *Result) {
+	fields := strings.Fields(line)
+	var pv []string
+	hasScore := false
+	for i := 1; i < len(fields); i++ {
+		next := func() int {
+			if i+1 >= len(fields) {
+				return 0
+			}
+			i++
+			n, _ := strconv.Atoi(fields[i])
+			return n
+		}
+		switch fields[i] {
+		case "depth":
+			result.Depth = next()
+		case "score":
+			if i+1 < len(fields) {
+				i++
+				hasScore = true
+				switch fields[i] {
+				case "cp":
+					result.ScoreCP, result.Mate = next(), 0
+				case "mate":
+					result.Mate = next()
+				}
+			}
+		case "pv":
+			pv = append([]string{}, fields[i+1:]...)
+			i = len(fields)
+		case "string":
+			// The rest of the line is free text
+			i = len(fields)
+		}
+	}
+	if pv != nil && hasScore {
+		result.PV = pv
+	}
+}
+
+/*
+Ask the engine to quit, killing it if it doesn't
+*/
+func (p *Process) Close() error {
+	p.send("quit")
+	p.stdin.Close()
+	go p.discardOutput()
+	select {
+	case <-p.exited:
+		return nil
+	case <-time.After(quitGrace):
+		return p.Kill()
+	}
+}
+
+func (p *Process) Kill() error {
+	err := p.cmd.Process.Kill()
+	go p.discardOutput()
+	<-p.exited
+	return err
+}
+
+/*
+Keep the output reader from blocking on lines nobody will read
+*/
+func (p *Process) discardOutput() {
+	for range p.lines {
+	}
+}
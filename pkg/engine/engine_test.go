@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const startFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+/*
+The test binary doubles as a stub UCI engine when ENGINE_STUB is set. The
+stub always answers e2e4 after reporting the limits it was given as its depth,
+or never answers searches at all when ENGINE_STUB is "hang".
+*/
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("ENGINE_STUB"); mode != "" {
+		runStub(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runStub(mode string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name stub")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "go":
+			if mode == "hang" {
+				continue
+			}
+			depth := "1"
+			if len(fields) >= 3 && fields[1] == "depth" {
+				depth = fields[2]
+			}
+			fmt.Println("info string thinking")
+			fmt.Printf("info depth %s score cp 31 nodes 20 pv e2e4 e7e5\n", depth)
+			fmt.Println("bestmove e2e4 ponder e7e5")
+		case "quit":
+			return
+		}
+	}
+}
+
+func stubPool(t *testing.T, mode string, size int) *Pool {
+	t.Setenv("ENGINE_STUB", mode)
+	pool := NewPool(os.Args[0], size)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestPoolSearch(t *testing.T) {
+	pool := stubPool(t, "play", 2)
+
+	result, err := pool.Search(context.Background(), startFEN, nil, Limits{Depth: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{BestMove: "e2e4", Ponder: "e7e5", Depth: 7, ScoreCP: 31, PV: []string{"e2e4", "e7e5"}}
+	if fmt.Sprint(result) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", result, want)
+	}
+
+	// Processes are reused
+	for i := 0; i < 5; i++ {
+		if _, err := pool.Search(context.Background(), startFEN, []string{"e2e4"}, Limits{Depth: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pool.idle) != 1 {
+		t.Errorf("got %d idle engines, want 1", len(pool.idle))
+	}
+}
+
+func TestPoolDiscardsHungEngine(t *testing.T) {
+	pool := stubPool(t, "hang", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := pool.Search(ctx, startFEN, nil, Limits{}); err != ErrTimeout {
+		t.Errorf("got %v, want %v", err, ErrTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond+stopGrace+time.Second {
+		t.Errorf("search took %s", elapsed)
+	}
+	if len(pool.idle) != 0 {
+		t.Error("hung engine returned to the pool")
+	}
+}
+
+func TestPoolMissingBinary(t *testing.T) {
+	pool := NewPool("/nonexistent/engine", 1)
+	if _, err := pool.Search(context.Background(), startFEN, nil, Limits{Depth: 1}); err == nil {
+		t.Fatal("search succeeded without an engine")
+	}
+	// The failed start doesn't leak the slot
+	if _, err := pool.Search(context.Background(), startFEN, nil, Limits{Depth: 1}); err == nil || err == context.DeadlineExceeded {
+		t.Fatalf("got %v, want a start error", err)
+	}
+}
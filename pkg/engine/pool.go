@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoEngine   = errors.New("no engine configured")
+	ErrPoolClosed = errors.New("engine pool closed")
+)
+
+// Extra time a search with a move time gets before it is stopped
+const moveTimeGrace = time.Second
+
+/*
+A Pool runs searches on up to size engine processes of the same binary.
+Processes are started on demand and reused; one that fails a search is
+discarded and replaced by the next search that needs it.
+*/
+type Pool struct {
+	path   string
+	slots  chan struct{} // One token per process that may run
+	idle   []*Process
+	closed bool
+	mu     sync.Mutex
+}
+
+func NewPool(path string, size int) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	return &Pool{
+		path:  path,
+		slots: make(chan struct{}, size),
+	}
+}
+
+/*
+Return a pool for ENGINE_PATH with ENGINE_POOL_SIZE processes, or ErrNoEngine
+if no engine is configured
+*/
+func NewPoolFromEnv() (*Pool, error) {
+	path := env.GetEnv("ENGINE_PATH")
+	if path == "" {
+		return nil, ErrNoEngine
+	}
+	size, _ := strconv.Atoi(env.GetEnv("ENGINE_POOL_SIZE"))
+	return NewPool(path, size), nil
+}
+
+/*
+Search the position on an idle engine, waiting for one to become available
+if all of them are busy. Searches with a move time are stopped shortly after
+it passed, even if the engine ignores the limit.
+*/
+func (p *Pool) Search(ctx context.Context, fen string, moves []string, limits Limits) (Result, error) {
+	if limits.MoveTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.MoveTime+moveTimeGrace)
+		defer cancel()
+	}
+
+	process, err := p.acquire(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := process.Search(ctx, fen, moves, limits)
+	if err != nil && err != ErrNoMove {
+		logging.Warn("discarding engine", zap.String("path", p.path), zap.Error(err))
+		process.Kill()
+		process = nil
+	}
+	p.release(process)
+	return result, err
+}
+
+func (p *Pool) acquire(ctx context.Context) (*Process, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return nil, ErrPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		process := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return process, nil
+	}
+	p.mu.Unlock()
+
+	process, err := Start(p.path)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return process, nil
+}
+
+/*
+Return a process to the pool. A nil process frees its slot for a new one.
+*/
+func (p *Pool) release(process *Process) {
+	p.mu.Lock()
+	if process != nil {
+		if p.closed {
+			process.Close()
+		} else {
+			p.idle = append(p.idle, process)
+		}
+	}
+	p.mu.Unlock()
+	<-p.slots
+}
+
+/*
+Stop the idle engines. Busy ones are stopped once their search is done.
+*/
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, process := range idle {
+		process.Close()
+	}
+}
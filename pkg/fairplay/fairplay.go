@@ -0,0 +1,216 @@
+package fairplay
+
+import (
+	"math"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/analysis"
+	"github.com/bstchow/go-chess-server/pkg/rating"
+)
+
+const (
+	SignalEngineMatch = "engine_match"
+	SignalMoveTimes   = "move_times"
+	SignalPerformance = "performance"
+)
+
+/*
+Thresholds of the signals. Each needs enough data before it can trigger, so
+short games and new accounts aren't flagged on noise.
+*/
+const (
+	// Opening plies are skipped, book moves match the engine anyway
+	openingPlies  = 16
+	minMatchMoves = 15
+	// Match rate above what the player's rating leads to expect
+	matchExcess = 0.25
+
+	// The player's first moves are skipped, as are premoves
+	skippedMoves     = 5
+	premoveThinkTime = 100 * time.Millisecond
+	minTimedMoves    = 20
+	minMeanThinkTime = time.Second
+	// Coefficient of variation of think times below which they look automated
+	minTimeVariation = 0.2
+
+	minPerformanceGames = 5
+	// Performance above the rating that counts as a spike
+	performanceSpike = 400
+)
+
+/*
+A rated game of the player, as far as the performance signal cares
+*/
+type Result struct {
+	OpponentRating int
+	Score          float64 // 1 for a win, 0.5 for a draw, 0 for a loss
+}
+
+/*
+What's known about one player's conduct in a finished game
+*/
+type Game struct {
+	White     bool
+	Rating    int            // Before the game
+	Plies     []analysis.Ply // Engine analysis of all plies, nil if the game wasn't analysed
+	MoveTimes []time.Time    // When each ply was played
+	StartedAt time.Time
+	Recent    []Result // The player's latest rated games in the pool, including this one
+}
+
+type EngineMatch struct {
+	Moves    int     `json:"moves"`
+	Matches  int     `json:"matches"`
+	Rate     float64 `json:"rate"`
+	Expected float64 `json:"expected"`
+	Flagged  bool    `json:"flagged"`
+}
+
+type MoveTimes struct {
+	Moves     int     `json:"moves"`
+	MeanMs    int64   `json:"mean_ms"`
+	StdDevMs  int64   `json:"std_dev_ms"`
+	Variation float64 `json:"variation"`
+	Flagged   bool    `json:"flagged"`
+}
+
+type Performance struct {
+	Games       int     `json:"games"`
+	Score       float64 `json:"score"`
+	Performance int     `json:"performance"`
+	Rating      int     `json:"rating"`
+	Flagged     bool    `json:"flagged"`
+}
+
+/*
+The signals computed for a game. Those without enough data are nil.
+*/
+type Evidence struct {
+	EngineMatch *EngineMatch `json:"engine_match,omitempty"`
+	MoveTimes   *MoveTimes   `json:"move_times,omitempty"`
+	Performance *Performance `json:"performance,omitempty"`
+	Signals     []string     `json:"signals"`
+}
+
+/*
+Compute the suspicion signals of the game. The account should be reviewed if
+its moves match the engine far too often, or if two signals trigger at once.
+*/
+func Check(game Game) (Evidence, bool) {
+	evidence := Evidence{
+		EngineMatch: engineMatch(game),
+		MoveTimes:   moveTimes(game),
+		Performance: performance(game),
+		Signals:     []string{},
+	}
+	if evidence.EngineMatch != nil && evidence.EngineMatch.Flagged {
+		evidence.Signals = append(evidence.Signals, SignalEngineMatch)
+	}
+	if evidence.MoveTimes != nil && evidence.MoveTimes.Flagged {
+		evidence.Signals = append(evidence.Signals, SignalMoveTimes)
+	}
+	if evidence.Performance != nil && evidence.Performance.Flagged {
+		evidence.Signals = append(evidence.Signals, SignalPerformance)
+	}
+
+	engine := evidence.EngineMatch != nil && evidence.EngineMatch.Flagged
+	return evidence, engine || len(evidence.Signals) >= 2
+}
+
+/*
+Share of moves agreeing with the engine's first choice a player of the rating
+makes, from about a third for beginners to 60% for masters
+*/
+func expectedMatchRate(r int) float64 {
+	return math.Max(0.30, math.Min(0.60, 0.30+float64(r-1200)*0.0002))
+}
+
+func ownPly(game Game, ply int) bool {
+	return (ply%2 == 0) == game.White
+}
+
+func engineMatch(game Game) *EngineMatch {
+	m := &EngineMatch{Expected: expectedMatchRate(game.Rating)}
+	for i, p := range game.Plies {
+		if i < openingPlies || !ownPly(game, i) || p.BestMove == "" {
+			continue
+		}
+		m.Moves++
+		if p.Move == p.BestMove {
+			m.Matches++
+		}
+	}
+	if m.Moves < minMatchMoves {
+		return nil
+	}
+	m.Rate = float64(m.Matches) / float64(m.Moves)
+	m.Flagged = m.Rate-m.Expected >= matchExcess
+	return m
+}
+
+func moveTimes(game Game) *MoveTimes {
+	var thinkTimes []float64
+	own := 0
+	for i, at := range game.MoveTimes {
+		if !ownPly(game, i) {
+			continue
+		}
+		own++
+		previous := game.StartedAt
+		if i > 0 {
+			previous = game.MoveTimes[i-1]
+		}
+		think := at.Sub(previous)
+		if own <= skippedMoves || think < premoveThinkTime {
+			continue
+		}
+		thinkTimes = append(thinkTimes, float64(think.Milliseconds()))
+	}
+	if len(thinkTimes) < minTimedMoves {
+		return nil
+	}
+
+	var sum float64
+	for _, t := range thinkTimes {
+		sum += t
+	}
+	mean := sum / float64(len(thinkTimes))
+	if mean < float64(minMeanThinkTime.Milliseconds()) {
+		// Fast games are played at an even pace by everyone
+		return nil
+	}
+	var squares float64
+	for _, t := range thinkTimes {
+		squares += (t - mean) * (t - mean)
+	}
+	stdDev := math.Sqrt(squares / float64(len(thinkTimes)))
+
+	m := &MoveTimes{
+		Moves:     len(thinkTimes),
+		MeanMs:    int64(math.Round(mean)),
+		StdDevMs:  int64(math.Round(stdDev)),
+		Variation: stdDev / mean,
+	}
+	m.Flagged = m.Variation < minTimeVariation
+	return m
+}
+
+func performance(game Game) *Performance {
+	if len(game.Recent) < minPerformanceGames {
+		return nil
+	}
+	opponents := make([]int, len(game.Recent))
+	var score float64
+	for i, result := range game.Recent {
+		opponents[i] = result.OpponentRating
+		score += result.Score
+	}
+	p := &Performance{
+		Games:       len(game.Recent),
+		Score:       score,
+		Performance: rating.Performance(opponents, score),
+		Rating:      game.Rating,
+	}
+	p.Flagged = p.Performance-p.Rating >= performanceSpike
+	return p
+}
@@ -0,0 +1,77 @@
+package fairplay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/analysis"
+)
+
+/*
+A game of 80 plies in which white plays the engine's move with the given
+share of its moves, thinking the given times in turn
+*/
+func testGame(matchEvery int, thinkTimes ...time.Duration) Game {
+	start := time.Unix(0, 0)
+	game := Game{White: true, Rating: 1500, StartedAt: start}
+	at := start
+	for i := 0; i < 80; i++ {
+		ply := analysis.Ply{Move: "a", BestMove: "b"}
+		if i%matchEvery == 0 {
+			ply.BestMove = "a"
+		}
+		game.Plies = append(game.Plies, ply)
+		at = at.Add(thinkTimes[i%len(thinkTimes)])
+		game.MoveTimes = append(game.MoveTimes, at)
+	}
+	return game
+}
+
+func TestCheck(t *testing.T) {
+	human := []time.Duration{2 * time.Second, 9 * time.Second, 4 * time.Second, 15 * time.Second, time.Second}
+	spike := testGame(4, 3*time.Second)
+	for i := 0; i < 6; i++ {
+		spike.Recent = append(spike.Recent, Result{OpponentRating: 1900, Score: 1})
+	}
+
+	tests := []struct {
+		name    string
+		game    Game
+		signals []string
+		flagged bool
+	}{
+		{"human", testGame(4, human...), []string{}, false},
+		{"engine moves", testGame(1, human...), []string{SignalEngineMatch}, true},
+		{"steady times", testGame(4, 3*time.Second), []string{SignalMoveTimes}, false},
+		{"steady engine", testGame(1, 3*time.Second), []string{SignalEngineMatch, SignalMoveTimes}, true},
+		{"steady spike", spike, []string{SignalMoveTimes, SignalPerformance}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evidence, flagged := Check(tt.game)
+			if flagged != tt.flagged {
+				t.Errorf("flagged: got %v, want %v", flagged, tt.flagged)
+			}
+			if len(evidence.Signals) != len(tt.signals) {
+				t.Fatalf("signals: got %v, want %v", evidence.Signals, tt.signals)
+			}
+			for i := range tt.signals {
+				if evidence.Signals[i] != tt.signals[i] {
+					t.Errorf("signals: got %v, want %v", evidence.Signals, tt.signals)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckNeedsData(t *testing.T) {
+	game := testGame(1, 3*time.Second)
+	game.Plies = game.Plies[:30]
+	game.MoveTimes = game.MoveTimes[:30]
+
+	evidence, flagged := Check(game)
+	if flagged || evidence.EngineMatch != nil || evidence.MoveTimes != nil || evidence.Performance != nil {
+		t.Errorf("short game judged: %+v", evidence)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Package health answers load balancers and orchestrators: /healthz tells
+whether the process is alive, /readyz whether it should be sent traffic.
+Readiness runs the registered checks and fails while the server drains.
+*/
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long the readiness checks together may take
+const checkTimeout = 2 * time.Second
+
+var (
+	draining atomic.Bool
+	checksMu sync.RWMutex
+	checks   = map[string]func(context.Context) error{}
+)
+
+/*
+Add a check the server must pass to be ready, replacing an earlier one of
+the same name
+*/
+func AddCheck(name string, check func(context.Context) error) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks[name] = check
+}
+
+/*
+Mark the server as draining, so load balancers stop sending it new clients
+while the current ones finish
+*/
+func SetDraining(d bool) {
+	draining.Store(d)
+}
+
+func Draining() bool {
+	return draining.Load()
+}
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // "ok" or why the check failed
+}
+
+func respond(w http.ResponseWriter, code int, body response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+/*
+HTTP Handler for liveness, which only needs the process to serve requests
+*/
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, response{Status: "ok"})
+	})
+}
+
+/*
+Run every check at once, returning the result of each and whether all passed
+*/
+func runChecks(ctx context.Context) (map[string]string, bool) {
+	checksMu.RLock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	funcs := make([]func(context.Context) error, len(names))
+	for i, name := range names {
+		funcs[i] = checks[name]
+	}
+	checksMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, check := range funcs {
+		wg.Add(1)
+		go func(i int, check func(context.Context) error) {
+			defer wg.Done()
+			errs[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	results := make(map[string]string, len(names))
+	ok := true
+	for i, name := range names {
+		results[name] = "ok"
+		if errs[i] != nil {
+			results[name] = errs[i].Error()
+			ok = false
+		}
+	}
+	return results, ok
+}
+
+/*
+HTTP Handler for readiness, 503 while draining or if any check fails
+*/
+func ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, ok := runChecks(r.Context())
+		switch {
+		case Draining():
+			respond(w, http.StatusServiceUnavailable, response{Status: "draining", Checks: results})
+		case !ok:
+			respond(w, http.StatusServiceUnavailable, response{Status: "unavailable", Checks: results})
+		default:
+			respond(w, http.StatusOK, response{Status: "ready", Checks: results})
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyHandler(t *testing.T) {
+	var dbErr error
+	AddCheck("database", func(ctx context.Context) error { return dbErr })
+	AddCheck("websocket", func(ctx context.Context) error { return nil })
+
+	tests := []struct {
+		name       string
+		dbErr      error
+		draining   bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"ready", nil, false, http.StatusOK, "ready"},
+		{"failing check", errors.New("connection refused"), false, http.StatusServiceUnavailable, "unavailable"},
+		{"draining", nil, true, http.StatusServiceUnavailable, "draining"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbErr = tt.dbErr
+			SetDraining(tt.draining)
+			defer SetDraining(false)
+
+			w := httptest.NewRecorder()
+			ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			var body response
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode || body.Status != tt.wantStatus {
+				t.Errorf("got %d %q, want %d %q", w.Code, body.Status, tt.wantCode, tt.wantStatus)
+			}
+			wantDB := "ok"
+			if tt.dbErr != nil {
+				wantDB = tt.dbErr.Error()
+			}
+			if body.Checks["database"] != wantDB || body.Checks["websocket"] != "ok" {
+				t.Errorf("checks: got %v", body.Checks)
+			}
+		})
+	}
+}
+
+func TestLiveHandlerIgnoresDraining(t *testing.T) {
+	SetDraining(true)
+	defer SetDraining(false)
+	w := httptest.NewRecorder()
+	LiveHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, want 200", w.Code)
+	}
+}
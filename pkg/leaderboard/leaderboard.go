@@ -0,0 +1,224 @@
+package leaderboard
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotRanked = errors.New("the user isn't ranked in this pool")
+
+/*
+A user's rating in a pool
+*/
+type Entry struct {
+	UserID       string    `json:"user_id"`
+	Rating       int       `json:"rating"`
+	Games        int       `json:"games"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+/*
+An entry with its rank among the entries a query keeps, starting at 1
+*/
+type Ranked struct {
+	Rank int `json:"rank"`
+	Entry
+}
+
+/*
+Which entries are ranked. Users with fewer games, or who haven't played
+since ActiveSince, are left out.
+*/
+type Filter struct {
+	MinGames    int
+	ActiveSince time.Time
+}
+
+func (f Filter) keeps(e *Entry) bool {
+	return e.Games >= f.MinGames && !e.LastPlayedAt.Before(f.ActiveSince)
+}
+
+/*
+The entries of a pool, best first. Ties are broken by user id so every
+entry has a fixed position.
+*/
+type ranking struct {
+	entries []*Entry
+	byUser  map[string]*Entry
+}
+
+func before(a, b *Entry) bool {
+	if a.Rating != b.Rating {
+		return a.Rating > b.Rating
+	}
+	return a.UserID < b.UserID
+}
+
+func (r *ranking) position(e *Entry) int {
+	return sort.Search(len(r.entries), func(i int) bool { return !before(r.entries[i], e) })
+}
+
+func (r *ranking) remove(e *Entry) {
+	i := r.position(e)
+	r.entries = append(r.entries[:i], r.entries[i+1:]...)
+	delete(r.byUser, e.UserID)
+}
+
+func (r *ranking) insert(e *Entry) {
+	i := r.position(e)
+	r.entries = append(r.entries, nil)
+	copy(r.entries[i+1:], r.entries[i:])
+	r.entries[i] = e
+	r.byUser[e.UserID] = e
+}
+
+/*
+A Board keeps the ranking of every pool in memory. It's loaded once and then
+updated with each rated game, so reading it never sorts all ratings.
+*/
+type Board struct {
+	pools map[string]*ranking
+	mu    sync.RWMutex
+}
+
+func New() *Board {
+	return &Board{pools: map[string]*ranking{}}
+}
+
+/*
+Replace the rankings with the entries of each pool
+*/
+func (b *Board) Load(pools map[string][]Entry) {
+	loaded := map[string]*ranking{}
+	for pool, entries := range pools {
+		r := &ranking{byUser: map[string]*Entry{}}
+		for i := range entries {
+			e := entries[i]
+			r.byUser[e.UserID] = &e
+			r.entries = append(r.entries, &e)
+		}
+		sort.Slice(r.entries, func(i, j int) bool { return before(r.entries[i], r.entries[j]) })
+		loaded[pool] = r
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pools = loaded
+}
+
+/*
+Move the user to their new rating in the pool
+*/
+func (b *Board) Update(pool string, e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.pools[pool]
+	if !ok {
+		r = &ranking{byUser: map[string]*Entry{}}
+		b.pools[pool] = r
+	}
+	if old, ok := r.byUser[e.UserID]; ok {
+		r.remove(old)
+	}
+	r.insert(&e)
+}
+
+/*
+Pools with any rated user, sorted
+*/
+func (b *Board) Pools() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	pools := make([]string, 0, len(b.pools))
+	for pool := range b.pools {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+/*
+The best n users of the pool the filter keeps
+*/
+func (b *Board) Top(pool string, filter Filter, n int) []Ranked {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	top := []Ranked{}
+	r, ok := b.pools[pool]
+	if !ok {
+		return top
+	}
+	for _, e := range r.entries {
+		if len(top) == n {
+			break
+		}
+		if filter.keeps(e) {
+			top = append(top, Ranked{Rank: len(top) + 1, Entry: *e})
+		}
+	}
+	return top
+}
+
+/*
+The user with up to n users the filter keeps ranked right above and below
+them. Fails with ErrNotRanked if the filter leaves the user out.
+*/
+func (b *Board) Around(pool, userID string, filter Filter, n int) ([]Ranked, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	r, ok := b.pools[pool]
+	if !ok {
+		return nil, ErrNotRanked
+	}
+	user, ok := r.byUser[userID]
+	if !ok || !filter.keeps(user) {
+		return nil, ErrNotRanked
+	}
+
+	// Entries above the user are only known to be in the window once the user is found
+	var above []Ranked
+	rank := 0
+	i := 0
+	for ; r.entries[i] != user; i++ {
+		if e := r.entries[i]; filter.keeps(e) {
+			rank++
+			above = append(above, Ranked{Rank: rank, Entry: *e})
+			if len(above) > n {
+				above = above[1:]
+			}
+		}
+	}
+	rank++
+	window := append(above, Ranked{Rank: rank, Entry: *user})
+	for i++; i < len(r.entries) && len(window) < len(above)+1+n; i++ {
+		if e := r.entries[i]; filter.keeps(e) {
+			rank++
+			window = append(window, Ranked{Rank: rank, Entry: *e})
+		}
+	}
+	return window, nil
+}
+
+/*
+Share of the pool's rated users, in percent, rated lower than the user. False
+if the user isn't rated in the pool.
+*/
+func (b *Board) Percentile(pool, userID string) (float64, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	r, ok := b.pools[pool]
+	if !ok {
+		return 0, false
+	}
+	user, ok := r.byUser[userID]
+	if !ok {
+		return 0, false
+	}
+	lower := len(r.entries) - sort.Search(len(r.entries), func(i int) bool {
+		return r.entries[i].Rating < user.Rating
+	})
+	return math.Round(1000*float64(lower)/float64(len(r.entries))) / 10, true
+}
@@ -0,0 +1,67 @@
+package leaderboard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func ids(ranked []Ranked) string {
+	s := ""
+	for _, r := range ranked {
+		s += fmt.Sprintf("%d.%s ", r.Rank, r.UserID)
+	}
+	return s
+}
+
+func TestBoard(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+	b := New()
+	b.Load(map[string][]Entry{"5+3": {
+		{UserID: "a", Rating: 1900, Games: 50, LastPlayedAt: now},
+		{UserID: "b", Rating: 1800, Games: 3, LastPlayedAt: now},
+		{UserID: "c", Rating: 1700, Games: 40, LastPlayedAt: old},
+		{UserID: "d", Rating: 1600, Games: 40, LastPlayedAt: now},
+		{UserID: "e", Rating: 1500, Games: 40, LastPlayedAt: now},
+		{UserID: "f", Rating: 1400, Games: 40, LastPlayedAt: now},
+	}})
+	active := Filter{MinGames: 10, ActiveSince: now.Add(-30 * 24 * time.Hour)}
+
+	if got, want := ids(b.Top("5+3", Filter{}, 3)), "1.a 2.b 3.c "; got != want {
+		t.Errorf("top: got %s, want %s", got, want)
+	}
+	if got, want := ids(b.Top("5+3", active, 3)), "1.a 2.d 3.e "; got != want {
+		t.Errorf("active top: got %s, want %s", got, want)
+	}
+	around, err := b.Around("5+3", "e", active, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(around), "2.d 3.e 4.f "; got != want {
+		t.Errorf("around: got %s, want %s", got, want)
+	}
+	if _, err := b.Around("5+3", "c", active, 1); err != ErrNotRanked {
+		t.Errorf("got %v for an inactive user, want %v", err, ErrNotRanked)
+	}
+
+	// f wins its way to the top, a newcomer enters
+	b.Update("5+3", Entry{UserID: "f", Rating: 2000, Games: 41, LastPlayedAt: now})
+	b.Update("5+3", Entry{UserID: "g", Rating: 1500, Games: 1, LastPlayedAt: now})
+	if got, want := ids(b.Top("5+3", Filter{}, 10)), "1.f 2.a 3.b 4.c 5.d 6.e 7.g "; got != want {
+		t.Errorf("updated: got %s, want %s", got, want)
+	}
+	around, _ = b.Around("5+3", "f", active, 2)
+	if got, want := ids(around), "1.f 2.a 3.d "; got != want {
+		t.Errorf("around the top: got %s, want %s", got, want)
+	}
+	if p, ok := b.Percentile("5+3", "d"); !ok || p != 28.6 {
+		t.Errorf("percentile: got %v, want 28.6", p)
+	}
+	if _, ok := b.Percentile("5+3", "nobody"); ok {
+		t.Error("percentile of an unrated user")
+	}
+	if got := b.Pools(); len(got) != 1 || got[0] != "5+3" {
+		t.Errorf("pools: got %v", got)
+	}
+}
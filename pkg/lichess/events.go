@@ -0,0 +1,280 @@
+package lichess
+
+import (
+	"math"
+	"strings"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+
+	"github.com/notnil/chess"
+)
+
+/*
+The documents of the Lichess Bot and Board API streams, limited to the fields
+bots rely on
+*/
+
+type Variant struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Short string `json:"short"`
+}
+
+var standard = Variant{Key: "standard", Name: "Standard", Short: "Std"}
+
+type Status struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Opponent struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type Compat struct {
+	Bot   bool `json:"bot"`
+	Board bool `json:"board"`
+}
+
+type Game struct {
+	ID       string   `json:"id"`
+	GameID   string   `json:"gameId"`
+	FullID   string   `json:"fullId"`
+	Color    string   `json:"color"`
+	Fen      string   `json:"fen"`
+	HasMoved bool     `json:"hasMoved"`
+	IsMyTurn bool     `json:"isMyTurn"`
+	LastMove string   `json:"lastMove"`
+	Opponent Opponent `json:"opponent"`
+	Perf     string   `json:"perf"`
+	Rated    bool     `json:"rated"`
+	Source   string   `json:"source"`
+	Speed    string   `json:"speed"`
+	Variant  Variant  `json:"variant"`
+	Compat   Compat   `json:"compat"`
+	Status   *Status  `json:"status,omitempty"`
+	Winner   string   `json:"winner,omitempty"`
+}
+
+/*
+A gameStart or gameFinish line of the event stream
+*/
+type GameEvent struct {
+	Type string `json:"type"`
+	Game Game   `json:"game"`
+}
+
+type Player struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Title *string `json:"title"`
+}
+
+type Clock struct {
+	Initial   int64 `json:"initial"`   // Milliseconds
+	Increment int64 `json:"increment"` // Milliseconds
+}
+
+type Perf struct {
+	Name string `json:"name"`
+}
+
+type GameState struct {
+	Type   string `json:"type"`
+	Moves  string `json:"moves"` // UCI, space separated
+	Wtime  int64  `json:"wtime"`
+	Btime  int64  `json:"btime"`
+	Winc   int64  `json:"winc"`
+	Binc   int64  `json:"binc"`
+	Status string `json:"status"`
+	Winner string `json:"winner,omitempty"`
+}
+
+/*
+First line of a game stream
+*/
+type GameFull struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	Rated      bool      `json:"rated"`
+	Variant    Variant   `json:"variant"`
+	Clock      *Clock    `json:"clock"`
+	Speed      string    `json:"speed"`
+	Perf       Perf      `json:"perf"`
+	White      Player    `json:"white"`
+	Black      Player    `json:"black"`
+	InitialFen string    `json:"initialFen"`
+	State      GameState `json:"state"`
+}
+
+type ChatLine struct {
+	Type     string `json:"type"`
+	Room     string `json:"room"`
+	Username string `json:"username"`
+	Text     string `json:"text"`
+}
+
+type OpponentGone struct {
+	Type string `json:"type"`
+	Gone bool   `json:"gone"`
+}
+
+// What Lichess reports as the time left in games without a clock
+const unlimitedMs = math.MaxInt32
+
+var statusIDs = map[string]int{
+	"started":   20,
+	"aborted":   25,
+	"mate":      30,
+	"resign":    31,
+	"stalemate": 32,
+	"draw":      34,
+	"outoftime": 35,
+}
+
+/*
+Lichess status of a game in the given state
+*/
+func statusName(state *protocol.GameState) string {
+	if state.Status != protocol.StatusEnded {
+		return "started"
+	}
+	switch state.Method {
+	case protocol.MethodCheckmate:
+		return "mate"
+	case protocol.MethodResignation:
+		return "resign"
+	case protocol.MethodStalemate:
+		return "stalemate"
+	case protocol.MethodTimeout:
+		return "outoftime"
+	case protocol.MethodAborted:
+		return "aborted"
+	case protocol.MethodAdjudication:
+		if chess.Outcome(state.Outcome) != chess.Draw {
+			return "unknownFinish"
+		}
+	}
+	return "draw"
+}
+
+func winner(state *protocol.GameState) string {
+	switch chess.Outcome(state.Outcome) {
+	case chess.WhiteWon:
+		return "white"
+	case chess.BlackWon:
+		return "black"
+	}
+	return ""
+}
+
+/*
+Lichess speed category of a time control, from its estimated duration
+*/
+func speed(tc session.TimeControl) string {
+	if tc.IsUnlimited() {
+		return "correspondence"
+	}
+	estimate := tc.Initial.Seconds() + 40*tc.Increment.Seconds()
+	switch {
+	case estimate < 30:
+		return "ultraBullet"
+	case estimate < 180:
+		return "bullet"
+	case estimate < 480:
+		return "blitz"
+	case estimate < 1500:
+		return "rapid"
+	}
+	return "classical"
+}
+
+func gameState(tc session.TimeControl, state *protocol.GameState, clock *protocol.ClockState) GameState {
+	moves := make([]string, len(state.Moves))
+	for i, move := range state.Moves {
+		moves[i] = move.Uci
+	}
+	gs := GameState{
+		Type:   "gameState",
+		Moves:  strings.Join(moves, " "),
+		Wtime:  unlimitedMs,
+		Btime:  unlimitedMs,
+		Winc:   tc.Increment.Milliseconds(),
+		Binc:   tc.Increment.Milliseconds(),
+		Status: statusName(state),
+		Winner: winner(state),
+	}
+	if clock != nil {
+		gs.Wtime, gs.Btime = clock.WhiteMs, clock.BlackMs
+	}
+	return gs
+}
+
+func player(p session.Player) Player {
+	lp := Player{ID: p.ID, Name: p.ID}
+	if p.Bot {
+		title := "BOT"
+		lp.Title = &title
+	}
+	return lp
+}
+
+func gameFull(info session.Info) GameFull {
+	full := GameFull{
+		Type:       "gameFull",
+		ID:         info.ID,
+		Variant:    standard,
+		Rated:      info.Rated,
+		Speed:      speed(info.TimeControl),
+		White:      player(info.White),
+		Black:      player(info.Black),
+		InitialFen: "startpos",
+		State:      gameState(info.TimeControl, info.State, info.Clock),
+	}
+	full.Perf.Name = full.Speed
+	if !info.TimeControl.IsUnlimited() {
+		full.Clock = &Clock{
+			Initial:   info.TimeControl.Initial.Milliseconds(),
+			Increment: info.TimeControl.Increment.Milliseconds(),
+		}
+	}
+	return full
+}
+
+/*
+The game as listed in gameStart and gameFinish events of the given player
+*/
+func gameEvent(eventType string, info session.Info, state *protocol.GameState, playerID string) GameEvent {
+	white := info.White.ID == playerID
+	color, opponent := "white", info.Black
+	if !white {
+		color, opponent = "black", info.White
+	}
+	game := Game{
+		ID:       info.ID,
+		GameID:   info.ID,
+		FullID:   info.ID,
+		Color:    color,
+		Fen:      state.Fen,
+		HasMoved: len(state.Moves) > 1 || (len(state.Moves) == 1 && white),
+		IsMyTurn: state.Status == protocol.StatusActive && state.IsWhiteTurn == white,
+		Opponent: Opponent{ID: opponent.ID, Username: opponent.ID},
+		Perf:     speed(info.TimeControl),
+		Source:   "lobby",
+		Rated:    info.Rated,
+		Speed:    speed(info.TimeControl),
+		Variant:  standard,
+		Compat:   Compat{Bot: true, Board: true},
+	}
+	if state.LastMove != nil {
+		game.LastMove = state.LastMove.Uci
+	}
+	if eventType == "gameFinish" {
+		name := statusName(state)
+		game.Status = &Status{ID: statusIDs[name], Name: name}
+		game.Winner = winner(state)
+	}
+	return GameEvent{Type: eventType, Game: game}
+}
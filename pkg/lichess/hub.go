@@ -0,0 +1,452 @@
+package lichess
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/bstchow/go-chess-server/pkg/utils"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotInGame = errors.New("no such game")
+	ErrStreaming = errors.New("the account is already streaming events")
+)
+
+// Lines buffered for a stream reader before it's dropped as too slow
+const streamBuffer = 64
+
+/*
+What the hub needs from matchmaking, see agent.Agent
+*/
+type Matchmaker interface {
+	Seek(player *session.Player, pool string)
+	Disconnect(connID string)
+	SessionOf(playerID string) (string, bool)
+}
+
+/*
+A Hub connects accounts using the Lichess Bot and Board API to the matcher and
+their sessions. An account is represented to them by one Client for as long
+as it has any stream open.
+*/
+type Hub struct {
+	matchmaker Matchmaker
+	clients    map[string]*Client
+	mu         sync.Mutex
+}
+
+func NewHub(matchmaker Matchmaker) *Hub {
+	return &Hub{
+		matchmaker: matchmaker,
+		clients:    map[string]*Client{},
+	}
+}
+
+/*
+Stream the account's events. While the stream is open the account is kept
+in the matching queue of the pool, and put back into it after every game.
+The returned stop function must be called once the reader is gone.
+*/
+func (h *Hub) StreamEvents(userID string, bot bool, pool string) (<-chan []byte, func(), error) {
+	c := h.acquire(userID, bot)
+	lines, err := c.openEvents(pool)
+	if err != nil {
+		h.release(c)
+		return nil, nil, err
+	}
+	c.seek()
+	return lines, func() {
+		c.closeEvents(lines)
+		h.release(c)
+	}, nil
+}
+
+/*
+Stream a game the account plays in. The first line is the full game, the
+channel carries the changes after it and is closed once the game ended.
+*/
+func (h *Hub) StreamGame(userID string, bot bool, gameID string) ([]byte, <-chan []byte, func(), error) {
+	info, err := session.GetInfo(gameID)
+	if err != nil || (info.White.ID != userID && info.Black.ID != userID) {
+		return nil, nil, nil, ErrNotInGame
+	}
+
+	c := h.acquire(userID, bot)
+	lines := c.openGame(gameID)
+	// Make this client the player's connection, unless it already is
+	if !c.playing(gameID) {
+		c.rejoin()
+	}
+	full, err := json.Marshal(gameFull(info))
+	if err != nil {
+		c.closeGame(gameID, lines)
+		h.release(c)
+		return nil, nil, nil, err
+	}
+	return append(full, '\n'), lines, func() {
+		c.closeGame(gameID, lines)
+		h.release(c)
+	}, nil
+}
+
+func (h *Hub) acquire(userID string, bot bool) *Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.clients[userID]
+	if !ok {
+		c = newClient(userID, bot, h.matchmaker)
+		h.clients[userID] = c
+	}
+	c.streams++
+	return c
+}
+
+/*
+Drop a stream of the client, disconnecting it once it has none left
+*/
+func (h *Hub) release(c *Client) {
+	h.mu.Lock()
+	c.streams--
+	last := c.streams == 0
+	if last {
+		delete(h.clients, c.ID)
+	}
+	h.mu.Unlock()
+
+	if last {
+		c.stop()
+	}
+}
+
+/*
+The parts of the session messages a client translates
+*/
+type message struct {
+	Type        string               `json:"type"`
+	SessionID   string               `json:"session_id"`
+	State       *protocol.GameState  `json:"state"`
+	PlayerState protocol.PlayerState `json:"player_state"`
+	Clock       *protocol.ClockState `json:"clock"`
+	Data        protocol.EndgameData `json:"data"`
+	Error       string               `json:"error"`
+	From        string               `json:"from"`
+	Text        string               `json:"text"`
+	Connected   bool                 `json:"connected"`
+}
+
+/*
+A Client is the connection of a Lichess API account as a session player. The
+session and matcher write to it from their own goroutines, so messages are
+queued and translated into stream lines on the client's goroutine, which
+may call back into them.
+*/
+type Client struct {
+	ID         string
+	bot        bool
+	matchmaker Matchmaker
+
+	connID string
+	pool   string
+	events chan []byte            // Nil while no event stream is open
+	games  map[string]chan []byte // Game stream per game id
+	game   *session.Info          // Game the client plays, as of its start
+	plies  int                    // Moves in the last game state sent
+	inbox  []message
+	wake   chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+
+	streams int // Guarded by the hub
+}
+
+func newClient(id string, bot bool, matchmaker Matchmaker) *Client {
+	c := &Client{
+		ID:         id,
+		bot:        bot,
+		matchmaker: matchmaker,
+		connID:     utils.GenerateUUID(),
+		games:      map[string]chan []byte{},
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Client) player() *session.Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &session.Player{Conn: c, ConnID: c.connID, ID: c.ID, Bot: c.bot}
+}
+
+/*
+Receive a message from the matcher or a session
+*/
+func (c *Client) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return errors.New("client disconnected")
+	default:
+	}
+	c.inbox = append(c.inbox, msg)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *Client) ProtocolVersion() int {
+	return protocol.SupportedVersions[len(protocol.SupportedVersions)-1]
+}
+
+/*
+Called by the game over handler. The client outlives its games, it's only
+stopped once its streams are closed.
+*/
+func (c *Client) Close() error {
+	return nil
+}
+
+func (c *Client) stop() {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return
+	default:
+	}
+	close(c.done)
+	connID := c.connID
+	c.mu.Unlock()
+	c.matchmaker.Disconnect(connID)
+}
+
+func (c *Client) run() {
+	for {
+		select {
+		case <-c.wake:
+			c.mu.Lock()
+			inbox := c.inbox
+			c.inbox = nil
+			c.mu.Unlock()
+			for _, msg := range inbox {
+				c.handle(msg)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) handle(msg message) {
+	switch msg.Type {
+	case protocol.TypeMatched:
+		info, err := session.GetInfo(msg.SessionID)
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		restart := c.game == nil || c.game.ID != info.ID
+		c.game = &info
+		c.plies = len(info.State.Moves)
+		c.mu.Unlock()
+		if restart {
+			c.sendEvent(gameEvent("gameStart", info, info.State, c.ID))
+		}
+	case protocol.TypeSession:
+		c.sendState(msg.State, msg.Clock)
+	case protocol.TypeEndgame:
+		c.finish(msg.Data.State)
+	case protocol.TypeChat:
+		c.sendGame(ChatLine{Type: "chatLine", Room: "player", Username: msg.From, Text: msg.Text})
+	case protocol.TypeOpponentConnection:
+		c.sendGame(OpponentGone{Type: "opponentGone", Gone: !msg.Connected})
+	case protocol.TypeTimeout:
+		c.seek()
+	case protocol.TypeQueueing, protocol.TypeError:
+		logging.Info("lichess api client refused",
+			zap.String("id", c.ID),
+			zap.String("type", msg.Type),
+			zap.String("error", msg.Error),
+		)
+	}
+}
+
+func (c *Client) sendState(state *protocol.GameState, clock *protocol.ClockState) {
+	c.mu.Lock()
+	game := c.game
+	stale := game == nil || state == nil || len(state.Moves) < c.plies
+	if !stale {
+		c.plies = len(state.Moves)
+	}
+	c.mu.Unlock()
+	if stale {
+		return
+	}
+	c.sendGame(gameState(game.TimeControl, state, clock))
+}
+
+/*
+Report the end of the game, end its streams and look for the next one
+*/
+func (c *Client) finish(state *protocol.GameState) {
+	c.mu.Lock()
+	game := c.game
+	c.game = nil
+	c.mu.Unlock()
+	if game == nil || state == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if lines, ok := c.games[game.ID]; ok {
+		send(lines, gameState(game.TimeControl, state, nil))
+		close(lines)
+		delete(c.games, game.ID)
+	}
+	c.mu.Unlock()
+	c.sendEvent(gameEvent("gameFinish", *game, state, c.ID))
+
+	// The old connection id is still known to the matcher as queued
+	c.matchmaker.Disconnect(c.connID)
+	c.mu.Lock()
+	c.connID = utils.GenerateUUID()
+	c.mu.Unlock()
+	c.seek()
+}
+
+func (c *Client) sendEvent(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events != nil && !send(c.events, v) {
+		logging.Info("dropping slow lichess event stream", zap.String("id", c.ID))
+		close(c.events)
+		c.events = nil
+	}
+}
+
+func (c *Client) sendGame(v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.game == nil {
+		return
+	}
+	if lines, ok := c.games[c.game.ID]; ok && !send(lines, v) {
+		logging.Info("dropping slow lichess game stream", zap.String("id", c.ID), zap.String("game_id", c.game.ID))
+		close(lines)
+		delete(c.games, c.game.ID)
+	}
+}
+
+/*
+Write the line, false if the reader doesn't keep up. Like Lichess, the
+caller then closes the stream, and the reader catches up from the full state
+it gets when it opens a new one.
+*/
+func send(lines chan []byte, v interface{}) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return true
+	}
+	select {
+	case lines <- append(data, '\n'):
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Queue for a game, or rejoin the running one, while the event stream is open
+*/
+func (c *Client) seek() {
+	c.mu.Lock()
+	streaming := c.events != nil
+	pool := c.pool
+	c.mu.Unlock()
+	if streaming {
+		c.matchmaker.Seek(c.player(), pool)
+	}
+}
+
+/*
+Attach the client to the session the account plays in
+*/
+func (c *Client) rejoin() {
+	if _, ok := c.matchmaker.SessionOf(c.ID); ok {
+		c.matchmaker.Seek(c.player(), "")
+	}
+}
+
+func (c *Client) playing(gameID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.game != nil && c.game.ID == gameID
+}
+
+func (c *Client) openEvents(pool string) (chan []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events != nil {
+		return nil, ErrStreaming
+	}
+	c.events = make(chan []byte, streamBuffer)
+	c.pool = pool
+	return c.events, nil
+}
+
+func (c *Client) closeEvents(lines chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.events == lines {
+		c.events = nil
+	}
+}
+
+/*
+Open a stream of the game, replacing an older stream of the same game
+*/
+func (c *Client) openGame(gameID string) chan []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.games[gameID]; ok {
+		close(old)
+	}
+	lines := make(chan []byte, streamBuffer)
+	c.games[gameID] = lines
+	return lines
+}
+
+func (c *Client) closeGame(gameID string, lines chan []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.games[gameID] == lines {
+		delete(c.games, gameID)
+	}
+}
+
+/*
+Whether the account is in a game
+*/
+func (h *Hub) Playing(userID string) bool {
+	_, ok := h.matchmaker.SessionOf(userID)
+	return ok
+}
@@ -0,0 +1,175 @@
+package lichess
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/matcher"
+	"github.com/bstchow/go-chess-server/pkg/session"
+)
+
+/*
+Matchmaking as the agent does it
+*/
+type testMatchmaker struct {
+	*matcher.Matcher
+}
+
+func (m testMatchmaker) Seek(player *session.Player, pool string) {
+	m.EnterQueue(context.Background(), player, player.ConnID, pool, nil)
+}
+
+func (m testMatchmaker) Disconnect(connID string) {
+	m.RemoveConn(connID)
+}
+
+func (m testMatchmaker) SessionOf(playerID string) (string, bool) {
+	return m.SessionExists(playerID)
+}
+
+func next(t *testing.T, lines <-chan []byte, v interface{}) {
+	t.Helper()
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		if err := json.Unmarshal(line, v); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line on the stream")
+	}
+}
+
+func TestHubPlaysGames(t *testing.T) {
+	m := testMatchmaker{matcher.NewMatcher()}
+	session.SetGameOverHandler(func(s *session.GameSession, sessionID string) {
+		players := s.GetPlayers()
+		session.CloseSession(sessionID)
+		m.RemoveSession(players[0].ID, players[1].ID)
+		for _, player := range players {
+			player.Conn.WriteJSON(map[string]interface{}{
+				"type": "endgame",
+				"data": map[string]interface{}{"state": s.State()},
+			})
+			player.Conn.Close()
+		}
+	})
+	hub := NewHub(m)
+
+	events := map[string]<-chan []byte{}
+	for _, id := range []string{"alpha", "beta"} {
+		lines, stop, err := hub.StreamEvents(id, true, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		events[id] = lines
+	}
+	if _, _, err := hub.StreamEvents("alpha", true, ""); err != ErrStreaming {
+		t.Errorf("got %v for a second event stream, want %v", err, ErrStreaming)
+	}
+
+	starts := map[string]GameEvent{}
+	for id, lines := range events {
+		var start GameEvent
+		next(t, lines, &start)
+		if start.Type != "gameStart" {
+			t.Fatalf("got %s, want gameStart", start.Type)
+		}
+		starts[start.Game.Color] = start
+		if start.Game.Color == "white" && start.Game.Opponent.ID == id {
+			t.Errorf("%s plays itself", id)
+		}
+	}
+	white, black := starts["black"].Game.Opponent.ID, starts["white"].Game.Opponent.ID
+	gameID := starts["white"].Game.GameID
+	if !starts["white"].Game.IsMyTurn || starts["black"].Game.IsMyTurn {
+		t.Error("white should move first")
+	}
+
+	first, lines, stop, err := hub.StreamGame(black, true, gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	var full GameFull
+	if err := json.Unmarshal(first, &full); err != nil {
+		t.Fatal(err)
+	}
+	if full.White.ID != white || full.State.Status != "started" || *full.Black.Title != "BOT" {
+		t.Errorf("got %+v", full)
+	}
+	if _, _, _, err := hub.StreamGame("gamma", false, gameID); err != ErrNotInGame {
+		t.Errorf("got %v for a game of others, want %v", err, ErrNotInGame)
+	}
+
+	if err := session.SubmitMove(gameID, white, session.MoveSubmission{Move: "e2e4"}); err != nil {
+		t.Fatal(err)
+	}
+	var state GameState
+	next(t, lines, &state)
+	if state.Moves != "e2e4" {
+		t.Errorf("got moves %q, want e2e4", state.Moves)
+	}
+
+	if err := session.Abort(gameID, white); err == nil {
+		t.Error("white aborted after moving")
+	}
+	if err := session.Abort(gameID, black); err != nil {
+		t.Fatal(err)
+	}
+	next(t, lines, &state)
+	if state.Status != "aborted" {
+		t.Errorf("got status %s, want aborted", state.Status)
+	}
+	select {
+	case _, ok := <-lines:
+		if ok {
+			t.Error("game stream still open")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("game stream still open")
+	}
+
+	// Both are matched again
+	for _, lines := range events {
+		var finish, start GameEvent
+		next(t, lines, &finish)
+		next(t, lines, &start)
+		if finish.Type != "gameFinish" || finish.Game.Status.Name != "aborted" {
+			t.Errorf("got %+v, want an aborted gameFinish", finish)
+		}
+		if start.Type != "gameStart" || start.Game.GameID == gameID {
+			t.Errorf("got %+v, want a new gameStart", start)
+		}
+	}
+}
+
+func TestSlowStreamIsClosed(t *testing.T) {
+	c := newClient("slow", true, testMatchmaker{matcher.NewMatcher()})
+	defer c.stop()
+	lines, err := c.openEvents("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= streamBuffer; i++ {
+		c.sendEvent(map[string]int{"n": i})
+	}
+	read := 0
+	for range lines {
+		read++
+	}
+	if read != streamBuffer {
+		t.Errorf("read %d lines before the stream closed, want %d", read, streamBuffer)
+	}
+
+	// The reader reconnects
+	if _, err := c.openEvents(""); err != nil {
+		t.Errorf("couldn't reopen the stream: %v", err)
+	}
+}
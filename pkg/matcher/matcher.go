@@ -1,14 +1,23 @@
 package matcher
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/bot"
+	"github.com/bstchow/go-chess-server/pkg/engine"
+	"github.com/bstchow/go-chess-server/pkg/health"
 	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
 	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+	"github.com/bstchow/go-chess-server/pkg/utils"
 
 	"go.uber.org/zap"
 )
@@ -17,169 +26,386 @@ import (
 A Matcher handles matchmaking logic and forwards the player connection to session manager
 */
 type Matcher struct {
-	Queue      []*session.Player
-	SessionMap map[string]string
-	ConnMap    map[string]string
-	mu         sync.Mutex
+	SessionMap  map[string]string
+	ConnMap     map[string]string
+	pools       map[string]*Pool
+	defaultPool *Pool
+	enginePool  *engine.Pool // External engine for bots, nil to use the built-in one
+	queueGuard  func(context.Context, *session.Player) error
+	mu          sync.Mutex
 }
 
-type matchResponse struct {
-	Type        string              `json:"type"`
-	SessionID   string              `json:"session_id"`
-	GameState   string              `json:"game_state"`
-	PlayerState session.PlayerState `json:"player_state"`
+/*
+Return a Matcher with initialized fields
+*/
+func NewMatcher() *Matcher {
+	poolList := env.GetEnv("MATCH_POOLS")
+	if poolList == "" {
+		poolList = env.GetEnv("TIME_CONTROL")
+	}
+	pools, err := parsePools(poolList)
+	if err != nil {
+		logging.Warn("invalid match pools, games will be untimed", zap.Error(err))
+		pools, _ = parsePools("")
+	}
+	enginePool, err := engine.NewPoolFromEnv()
+	if err != nil {
+		logging.Info("bots use the built-in engine", zap.Error(err))
+	}
+	m := &Matcher{
+		SessionMap:  map[string]string{},
+		ConnMap:     map[string]string{},
+		pools:       map[string]*Pool{},
+		defaultPool: pools[0],
+		enginePool:  enginePool,
+		mu:          sync.Mutex{},
+	}
+	for _, pool := range pools {
+		m.pools[pool.Key] = pool
+	}
+	m.registerMetrics()
+	return m
 }
 
-type timeoutResponpse struct {
-	Type    string `json:"type"`
-	Message string `json:"Message"`
+/*
+Set the check players must pass to queue or start a bot game. The player is
+told the error of a failed check. Its context carries the span of the entry.
+*/
+func (m *Matcher) SetQueueGuard(guard func(context.Context, *session.Player) error) {
+	m.queueGuard = guard
 }
 
 /*
-Return a Matcher with initialized fields
+Run the queue guard, telling the player if they can't queue. It may be slow,
+so it must be called without m.mu held.
 */
-func NewMatcher() *Matcher {
-	return &Matcher{
-		Queue:      []*session.Player{},
-		SessionMap: map[string]string{},
-		ConnMap:    map[string]string{},
-		mu:         sync.Mutex{},
+func (m *Matcher) mayQueue(ctx context.Context, player *session.Player) bool {
+	if m.queueGuard == nil {
+		return true
 	}
+	if err := m.queueGuard(ctx, player); err != nil {
+		player.Conn.WriteJSON(protocol.QueueingResponse{
+			Type:  protocol.TypeQueueing,
+			Error: err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+/*
+Whether the server drains, telling the player no new games start. Players
+still finish the games they are in.
+*/
+func draining(player *session.Player) bool {
+	if !health.Draining() {
+		return false
+	}
+	player.Conn.WriteJSON(protocol.QueueingResponse{
+		Type:  protocol.TypeQueueing,
+		Error: "server is shutting down, try again shortly",
+	})
+	return true
 }
 
 /*
-Enter players to the matching queue. Matcher also keeps track of connection ID
+Return the pool with the given key, the default pool for an empty key
+*/
+func (m *Matcher) pool(key string) (*Pool, bool) {
+	if key == "" {
+		return m.defaultPool, true
+	}
+	pool, ok := m.pools[key]
+	return pool, ok
+}
+
+/*
+Enter players to the matching queue of the pool with the given key, or of
+the default pool for an empty key. Matcher also keeps track of connection ID
 to ensure no user can enter queue multiple time at the same time.
 After timeout, Matcher will cancel queueing of the corresponding player
 if there aren't no matches available.
-The player can also rejoin an unfinished match they left
+The player can also rejoin an unfinished match they left, resuming after the
+event sequence number lastSeq if it is set.
+The match the player ends up in is traced under the span in ctx.
+*/
+func (m *Matcher) EnterQueue(ctx context.Context, player *session.Player, connID string, poolKey string, lastSeq *int64) {
+	ctx, span := tracing.Start(ctx, "EnterQueue", tracing.String("player_id", player.ID), tracing.String("pool", poolKey))
+	defer span.End()
+	if !m.mayQueue(ctx, player) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessionID, exists := m.SessionMap[player.ID]
+	if exists {
+		span.SetAttributes(tracing.String("rejoined", sessionID))
+		m.ConnMap[connID] = player.ID
+		m.rejoinMatch(sessionID, player, lastSeq)
+		return
+	}
+	if draining(player) {
+		return
+	}
+	pool, ok := m.joinablePool(player, poolKey)
+	if !ok || m.alreadyQueued(player) {
+		return
+	}
+	queued := queuedPlayer{Player: player, since: time.Now(), trace: span.Context()}
+	pool.queue = append(pool.queue, queued)
+	m.ConnMap[connID] = player.ID
+	go m.leaveQueueIfTimeout(pool, player, connID)
+	go m.fallBackToBot(pool, player)
+	go m.findMatch(pool, queued.trace)
+}
+
+/*
+Start a game against a bot instead of waiting for a human opponent, with
+the time control of the given pool. A level of zero picks the default
+BOT_LEVEL. Like EnterQueue, a player with an unfinished match rejoins it instead.
 */
-func (m *Matcher) EnterQueue(player *session.Player, connID string) {
+func (m *Matcher) EnterBotGame(ctx context.Context, player *session.Player, connID string, poolKey string, level int, lastSeq *int64) {
+	ctx, span := tracing.Start(ctx, "EnterBotGame", tracing.String("player_id", player.ID), tracing.String("pool", poolKey))
+	defer span.End()
+	if !m.mayQueue(ctx, player) {
+		return
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	sessionID, exists := m.SessionMap[player.ID]
 	if exists {
-		m.rejoinMatch(sessionID, player)
+		span.SetAttributes(tracing.String("rejoined", sessionID))
+		m.ConnMap[connID] = player.ID
+		m.rejoinMatch(sessionID, player, lastSeq)
+		return
+	}
+	if draining(player) {
 		return
 	}
+	pool, ok := m.joinablePool(player, poolKey)
+	if !ok || m.alreadyQueued(player) {
+		return
+	}
+	m.ConnMap[connID] = player.ID
+	m.startBotGame(pool, player, level, span.Context())
+}
+
+/*
+Look up the pool the player asked for, telling the player if it can't join it
+*/
+func (m *Matcher) joinablePool(player *session.Player, poolKey string) (*Pool, bool) {
+	pool, ok := m.pool(poolKey)
+	if !ok {
+		player.Conn.WriteJSON(protocol.QueueingResponse{
+			Type:  protocol.TypeQueueing,
+			Error: "Unknown pool " + poolKey,
+		})
+		return nil, false
+	}
+	if pool.HumanOnly && player.Bot {
+		player.Conn.WriteJSON(protocol.QueueingResponse{
+			Type:  protocol.TypeQueueing,
+			Error: "Bots can't join pool " + pool.Key,
+		})
+		return nil, false
+	}
+	return pool, true
+}
+
+func (m *Matcher) alreadyQueued(player *session.Player) bool {
 	for _, pid := range m.ConnMap {
 		if pid == player.ID {
-			player.Conn.WriteJSON(struct {
-				Type  string `json:"type"`
-				Error string `json:"error"`
-			}{
-				Type:  "queueing",
+			player.Conn.WriteJSON(protocol.QueueingResponse{
+				Type:  protocol.TypeQueueing,
 				Error: "Already queued",
 			})
-			return
+			return true
 		}
 	}
-	m.Queue = append(m.Queue, player)
-	m.ConnMap[connID] = player.ID
-	go m.leaveQueueIfTimeout(player, connID)
-	go m.findMatch()
+	return false
 }
 
 /*
 Matcher pushes player out of the matching queue after a timeout if there aren't no matches available.
 */
-func (m *Matcher) leaveQueueIfTimeout(player *session.Player, connID string) {
+func (m *Matcher) leaveQueueIfTimeout(pool *Pool, player *session.Player, connID string) {
 	timeoutI, _ := strconv.Atoi(env.GetEnv("MATCHING_TIMEOUT"))
 	timeout := time.Duration(timeoutI) * time.Second
 	time.Sleep(timeout)
 	if player == nil {
 		return
 	}
-	if _, ok := m.SessionMap[player.ID]; !ok {
-		player.Conn.WriteJSON(timeoutResponpse{
-			Type:    "timeout",
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if queued, ok := pool.remove(player); ok {
+		tracing.StartFrom(queued.trace, "leaveQueue", tracing.String("reason", "timeout")).End()
+		delete(m.ConnMap, connID)
+		player.Conn.WriteJSON(protocol.TimeoutResponse{
+			Type:    protocol.TypeTimeout,
 			Message: "Canceled matching due to timeout",
 		})
 	}
+}
 
+/*
+Forget a closed connection. A player still waiting in the queue on that
+connection is taken out of the queue. Returns the player ID bound to the connection.
+*/
+func (m *Matcher) RemoveConn(connID string) (string, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-
+	playerID, ok := m.ConnMap[connID]
+	if !ok {
+		return "", false
+	}
 	delete(m.ConnMap, connID)
-	for i, p := range m.Queue {
-		if p.ID == player.ID || p == player {
-			m.Queue = append(m.Queue[:i], m.Queue[i+1:]...)
-			return
+	for _, pool := range m.pools {
+		for i, p := range pool.queue {
+			if p.ConnID == connID {
+				pool.queue = append(pool.queue[:i], pool.queue[i+1:]...)
+				break
+			}
 		}
 	}
+	return playerID, true
+}
+
+/*
+Match a player who is still waiting after BOT_FALLBACK_WAIT with a bot,
+unless the pool is for humans only
+*/
+func (m *Matcher) fallBackToBot(pool *Pool, player *session.Player) {
+	wait, _ := strconv.Atoi(env.GetEnv("BOT_FALLBACK_WAIT"))
+	if wait <= 0 || pool.HumanOnly {
+		return
+	}
+	time.Sleep(time.Duration(wait) * time.Second)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if queued, ok := pool.remove(player); ok {
+		logging.Info("no opponent found, matching with a bot", zap.String("id", player.ID))
+		matchWait.Observe(time.Since(queued.since).Seconds(), pool.Key, "bot")
+		span := tracing.StartFrom(queued.trace, "fallBackToBot", tracing.String("pool", pool.Key))
+		defer span.End()
+		m.startBotGame(pool, player, 0, span.Context())
+	}
 }
 
 func generateSessionId() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
 }
 
-func (m *Matcher) findMatch() {
+/*
+Pair the first two players waiting in the pool. The search is traced under
+trace, the span of the player whose arrival started it.
+*/
+func (m *Matcher) findMatch(pool *Pool, trace tracing.SpanContext) {
+	span := tracing.StartFrom(trace, "findMatch", tracing.String("pool", pool.Key))
+	defer span.End()
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if len(m.Queue) >= 2 {
-		player1 := m.Queue[0]
-		player2 := m.Queue[1]
-		m.Queue = m.Queue[2:]
-
-		sessionID := generateSessionId()
-		session.InitSession(sessionID, player1, player2)
-		m.SessionMap[player1.ID] = sessionID
-		m.SessionMap[player2.ID] = sessionID
-
-		logging.Info("init match",
-			zap.String("player_1", player1.ID),
-			zap.String("player_2", player2.ID),
-		)
-
-		notifyMatchingResult(sessionID, player1)
-		notifyMatchingResult(sessionID, player2)
-	}
-}
-
-func (m *Matcher) rejoinMatch(sessionID string, player *session.Player) {
-	if err := session.PlayerRejoinExisting(sessionID, player); err != nil {
-		player.Conn.WriteJSON(struct {
-			Type  string `json:"type"`
-			Error string `json:"error"`
-		}{
-			Type:  "error",
-			Error: "Coulnd't join match: " + err.Error(),
-		})
-		return
+	span.SetAttributes(tracing.Int("queue_depth", len(pool.queue)))
+	if len(pool.queue) >= 2 {
+		player1 := pool.queue[0]
+		player2 := pool.queue[1]
+		pool.queue = pool.queue[2:]
+		now := time.Now()
+		matchWait.Observe(now.Sub(player1.since).Seconds(), pool.Key, "human")
+		matchWait.Observe(now.Sub(player2.since).Seconds(), pool.Key, "human")
+		// Both players queued in traces of their own
+		span.AddLink(player1.trace)
+		span.AddLink(player2.trace)
+		m.startSession(pool, player1.Player, player2.Player, true, span.Context())
+	}
+}
+
+/*
+Pair the player with a new bot on a random side. Must be called with m.mu held.
+*/
+func (m *Matcher) startBotGame(pool *Pool, player *session.Player, level int, trace tracing.SpanContext) {
+	if level == 0 {
+		level, _ = strconv.Atoi(env.GetEnv("BOT_LEVEL"))
+	}
+	level = bot.ClampLevel(level)
+	b := bot.New(fmt.Sprintf("bot-%d-%s", level, utils.GenerateUUID()), bot.NewEngine(level, m.enginePool))
+
+	white, black := player, b.Player()
+	if rand.Intn(2) == 0 {
+		white, black = black, white
+	}
+	// Games against the built-in bots don't count for ratings
+	m.startSession(pool, white, black, false, trace)
+}
+
+/*
+Start the session as a child of the trace span. Must be called with m.mu held
+*/
+func (m *Matcher) startSession(pool *Pool, player1, player2 *session.Player, rated bool, trace tracing.SpanContext) {
+	sessionID := generateSessionId()
+	session.InitSession(sessionID, player1, player2, session.Settings{
+		TimeControl: pool.TimeControl,
+		Pool:        pool.Key,
+		Variant:     pool.Variant,
+		Rated:       rated,
+		Trace:       trace,
+	})
+	m.SessionMap[player1.ID] = sessionID
+	m.SessionMap[player2.ID] = sessionID
+
+	logging.Info("init match",
+		zap.String("player_1", player1.ID),
+		zap.String("player_2", player2.ID),
+		zap.String("pool", pool.Key),
+	)
+
+	notifyMatchingResult(sessionID, player1)
+	notifyMatchingResult(sessionID, player2)
+}
+
+func (m *Matcher) rejoinMatch(sessionID string, player *session.Player, lastSeq *int64) {
+	if err := session.PlayerRejoinExisting(sessionID, player, lastSeq); err != nil {
+		player.Conn.WriteJSON(protocol.NewError("Coulnd't join match: " + err.Error()))
 	}
-	notifyMatchingResult(sessionID, player)
 }
 
 func notifyMatchingResult(sessionID string, player *session.Player) {
-	gameState, err := session.GetGameFen(sessionID)
+	matchState, err := session.MatchState(sessionID, player.ID)
 	if err != nil {
-		player.Conn.WriteJSON(struct {
-			Type  string `json:"type"`
-			Error string `json:"error"`
-		}{
-			Type:  "error",
-			Error: "Coulnd't join match: " + err.Error(),
-		})
+		player.Conn.WriteJSON(protocol.NewError("Coulnd't join match: " + err.Error()))
 		return
 	}
+	player.Conn.WriteJSON(matchState)
+}
 
-	playerState, err := session.GetPlayerState(sessionID, player.ID)
-	if err != nil {
-		player.Conn.WriteJSON(struct {
-			Type  string `json:"type"`
-			Error string `json:"error"`
-		}{
-			Type:  "error",
-			Error: "Coulnd't join match: " + err.Error(),
-		})
-	}
+/*
+The players waiting in a pool, first in line first
+*/
+type Queue struct {
+	Pool      string
+	HumanOnly bool
+	Players   []session.Player
+}
 
-	player.Conn.WriteJSON(matchResponse{
-		Type:        "matched",
-		SessionID:   sessionID,
-		GameState:   gameState,
-		PlayerState: playerState,
-	})
+/*
+The matching queue of every pool
+*/
+func (m *Matcher) Queues() []Queue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queues := make([]Queue, 0, len(m.pools))
+	for _, pool := range m.pools {
+		queue := Queue{Pool: pool.Key, HumanOnly: pool.HumanOnly, Players: make([]session.Player, len(pool.queue))}
+		for i, player := range pool.queue {
+			queue.Players[i] = *player.Player
+		}
+		queues = append(queues, queue)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Pool < queues[j].Pool })
+	return queues
 }
 
 /*
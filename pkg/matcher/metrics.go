@@ -0,0 +1,26 @@
+package matcher
+
+import (
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+)
+
+// Seconds, from a near instant match to the longest MATCHING_TIMEOUT worth setting
+var waitBuckets = []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}
+
+var matchWait = metrics.NewHistogram("chess_matchmaking_wait_seconds",
+	"Time players waited in a pool before being matched, by the kind of opponent found", waitBuckets, "pool", "opponent")
+
+/*
+Serve the number of players waiting in each pool
+*/
+func (m *Matcher) registerMetrics() {
+	metrics.NewGaugeFunc("chess_queue_depth", "Players waiting in the matching queue", []string{"pool"}, func() []metrics.Sample {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		samples := make([]metrics.Sample, 0, len(m.pools))
+		for _, pool := range m.pools {
+			samples = append(samples, metrics.Sample{LabelValues: []string{pool.Key}, Value: float64(len(pool.queue))})
+		}
+		return samples
+	})
+}
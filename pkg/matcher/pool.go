@@ -0,0 +1,76 @@
+package matcher
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/session"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+)
+
+// The only variant sessions can play so far
+const VariantStandard = "standard"
+
+// Suffix of a MATCH_POOLS entry that keeps bot accounts out of the pool
+const humanOnlySuffix = "/humans"
+
+/*
+A Pool is a matching queue for one kind of game. Players are only ever
+paired with others waiting in the same pool.
+*/
+type Pool struct {
+	Key         string // The time control, e.g. "5+3" or "unlimited"
+	TimeControl session.TimeControl
+	Variant     string
+	HumanOnly   bool // Bot accounts can't queue and nobody falls back to a bot
+	queue       []queuedPlayer
+}
+
+type queuedPlayer struct {
+	*session.Player
+	since time.Time           // When the player joined the queue
+	trace tracing.SpanContext // Span the player queued in, the parent of what happens to them next
+}
+
+/*
+Parse a comma separated list of time controls, each optionally followed by
+"/humans", e.g. "5+3,10+5/humans". The first pool is the default one.
+*/
+func parsePools(s string) ([]*Pool, error) {
+	var pools []*Pool
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		humanOnly := strings.HasSuffix(entry, humanOnlySuffix)
+		timeControl, err := session.ParseTimeControl(strings.TrimSuffix(entry, humanOnlySuffix))
+		if err != nil {
+			return nil, fmt.Errorf("pool %q: %w", entry, err)
+		}
+		key := timeControl.String()
+		if seen[key] {
+			return nil, fmt.Errorf("pool %q is defined twice", key)
+		}
+		seen[key] = true
+		pools = append(pools, &Pool{
+			Key:         key,
+			TimeControl: timeControl,
+			Variant:     VariantStandard,
+			HumanOnly:   humanOnly,
+		})
+	}
+	return pools, nil
+}
+
+/*
+Take the player out of the queue, returning their queue entry
+*/
+func (p *Pool) remove(player *session.Player) (queuedPlayer, bool) {
+	for i, queued := range p.queue {
+		if queued.Player == player {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return queued, true
+		}
+	}
+	return queuedPlayer{}, false
+}
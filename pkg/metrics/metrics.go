@@ -0,0 +1,338 @@
+/*
+Package metrics keeps counters, gauges and histograms in memory and serves
+them in the Prometheus text exposition format. Metrics are created once,
+usually as package variables next to the code they measure, and registered
+under their name when created.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Latency buckets in seconds, from 5ms to 10s
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	describe() *desc
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]metric{}
+)
+
+/*
+Add the metric to the ones served, replacing an earlier one of the same name
+*/
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[m.describe().name] = m
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	help := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, help, d.name, d.typ)
+}
+
+/*
+Key of a series, panicking when the label values don't match the labels,
+which is a programming error
+*/
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", d.name, d.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+/*
+The label set of a series in braces, with extra label pairs appended, or an
+empty string without any labels
+*/
+func (d *desc) labelSet(labelValues []string, extra ...string) string {
+	var pairs []string
+	for i, label := range d.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(labelValues[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+/*
+Values of a counter or gauge by label values
+*/
+type valueSeries struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*value
+}
+
+type value struct {
+	labelValues []string
+	v           float64
+}
+
+func (s *valueSeries) add(delta float64, labelValues []string) {
+	key := s.key(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[key] == nil {
+		s.values[key] = &value{labelValues: append([]string{}, labelValues...)}
+	}
+	s.values[key].v += delta
+}
+
+func (s *valueSeries) set(v float64, labelValues []string) {
+	key := s.key(labelValues)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = &value{labelValues: append([]string{}, labelValues...), v: v}
+}
+
+func (s *valueSeries) write(w io.Writer) {
+	s.writeHeader(w)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.labels) == 0 && len(s.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", s.name)
+		return
+	}
+	for _, key := range sortedKeys(s.values) {
+		value := s.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", s.name, s.labelSet(value.labelValues), formatValue(value.v))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+A value that only goes up, e.g. the number of moves played
+*/
+type Counter struct {
+	valueSeries
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{valueSeries{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		values: map[string]*value{},
+	}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+/*
+Add a non-negative amount
+*/
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metric " + c.name + ": counters can't decrease")
+	}
+	c.add(delta, labelValues)
+}
+
+/*
+A value that goes up and down, e.g. the number of open connections
+*/
+type Gauge struct {
+	valueSeries
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{valueSeries{
+		desc:   desc{name: name, help: help, typ: "gauge", labels: labels},
+		values: map[string]*value{},
+	}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.set(v, labelValues)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.add(1, labelValues)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.add(-1, labelValues)
+}
+
+/*
+One value of a GaugeFunc
+*/
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+/*
+A gauge read when the metrics are scraped, for values that are kept
+elsewhere anyway, e.g. the number of live sessions
+*/
+type GaugeFunc struct {
+	desc
+	f func() []Sample
+}
+
+func NewGaugeFunc(name, help string, labels []string, f func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	samples := g.f()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+	for _, sample := range samples {
+		g.key(sample.LabelValues)
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelSet(sample.LabelValues), formatValue(sample.Value))
+	}
+}
+
+/*
+Counts observations, e.g. latencies, in buckets of increasing upper bounds
+*/
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // Observations in each bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: append([]float64{}, buckets...),
+		series:  map[string]*histogramSeries{},
+	}
+	sort.Float64s(h.buckets)
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{labelValues: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelSet(s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelSet(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelSet(s.labelValues), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelSet(s.labelValues), s.count)
+	}
+}
+
+/*
+Write every metric in the Prometheus text format, ordered by name
+*/
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	metrics := make([]metric, 0, len(registry))
+	for _, name := range sortedKeys(registry) {
+		metrics = append(metrics, registry[name])
+	}
+	registryMu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+/*
+HTTP Handler serving the metrics to Prometheus
+*/
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	counter := NewCounter("test_events_total", "Events\nseen", "kind")
+	counter.Inc("a")
+	counter.Add(2, "a")
+	counter.Inc(`quo"te`)
+	gauge := NewGauge("test_open", "Open things")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	NewGaugeFunc("test_depth", "Depth", []string{"pool"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"5+3"}, Value: 4}, {LabelValues: []string{"1+0"}, Value: 2}}
+	})
+	histogram := NewHistogram("test_latency_seconds", "Latency", []float64{0.1, 1}, "op")
+	histogram.Observe(0.05, "get")
+	histogram.Observe(0.5, "get")
+	histogram.Observe(3, "get")
+
+	var b strings.Builder
+	if err := WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# HELP test_events_total Events\\nseen\n# TYPE test_events_total counter\n" +
+			"test_events_total{kind=\"a\"} 3\ntest_events_total{kind=\"quo\\\"te\"} 1\n",
+		"# TYPE test_open gauge\ntest_open 1\n",
+		"test_depth{pool=\"1+0\"} 2\ntest_depth{pool=\"5+3\"} 4\n",
+		"# TYPE test_latency_seconds histogram\n" +
+			"test_latency_seconds_bucket{op=\"get\",le=\"0.1\"} 1\n" +
+			"test_latency_seconds_bucket{op=\"get\",le=\"1\"} 2\n" +
+			"test_latency_seconds_bucket{op=\"get\",le=\"+Inf\"} 3\n" +
+			"test_latency_seconds_sum{op=\"get\"} 3.55\n" +
+			"test_latency_seconds_count{op=\"get\"} 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing\n%s\ngot\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "test_depth") > strings.Index(out, "test_events_total") {
+		t.Error("metrics aren't sorted by name")
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	counter := NewCounter("test_labelled_total", "Labelled", "kind")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	counter.Inc()
+}
@@ -0,0 +1,39 @@
+package openings
+
+import (
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+)
+
+var (
+	book     *opening.BookECO
+	loadBook sync.Once
+)
+
+/*
+The most specific ECO opening a game given as UCI moves followed, e.g.
+"C50" and "Italian Game". Empty if it left the book on the first move or a
+move is illegal.
+*/
+func Identify(moves []string) (eco string, name string) {
+	// Parsing the book takes a while, only do it once it's needed
+	loadBook.Do(func() { book = opening.NewBookECO() })
+
+	game := chess.NewGame()
+	for _, uci := range moves {
+		move, err := chess.UCINotation{}.Decode(game.Position(), uci)
+		if err != nil {
+			break
+		}
+		if err := game.Move(move); err != nil {
+			break
+		}
+	}
+	o := book.Find(game.Moves())
+	if o == nil {
+		return "", ""
+	}
+	return o.Code(), o.Title()
+}
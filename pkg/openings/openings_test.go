@@ -0,0 +1,25 @@
+package openings
+
+import (
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		moves []string
+		eco   string
+		name  string
+	}{
+		{[]string{"e2e4", "c7c5"}, "B20", "Sicilian Defense"},
+		{[]string{"e2e4", "e7e6", "d2d4", "d7d5", "h2h3", "h7h6"}, "C00", "French Defense"},
+		{[]string{"a2a3", "a7a6", "h2h3"}, "A00", "Anderssen's Opening"},
+		{nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		eco, name := Identify(tt.moves)
+		if eco != tt.eco || name != tt.name {
+			t.Errorf("%v: got %s %s, want %s %s", tt.moves, eco, name, tt.eco, tt.name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Package protocol defines the messages exchanged with clients over the websocket.
+
+Every client message is an envelope {"action": ..., "data": {...}} whose data
+decodes into the request struct for that action. Every server message carries
+a "type" field. After connecting, the server sends a hello announcing the
+protocol versions and features it supports; a client may answer with its own
+hello to pick a version. Clients that never send a hello speak version 1.
+*/
+package protocol
+
+// Version spoken with clients that don't negotiate
+const DefaultVersion = 1
+
+// Versions this server can speak, oldest first.
+// Version 2 answers rejected moves with move_rejected instead of error.
+var SupportedVersions = []int{1, 2}
+
+// First version with structured move rejections
+const MoveRejectionVersion = 2
+
+// Optional capabilities clients can detect from the hello message
+var Features = []string{
+	"resign",
+	"clock",
+	"heartbeat",
+	"opponent_presence",
+	"connection_auth",
+	"reauth",
+	"resume",
+	"draw_offers",
+	"chat",
+	"move_ack",
+	"move_notation",
+	"premove",
+	"bots",
+}
+
+/*
+Pick the newest version both sides support. ok is false if there is none.
+*/
+func Negotiate(clientVersions []int) (version int, ok bool) {
+	for _, supported := range SupportedVersions {
+		for _, v := range clientVersions {
+			if v == supported && v > version {
+				version = v
+			}
+		}
+	}
+	return version, version != 0
+}
+
+func IsSupported(version int) bool {
+	for _, supported := range SupportedVersions {
+		if supported == version {
+			return true
+		}
+	}
+	return false
+}
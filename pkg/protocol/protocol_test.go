@@ -0,0 +1,27 @@
+package protocol
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		client []int
+		want   int
+		wantOK bool
+	}{
+		{"exact", []int{1}, 1, true},
+		{"newer client", []int{1, 2, 99}, 2, true},
+		{"old client", []int{1}, 1, true},
+		{"no overlap", []int{99}, 0, false},
+		{"empty", nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Negotiate(tt.client)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got (%d, %v), want (%d, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package protocol
+
+// Client actions
+const (
+	ActionHello    = "hello"
+	ActionAuth     = "auth"
+	ActionReauth   = "reauth"
+	ActionMatching = "matching"
+	ActionMove     = "move"
+	ActionResign   = "resign"
+	ActionDraw     = "draw"
+	ActionChat     = "chat"
+	ActionPremove  = "premove"
+)
+
+/*
+Credentials that may be carried in the data of any action. Only needed by
+clients that didn't authenticate the connection; the token is verified once
+and then bound to the connection.
+*/
+type Auth struct {
+	JwtToken string `json:"jwt_token,omitempty"`
+}
+
+/*
+Authenticate the connection, or refresh the token of an authenticated one
+*/
+type AuthRequest struct {
+	JwtToken string `json:"jwt_token"`
+}
+
+type HelloRequest struct {
+	Versions []int `json:"versions"`
+}
+
+type MatchingRequest struct {
+	Auth
+	// Set when rejoining a session to replay the events after this sequence number
+	LastSeq *int64 `json:"last_seq,omitempty"`
+	// Time control of the pool to queue in, e.g. "5+3", empty for the default pool
+	Pool string `json:"pool,omitempty"`
+	// Play a bot instead of waiting for an opponent, at BotLevel (1-5, 0 for the default)
+	Bot      bool `json:"bot,omitempty"`
+	BotLevel int  `json:"bot_level,omitempty"`
+}
+
+type MoveRequest struct {
+	Auth
+	SessionID string `json:"session_id"`
+	Move      string `json:"move"`
+	// One of the Notation* constants; left empty the notation is detected from the move
+	Notation string `json:"notation,omitempty"`
+	// Optional client-chosen id; resubmitting a move with the same id is a no-op
+	MoveID string `json:"move_id,omitempty"`
+	// Optional ply this move should become, 1 for white's first move
+	Ply int `json:"ply,omitempty"`
+}
+
+// Move notations
+const (
+	NotationUCI = "uci" // e2e4, e7e8q
+	NotationSAN = "san" // e4, Nbd7, exd8=Q, O-O
+	NotationLAN = "lan" // e2-e4, Ng1-f3, e7xd8=Q
+)
+
+/*
+Queue a move to be played as soon as the opponent has moved, or cancel it
+*/
+type PremoveRequest struct {
+	Auth
+	SessionID string `json:"session_id"`
+	Op        string `json:"op"`
+	Move      string `json:"move,omitempty"`
+	Notation  string `json:"notation,omitempty"`
+	MoveID    string `json:"move_id,omitempty"`
+}
+
+// Premove request operations
+const (
+	PremoveSet    = "set"
+	PremoveCancel = "cancel"
+)
+
+type ResignRequest struct {
+	Auth
+	SessionID string `json:"session_id"`
+}
+
+// Draw request operations
+const (
+	DrawOffer   = "offer"
+	DrawAccept  = "accept"
+	DrawDecline = "decline"
+)
+
+type DrawRequest struct {
+	Auth
+	SessionID string `json:"session_id"`
+	Op        string `json:"op"`
+}
+
+type ChatRequest struct {
+	Auth
+	SessionID string `json:"session_id"`
+	Text      string `json:"text"`
+}
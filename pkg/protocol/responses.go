@@ -0,0 +1,283 @@
+package protocol
+
+// Server message types
+const (
+	TypeHello              = "hello"
+	TypeAuthenticated      = "authenticated"
+	TypeError              = "error"
+	TypeQueueing           = "queueing"
+	TypeTimeout            = "timeout"
+	TypeMatched            = "matched"
+	TypeStart              = "start"
+	TypeSession            = "session"
+	TypeEndgame            = "endgame"
+	TypeOpponentConnection = "opponent_connection"
+	TypeSnapshot           = "snapshot"
+	TypeChat               = "chat"
+	TypeDrawOffer          = "draw_offer"
+	TypeMoveAck            = "move_ack"
+	TypeMoveRejected       = "move_rejected"
+	TypePremove            = "premove"
+)
+
+/*
+Every message a session sends to its players carries a sequence number,
+increasing by one per event in the session. A reconnecting client sends the
+last number it saw to have the missed events replayed.
+*/
+type Sequence struct {
+	Seq int64 `json:"seq"`
+}
+
+func (s *Sequence) SetSeq(seq int64) {
+	s.Seq = seq
+}
+
+type Event interface {
+	SetSeq(seq int64)
+}
+
+/*
+Sent right after a client connects, and again in reply to a client hello
+with the negotiated version filled in
+*/
+type HelloResponse struct {
+	Type              string   `json:"type"`
+	ProtocolVersion   int      `json:"protocol_version"`
+	SupportedVersions []int    `json:"supported_versions"`
+	Features          []string `json:"features"`
+	UserID            string   `json:"user_id,omitempty"` // Set if the connection was authenticated during the upgrade
+}
+
+func NewHello(version int) HelloResponse {
+	return HelloResponse{
+		Type:              TypeHello,
+		ProtocolVersion:   version,
+		SupportedVersions: SupportedVersions,
+		Features:          Features,
+	}
+}
+
+/*
+Sent when the connection was authenticated or its token refreshed
+*/
+type AuthResponse struct {
+	Type      string `json:"type"`
+	UserID    string `json:"user_id"`
+	ExpiresAt int64  `json:"expires_at"` // Unix seconds
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+func NewError(msg string) ErrorResponse {
+	return ErrorResponse{
+		Type:  TypeError,
+		Error: msg,
+	}
+}
+
+type QueueingResponse struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+type TimeoutResponse struct {
+	Type    string `json:"type"`
+	Message string `json:"Message"`
+}
+
+type StartResponse struct {
+	Type string `json:"type"`
+}
+
+type PlayerState struct {
+	IsWhiteSide       bool `json:"is_white_side"`
+	OpponentConnected bool `json:"opponent_connected"`
+}
+
+// Game statuses
+const (
+	StatusActive = "ACTIVE"
+	StatusEnded  = "ENDED"
+)
+
+// Methods by which a game ends
+const (
+	MethodCheckmate            = "checkmate"
+	MethodResignation          = "resignation"
+	MethodAborted              = "aborted" // Ended before both players moved, without a result
+	MethodTimeout              = "timeout"
+	MethodAdjudication         = "adjudication" // Result set by an admin
+	MethodDrawAgreement        = "draw_agreement"
+	MethodStalemate            = "stalemate"
+	MethodThreefoldRepetition  = "threefold_repetition"
+	MethodFivefoldRepetition   = "fivefold_repetition"
+	MethodFiftyMoveRule        = "fifty_move_rule"
+	MethodSeventyFiveMoveRule  = "seventy_five_move_rule"
+	MethodInsufficientMaterial = "insufficient_material"
+)
+
+type MoveRecord struct {
+	Uci string `json:"uci"`
+	San string `json:"san"`
+}
+
+/*
+The state of the board and the game, as seen by both players
+*/
+type GameState struct {
+	Fen         string       `json:"fen"`
+	Status      string       `json:"status"`
+	IsWhiteTurn bool         `json:"is_white_turn"`
+	InCheck     bool         `json:"in_check"` // Whether the side to move is in check
+	LastMove    *MoveRecord  `json:"last_move,omitempty"`
+	LegalMoves  []string     `json:"legal_moves"` // UCI moves available to the side to move
+	Moves       []MoveRecord `json:"moves"`
+	Outcome     string       `json:"outcome"`          // "*", "1-0", "0-1" or "1/2-1/2"
+	Method      string       `json:"method,omitempty"` // One of the Method* constants once the game ended
+}
+
+type MatchResponse struct {
+	Type string `json:"type"`
+	Sequence
+	SessionID   string      `json:"session_id"`
+	GameState   string      `json:"game_state"` // FEN, kept for version 1 clients
+	State       *GameState  `json:"state"`
+	PlayerState PlayerState `json:"player_state"`
+}
+
+type ClockState struct {
+	TimeControl string `json:"time_control"`
+	WhiteMs     int64  `json:"white_ms"`
+	BlackMs     int64  `json:"black_ms"`
+	Running     string `json:"running,omitempty"` // Side whose clock is running
+}
+
+type SessionResponse struct {
+	Type string `json:"type"`
+	Sequence
+	GameState   string      `json:"game_state"` // FEN, kept for version 1 clients
+	State       *GameState  `json:"state"`
+	PlayerState PlayerState `json:"player_state"`
+	Clock       *ClockState `json:"clock,omitempty"`
+}
+
+/*
+Full session state, sent to a resuming client whose missed events are no longer retained
+*/
+type SnapshotResponse struct {
+	Type string `json:"type"`
+	Sequence
+	SessionID   string        `json:"session_id"`
+	GameState   string        `json:"game_state"` // FEN, kept for version 1 clients
+	State       *GameState    `json:"state"`
+	PlayerState PlayerState   `json:"player_state"`
+	Clock       *ClockState   `json:"clock,omitempty"`
+	DrawOffer   string        `json:"draw_offer,omitempty"` // Side with a pending draw offer
+	Premove     string        `json:"premove,omitempty"`    // The player's pending premove
+	Chat        []ChatMessage `json:"chat"`
+}
+
+type ChatMessage struct {
+	From   string `json:"from"`
+	Text   string `json:"text"`
+	SentAt int64  `json:"sent_at"` // Unix milliseconds
+}
+
+type ChatResponse struct {
+	Type string `json:"type"`
+	Sequence
+	ChatMessage
+}
+
+// Draw offer statuses
+const (
+	DrawOffered  = "offered"
+	DrawDeclined = "declined"
+)
+
+type DrawOfferResponse struct {
+	Type string `json:"type"`
+	Sequence
+	By     string `json:"by"` // Side that offered or declined
+	Status string `json:"status"`
+}
+
+// Premove statuses
+const (
+	PremoveQueued    = "queued"
+	PremoveCancelled = "cancelled"
+	PremoveDropped   = "dropped" // Not legal once the opponent had moved
+)
+
+/*
+Sent to a player when its premove is queued, cancelled or dropped. A premove
+that is played is acknowledged with a move_ack like any other move.
+*/
+type PremoveResponse struct {
+	Type string `json:"type"`
+	Sequence
+	Status string `json:"status"`
+	Move   string `json:"move,omitempty"`
+	MoveID string `json:"move_id,omitempty"`
+	Reason string `json:"reason,omitempty"` // Reject code of a dropped premove
+	Error  string `json:"error,omitempty"`
+}
+
+type EndgameData struct {
+	GameOutcome string     `json:"game_outcome"`
+	Method      string     `json:"method"`
+	State       *GameState `json:"state"`
+}
+
+type EndgameResponse struct {
+	Type string      `json:"type"`
+	Data EndgameData `json:"data"`
+}
+
+/*
+Sent to a player when their opponent's connection is lost or restored
+*/
+type OpponentConnectionResponse struct {
+	Type string `json:"type"`
+	Sequence
+	Connected bool `json:"connected"`
+}
+
+/*
+Sent to the mover once its move was applied, or again if it resubmits it
+*/
+type MoveAckResponse struct {
+	Type string `json:"type"`
+	Sequence
+	MoveID string `json:"move_id,omitempty"`
+	Ply    int    `json:"ply"`
+	Move   string `json:"move"` // UCI
+	San    string `json:"san"`
+}
+
+// Reasons a move is rejected
+const (
+	RejectInvalidRequest  = "invalid_request"
+	RejectNoSession       = "no_session"
+	RejectNotYourTurn     = "not_your_turn"
+	RejectWrongPly        = "wrong_ply"
+	RejectUnparseableMove = "unparseable_move"
+	RejectIllegalMove     = "illegal_move"
+	RejectAmbiguousMove   = "ambiguous_move"
+	RejectOutOfTime       = "out_of_time"
+)
+
+/*
+Sent to the mover instead of an error when its move wasn't applied.
+Only sent to clients speaking protocol version 2 or newer.
+*/
+type MoveRejectedResponse struct {
+	Type   string `json:"type"`
+	MoveID string `json:"move_id,omitempty"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
@@ -0,0 +1,62 @@
+package rating
+
+import (
+	"math"
+)
+
+// Rating of a player without rated games in a pool
+const Default = 1500
+
+// Games in a pool after which a rating changes more slowly
+const provisionalGames = 30
+
+/*
+A player's standing in a pool before a game
+*/
+type Player struct {
+	Rating int
+	Games  int
+}
+
+/*
+How fast a rating moves. Provisional ratings settle quickly.
+*/
+func KFactor(games int) float64 {
+	if games < provisionalGames {
+		return 40
+	}
+	return 20
+}
+
+/*
+Expected score against the opponent, between 0 and 1
+*/
+func Expected(rating, opponent int) float64 {
+	return 1 / (1 + math.Pow(10, float64(opponent-rating)/400))
+}
+
+/*
+New ratings of both players after a game in which white scored whiteScore:
+1 for a win, 0.5 for a draw and 0 for a loss
+*/
+func Update(white, black Player, whiteScore float64) (int, int) {
+	whiteDelta := KFactor(white.Games) * (whiteScore - Expected(white.Rating, black.Rating))
+	blackDelta := KFactor(black.Games) * ((1 - whiteScore) - Expected(black.Rating, white.Rating))
+	return white.Rating + int(math.Round(whiteDelta)), black.Rating + int(math.Round(blackDelta))
+}
+
+/*
+Performance rating over games against opponents of the given ratings, with
+the player's total score in them
+*/
+func Performance(opponents []int, score float64) int {
+	if len(opponents) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, rating := range opponents {
+		sum += rating
+	}
+	n := float64(len(opponents))
+	return int(math.Round(float64(sum)/n + 400*(2*score-n)/n))
+}
@@ -0,0 +1,34 @@
+package rating
+
+import (
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		white, black         Player
+		score                float64
+		wantWhite, wantBlack int
+	}{
+		{Player{1500, 0}, Player{1500, 0}, 1, 1520, 1480},
+		{Player{1500, 50}, Player{1500, 50}, 0.5, 1500, 1500},
+		{Player{1800, 50}, Player{1400, 50}, 1, 1802, 1398},
+		{Player{1400, 50}, Player{1800, 10}, 1, 1418, 1764},
+	}
+	for _, tt := range tests {
+		white, black := Update(tt.white, tt.black, tt.score)
+		if white != tt.wantWhite || black != tt.wantBlack {
+			t.Errorf("Update(%v, %v, %v): got %d/%d, want %d/%d",
+				tt.white, tt.black, tt.score, white, black, tt.wantWhite, tt.wantBlack)
+		}
+	}
+}
+
+func TestPerformance(t *testing.T) {
+	if got := Performance([]int{1500, 1700}, 1); got != 1600 {
+		t.Errorf("got %d, want 1600", got)
+	}
+	if got := Performance([]int{1500, 1500}, 2); got != 1900 {
+		t.Errorf("got %d, want 1900", got)
+	}
+}
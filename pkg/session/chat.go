@@ -0,0 +1,51 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+)
+
+const (
+	maxChatLength  = 500
+	maxChatHistory = 100
+)
+
+func (session *GameSession) handleChat(playerID, text string) error {
+	player, err := session.GetPlayerById(playerID)
+	if err != nil {
+		return err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("empty chat message")
+	}
+	if len(text) > maxChatLength {
+		return errors.New("chat message too long")
+	}
+
+	message := protocol.ChatMessage{
+		From:   player.ID,
+		Text:   text,
+		SentAt: time.Now().UnixMilli(),
+	}
+	session.chat = append(session.chat, message)
+	if len(session.chat) > maxChatHistory {
+		session.chat = session.chat[len(session.chat)-maxChatHistory:]
+	}
+
+	session.emit(
+		&protocol.ChatResponse{Type: protocol.TypeChat, ChatMessage: message},
+		&protocol.ChatResponse{Type: protocol.TypeChat, ChatMessage: message},
+	)
+	return nil
+}
+
+/*
+The latest chat messages, oldest first
+*/
+func (session *GameSession) ChatLog() []protocol.ChatMessage {
+	return append([]protocol.ChatMessage{}, session.chat...)
+}
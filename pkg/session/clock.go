@@ -0,0 +1,142 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+/*
+A TimeControl describes the initial time on each player's clock and the
+increment added after every move. A zero TimeControl means the game is untimed.
+*/
+type TimeControl struct {
+	Initial   time.Duration
+	Increment time.Duration
+}
+
+/*
+Parse a time control of the form "<minutes>+<increment seconds>", e.g. "5+3".
+An empty string or "unlimited" yields an untimed TimeControl.
+*/
+func ParseTimeControl(s string) (TimeControl, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "unlimited" {
+		return TimeControl{}, nil
+	}
+
+	initialStr, incrementStr, found := strings.Cut(s, "+")
+	if !found {
+		incrementStr = "0"
+	}
+	minutes, err := strconv.ParseFloat(initialStr, 64)
+	if err != nil || minutes < 0 {
+		return TimeControl{}, errors.New("invalid time control initial time")
+	}
+	seconds, err := strconv.Atoi(incrementStr)
+	if err != nil || seconds < 0 {
+		return TimeControl{}, errors.New("invalid time control increment")
+	}
+
+	return TimeControl{
+		Initial:   time.Duration(minutes * float64(time.Minute)),
+		Increment: time.Duration(seconds) * time.Second,
+	}, nil
+}
+
+func (tc TimeControl) IsUnlimited() bool {
+	return tc.Initial == 0 && tc.Increment == 0
+}
+
+func (tc TimeControl) String() string {
+	if tc.IsUnlimited() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%s+%d", strconv.FormatFloat(tc.Initial.Minutes(), 'f', -1, 64), int(tc.Increment.Seconds()))
+}
+
+/*
+A Clock tracks the remaining time of both players. The clock only starts
+running after white's first move, so neither side loses time while the
+opponent is still connecting.
+*/
+type Clock struct {
+	TimeControl TimeControl
+	remaining   map[chess.Color]time.Duration
+	turn        chess.Color
+	turnStart   time.Time
+	running     bool
+}
+
+func NewClock(tc TimeControl) *Clock {
+	return &Clock{
+		TimeControl: tc,
+		remaining: map[chess.Color]time.Duration{
+			chess.White: tc.Initial,
+			chess.Black: tc.Initial,
+		},
+		turn: chess.White,
+	}
+}
+
+/*
+Return the time left for the given side at the given instant.
+*/
+func (c *Clock) Remaining(color chess.Color, now time.Time) time.Duration {
+	remaining := c.remaining[color]
+	if c.running && c.turn == color {
+		remaining -= now.Sub(c.turnStart)
+	}
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+/*
+Report whether the given side has run out of time
+*/
+func (c *Clock) Flagged(color chess.Color, now time.Time) bool {
+	return !c.TimeControl.IsUnlimited() && c.running && c.Remaining(color, now) <= 0
+}
+
+/*
+Stop the mover's clock, add the increment and start the opponent's clock
+*/
+func (c *Clock) Punch(color chess.Color, now time.Time) {
+	if c.TimeControl.IsUnlimited() {
+		return
+	}
+	if c.running {
+		c.remaining[color] = c.Remaining(color, now) + c.TimeControl.Increment
+	} else if color == chess.White {
+		c.running = true
+	}
+	c.turn = color.Other()
+	c.turnStart = now
+}
+
+/*
+Return the side whose clock is running and how long until it flags.
+ok is false when no clock is running.
+*/
+func (c *Clock) NextFlag(now time.Time) (color chess.Color, in time.Duration, ok bool) {
+	if c.TimeControl.IsUnlimited() || !c.running {
+		return chess.NoColor, 0, false
+	}
+	return c.turn, c.Remaining(c.turn, now), true
+}
+
+/*
+Freeze the clock, e.g. when the game ended.
+*/
+func (c *Clock) Stop(now time.Time) {
+	if c.running {
+		c.remaining[c.turn] = c.Remaining(c.turn, now)
+		c.running = false
+	}
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"errors"
+
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+/*
+Offering a draw while the opponent's offer is pending accepts it. A pending
+offer is also declined by the opponent making a move.
+*/
+func (session *GameSession) handleDraw(playerID, op string) error {
+	player, err := session.GetPlayerById(playerID)
+	if err != nil {
+		return err
+	}
+	color := session.colorOf(player)
+
+	switch op {
+	case protocol.DrawOffer:
+		if session.drawOffer == color.Other() {
+			return session.acceptDraw(player)
+		}
+		if session.drawOffer == color {
+			return errors.New("draw already offered")
+		}
+		session.drawOffer = color
+		session.emitDrawOffer(color, protocol.DrawOffered)
+	case protocol.DrawAccept:
+		if session.drawOffer != color.Other() {
+			return errors.New("no draw offer to accept")
+		}
+		return session.acceptDraw(player)
+	case protocol.DrawDecline:
+		if session.drawOffer != color.Other() {
+			return errors.New("no draw offer to decline")
+		}
+		session.drawOffer = chess.NoColor
+		session.emitDrawOffer(color, protocol.DrawDeclined)
+	default:
+		return errors.New("unknown draw operation")
+	}
+	return nil
+}
+
+func (session *GameSession) acceptDraw(player *Player) error {
+	if err := session.Game.Draw(chess.DrawOffer); err != nil {
+		return err
+	}
+	session.drawOffer = chess.NoColor
+	logging.Info("draw agreed",
+		zap.String("session_id", session.ID),
+		zap.String("id", player.ID),
+	)
+	return nil
+}
+
+func (session *GameSession) emitDrawOffer(by chess.Color, status string) {
+	session.emit(
+		&protocol.DrawOfferResponse{Type: protocol.TypeDrawOffer, By: colorName(by), Status: status},
+		&protocol.DrawOfferResponse{Type: protocol.TypeDrawOffer, By: colorName(by), Status: status},
+	)
+}
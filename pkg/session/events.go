@@ -0,0 +1,180 @@
+package session
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+/*
+An event as each side saw it. Either side may be nil if the event was only
+meant for the other player.
+*/
+type loggedEvent struct {
+	seq   int64
+	white protocol.Event
+	black protocol.Event
+}
+
+/*
+A bounded log of the events sent to the players of a session, used to replay
+what a reconnecting player missed
+*/
+type eventLog struct {
+	lastSeq int64
+	events  []loggedEvent
+	limit   int
+}
+
+// Events retained when SESSION_EVENT_LOG_SIZE isn't a positive number
+const defaultEventLogSize = 256
+
+/*
+The events each session retains, read from SESSION_EVENT_LOG_SIZE once so a
+bad value is reported once rather than for every session
+*/
+var eventLogSize = sync.OnceValue(func() int {
+	size, err := strconv.Atoi(env.GetEnv("SESSION_EVENT_LOG_SIZE"))
+	if err != nil || size <= 0 {
+		logging.Warn("invalid SESSION_EVENT_LOG_SIZE, using the default",
+			zap.String("value", env.GetEnv("SESSION_EVENT_LOG_SIZE")),
+			zap.Int("default", defaultEventLogSize),
+		)
+		return defaultEventLogSize
+	}
+	return size
+})
+
+func newEventLog() *eventLog {
+	return &eventLog{limit: eventLogSize()}
+}
+
+func (l *eventLog) append(white, black protocol.Event) {
+	l.lastSeq++
+	if white != nil {
+		white.SetSeq(l.lastSeq)
+	}
+	if black != nil {
+		black.SetSeq(l.lastSeq)
+	}
+	l.events = append(l.events, loggedEvent{seq: l.lastSeq, white: white, black: black})
+	if len(l.events) > l.limit {
+		l.events = l.events[len(l.events)-l.limit:]
+	}
+}
+
+/*
+Return the events after lastSeq for the given side. ok is false if some of
+them are no longer retained, or lastSeq is from the future.
+*/
+func (l *eventLog) since(lastSeq int64, color chess.Color) (events []protocol.Event, ok bool) {
+	if lastSeq > l.lastSeq || lastSeq < 0 {
+		return nil, false
+	}
+	if lastSeq < l.lastSeq && (len(l.events) == 0 || l.events[0].seq > lastSeq+1) {
+		return nil, false
+	}
+	for _, e := range l.events {
+		if e.seq <= lastSeq {
+			continue
+		}
+		event := e.white
+		if color == chess.Black {
+			event = e.black
+		}
+		if event != nil {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
+
+/*
+Record an event and send each side its version of it
+*/
+func (session *GameSession) emit(white, black protocol.Event) {
+	session.events.append(white, black)
+	if white != nil {
+		session.WhitePlayer.send(white)
+	}
+	if black != nil {
+		session.BlackPlayer.send(black)
+	}
+}
+
+/*
+Record an event sent to only one of the players
+*/
+func (session *GameSession) emitTo(player *Player, event protocol.Event) {
+	if player == session.WhitePlayer {
+		session.emit(event, nil)
+	} else {
+		session.emit(nil, event)
+	}
+}
+
+/*
+Bring a reconnecting player up to date, either by replaying the events after
+lastSeq or, if they are no longer retained, with a snapshot of the session
+*/
+func (session *GameSession) resume(player *Player, lastSeq int64) {
+	events, ok := session.events.since(lastSeq, session.colorOf(player))
+	if !ok {
+		player.send(session.snapshot(player))
+		return
+	}
+	for _, event := range events {
+		player.send(event)
+	}
+}
+
+func (session *GameSession) snapshot(player *Player) *protocol.SnapshotResponse {
+	drawOffer := ""
+	if session.drawOffer != chess.NoColor {
+		drawOffer = colorName(session.drawOffer)
+	}
+
+	return &protocol.SnapshotResponse{
+		Type:        protocol.TypeSnapshot,
+		Sequence:    protocol.Sequence{Seq: session.events.lastSeq},
+		SessionID:   session.ID,
+		GameState:   session.Game.FEN(),
+		State:       session.State(),
+		PlayerState: session.playerState(player),
+		Clock:       session.clockState(),
+		DrawOffer:   drawOffer,
+		Premove:     session.premoveOf(player),
+		Chat:        append([]protocol.ChatMessage{}, session.chat...),
+	}
+}
+
+func (session *GameSession) clockState() *protocol.ClockState {
+	if session.Clock.TimeControl.IsUnlimited() {
+		return nil
+	}
+	now := time.Now()
+	running := ""
+	if color, _, ok := session.Clock.NextFlag(now); ok {
+		running = colorName(color)
+	}
+	return &protocol.ClockState{
+		TimeControl: session.Clock.TimeControl.String(),
+		WhiteMs:     session.Clock.Remaining(chess.White, now).Milliseconds(),
+		BlackMs:     session.Clock.Remaining(chess.Black, now).Milliseconds(),
+		Running:     running,
+	}
+}
+
+func colorName(color chess.Color) string {
+	if color == chess.White {
+		return "white"
+	}
+	return "black"
+}
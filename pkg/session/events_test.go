@@ -0,0 +1,55 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/notnil/chess"
+)
+
+func TestEventLogSince(t *testing.T) {
+	log := &eventLog{limit: 3}
+	for i := 0; i < 5; i++ {
+		white := &protocol.SessionResponse{Type: protocol.TypeSession}
+		var black protocol.Event
+		if i%2 == 0 {
+			black = &protocol.SessionResponse{Type: protocol.TypeSession}
+		}
+		log.append(white, black)
+	}
+
+	tests := []struct {
+		name    string
+		lastSeq int64
+		color   chess.Color
+		want    []int64
+		wantOK  bool
+	}{
+		{"up to date", 5, chess.White, nil, true},
+		{"replay white", 2, chess.White, []int64{3, 4, 5}, true},
+		{"replay black skips white only events", 2, chess.Black, []int64{3, 5}, true},
+		{"gap too large", 1, chess.White, nil, false},
+		{"from the future", 9, chess.White, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, ok := log.since(tt.lastSeq, tt.color)
+			if ok != tt.wantOK {
+				t.Fatalf("ok: got %v, want %v", ok, tt.wantOK)
+			}
+			var got []int64
+			for _, e := range events {
+				got = append(got, e.(*protocol.SessionResponse).Seq)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -3,239 +3,752 @@ package session
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
 	"github.com/notnil/chess"
 
-	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
+/*
+A GameSession is owned by a single goroutine which applies commands (moves,
+resignations, joins, clock ticks) one at a time from its command channel.
+All reads and writes of the game state and all player notifications happen
+on that goroutine, so state changes and broadcasts are strictly ordered.
+*/
 type GameSession struct {
+	ID          string
 	WhitePlayer *Player
 	BlackPlayer *Player
 	Game        *chess.Game
+	Clock       *Clock
+	Pool        string // Matching pool the players came from
+	Variant     string
+	Rated       bool
+	StartedAt   time.Time
+
+	// Set when the game ends by a method chess.Game doesn't know about (e.g. timeout)
+	outcome chess.Outcome
+	method  string
+
+	events    *eventLog
+	chat      []protocol.ChatMessage
+	drawOffer chess.Color    // Side with a pending draw offer
+	premove   *premove       // Move queued by the side not to move
+	moveTimes []time.Time    // When each move was played
+	moveIDs   map[string]int // Ply of each move submitted with a client move id, by player and move id
+
+	// Parent of the spans of the session's moves and its end
+	trace tracing.SpanContext
+
+	commands chan command
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-type SessionResponse struct {
-	Type        string      `json:"type"`
-	GameState   string      `json:"game_state"`
-	PlayerState PlayerState `json:"player_state"`
-}
+type commandKind int
+
+const (
+	cmdMove commandKind = iota
+	cmdResign
+	cmdJoin
+	cmdDisconnect
+	cmdDraw
+	cmdChat
+	cmdPremove
+	cmdAbort
+	cmdForceEnd
+	cmdInspect
+)
 
-type PlayerState struct {
-	IsWhiteSide bool `json:"is_white_side"`
+type command struct {
+	kind     commandKind
+	playerID string
+	connID   string
+	move     MoveSubmission
+	player   *Player
+	lastSeq  *int64
+	op       string
+	text     string
+	outcome  chess.Outcome
+	inspect  func(*GameSession)
+	reply    chan error
 }
 
+const commandBufferSize = 16
+
+var (
+	ErrSessionClosed  = errors.New("session closed")
+	ErrUnknownSession = errors.New("invalid session id")
+)
+
+// gameSessions maps session ids to live sessions. mu only guards the map itself;
+// each session's state is owned by its own goroutine.
 var gameSessions = make(map[string]*GameSession)
 var mu sync.RWMutex
 var gameOverHandler = func(session *GameSession, sessionID string) {
 	CloseSession(sessionID)
-	session.WhitePlayer.Conn.Close()
-	session.BlackPlayer.Conn.Close()
+	for _, player := range session.GetPlayers() {
+		if player.Conn != nil {
+			player.Conn.Close()
+		}
+	}
 }
 
-func InitSession(sessionID string, whitePlayer *Player, blackPlayer *Player) {
-	gameSessions[sessionID] = &GameSession{
+/*
+How a session is played, as decided by the matcher
+*/
+type Settings struct {
+	TimeControl TimeControl
+	Pool        string
+	Variant     string
+	Rated       bool
+	Trace       tracing.SpanContext // Parent of the session's spans, e.g. the match that started it
+}
+
+/*
+Create a session for the player pair and start its goroutine
+*/
+func InitSession(sessionID string, whitePlayer *Player, blackPlayer *Player, settings Settings) {
+	span := tracing.StartFrom(settings.Trace, "InitSession",
+		tracing.String("session_id", sessionID),
+		tracing.String("white", whitePlayer.ID),
+		tracing.String("black", blackPlayer.ID),
+		tracing.String("pool", settings.Pool),
+		tracing.Bool("rated", settings.Rated),
+	)
+	defer span.End()
+	session := &GameSession{
+		ID:          sessionID,
 		WhitePlayer: whitePlayer,
 		BlackPlayer: blackPlayer,
 		Game:        chess.NewGame(),
+		Clock:       NewClock(settings.TimeControl),
+		Pool:        settings.Pool,
+		Variant:     settings.Variant,
+		Rated:       settings.Rated,
+		StartedAt:   time.Now(),
+		outcome:     chess.NoOutcome,
+		events:      newEventLog(),
+		drawOffer:   chess.NoColor,
+		moveIDs:     map[string]int{},
+		trace:       span.Context(),
+		commands:    make(chan command, commandBufferSize),
+		done:        make(chan struct{}),
 	}
+
+	mu.Lock()
+	gameSessions[sessionID] = session
+	mu.Unlock()
+
+	go session.run()
 }
 
+/*
+Remove the session from tracking and stop its goroutine. Safe to call from
+the game over handler.
+*/
 func CloseSession(sessionID string) {
 	mu.Lock()
-	defer mu.Unlock()
+	session, exists := gameSessions[sessionID]
 	delete(gameSessions, sessionID)
+	mu.Unlock()
+
+	if exists {
+		session.stop()
+	}
 }
 
 func SetGameOverHandler(govHandler func(*GameSession, string)) {
 	gameOverHandler = govHandler
 }
+
 func StartGame(session *GameSession) {
 	for _, player := range []*Player{session.WhitePlayer, session.BlackPlayer} {
-		err := player.Conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"start"}`))
+		err := player.Conn.WriteJSON(protocol.StartResponse{
+			Type: protocol.TypeStart,
+		})
 		if err != nil {
 			logging.Error("Error sending start message", zap.Error(err))
 		}
 	}
 }
 
-func (session *GameSession) GetPlayers() [2]*Player {
-	return [2]*Player{session.WhitePlayer, session.BlackPlayer}
-}
-
-func (session *GameSession) GetPlayerById(playerID string) (*Player, error) {
-	if session.WhitePlayer.ID == playerID {
-		return session.WhitePlayer, nil
-	} else if session.BlackPlayer.ID == playerID {
-		return session.BlackPlayer, nil
+/*
+Ids of the sessions being played
+*/
+func SessionIDs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	ids := make([]string, 0, len(gameSessions))
+	for id := range gameSessions {
+		ids = append(ids, id)
 	}
-	return nil, errors.New("player not in session")
+	return ids
 }
 
-func (session *GameSession) GetPlayerSide(playerID string) (bool, error) {
-	return session.WhitePlayer.ID == playerID, nil
+/*
+Number of sessions being played
+*/
+func Count() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(gameSessions)
 }
 
-func GetGameFen(sessionID string) (string, error) {
+func getSession(sessionID string) (*GameSession, error) {
 	mu.RLock()
 	defer mu.RUnlock()
 	session, exists := gameSessions[sessionID]
-	if exists {
-		return session.Game.FEN(), nil
+	if !exists {
+		return nil, ErrUnknownSession
 	}
-	return "", errors.New("invalid session id")
+	return session, nil
 }
 
-func GetPlayerState(sessionID, playerID string) (PlayerState, error) {
-	mu.RLock()
-	defer mu.RUnlock()
-	session, exists := gameSessions[sessionID]
-	if exists {
-		isWhiteSide, err := session.GetPlayerSide(playerID)
-		if err != nil {
-			return PlayerState{}, errors.New("invalid player id")
+func (session *GameSession) stop() {
+	session.stopOnce.Do(func() {
+		close(session.done)
+	})
+}
+
+/*
+Hand a command to the session goroutine and wait for it to be applied
+*/
+func (session *GameSession) do(cmd command) error {
+	cmd.reply = make(chan error, 1)
+	select {
+	case session.commands <- cmd:
+	case <-session.done:
+		return ErrSessionClosed
+	}
+
+	select {
+	case err := <-cmd.reply:
+		return err
+	case <-session.done:
+		// The command that ended the session still gets its reply
+		select {
+		case err := <-cmd.reply:
+			return err
+		default:
+			return ErrSessionClosed
 		}
-		return PlayerState{
-			IsWhiteSide: isWhiteSide,
-		}, nil
 	}
-	return PlayerState{}, errors.New("invalid session id")
 }
 
-func PlayerInSession(sessionID string, player *Player) bool {
-	mu.Lock()
-	defer mu.Unlock()
-	session, exists := gameSessions[sessionID]
-	if exists {
-		_, err := session.GetPlayerById(player.ID)
-		return err != nil
+/*
+The session goroutine. It exits once the session is closed; if the game ended
+on this goroutine the game over handler runs after the loop, so the handler
+may freely call back into other modules without blocking this session.
+*/
+func (session *GameSession) run() {
+	flagTimer := time.NewTimer(time.Hour)
+	flagTimer.Stop()
+	defer flagTimer.Stop()
+
+	for {
+		session.resetFlagTimer(flagTimer)
+
+		select {
+		case cmd := <-session.commands:
+			cmd.reply <- session.handle(cmd)
+		case <-flagTimer.C:
+			session.handleTick()
+		case <-session.done:
+			return
+		}
+
+		if session.Ended() {
+			session.Clock.Stop(time.Now())
+			session.stop()
+			gameOverHandler(session, session.ID)
+			return
+		}
 	}
-	return false
 }
 
-func PlayerRejoinExisting(sessionID string, player *Player) error {
-	mu.Lock()
-	defer mu.Unlock()
-	session, exists := gameSessions[sessionID]
-	if exists {
-		if p, err := session.GetPlayerById(player.ID); err == nil {
-			if p != nil {
-				p.Conn = player.Conn
-				logging.Info("Player rejoined session", zap.String("sessionID", sessionID))
-				return nil
-			}
+func (session *GameSession) resetFlagTimer(flagTimer *time.Timer) {
+	if !flagTimer.Stop() {
+		select {
+		case <-flagTimer.C:
+		default:
 		}
-		return errors.New("player id not in the session")
 	}
-	return errors.New("invalid session id")
+	if _, in, ok := session.Clock.NextFlag(time.Now()); ok {
+		flagTimer.Reset(in)
+	}
 }
 
-func PlayerDisconnect(sessionID, playerID string) error {
-	mu.Lock()
-	defer mu.Unlock()
-	session, exists := gameSessions[sessionID]
-	if !exists {
-		return errors.New("invalid session id")
+func (session *GameSession) handle(cmd command) error {
+	switch cmd.kind {
+	case cmdMove:
+		return session.handleMove(cmd.playerID, cmd.move)
+	case cmdResign:
+		return session.handleResign(cmd.playerID)
+	case cmdJoin:
+		return session.handleJoin(cmd.player, cmd.lastSeq)
+	case cmdDisconnect:
+		return session.handleDisconnect(cmd.playerID, cmd.connID)
+	case cmdDraw:
+		return session.handleDraw(cmd.playerID, cmd.op)
+	case cmdChat:
+		return session.handleChat(cmd.playerID, cmd.text)
+	case cmdPremove:
+		return session.handlePremove(cmd.playerID, cmd.op, cmd.move)
+	case cmdAbort:
+		return session.handleAbort(cmd.playerID)
+	case cmdForceEnd:
+		return session.handleForceEnd(cmd.outcome)
+	case cmdInspect:
+		cmd.inspect(session)
+		return nil
+	default:
+		return errors.New("unknown session command")
 	}
+}
 
+func (session *GameSession) handleResign(playerID string) error {
+	isWhiteSide, err := session.GetPlayerSide(playerID)
+	if err != nil {
+		return err
+	}
+	if isWhiteSide {
+		session.Game.Resign(chess.White)
+	} else {
+		session.Game.Resign(chess.Black)
+	}
+
+	logging.Info("player resigned",
+		zap.String("session_id", session.ID),
+		zap.String("id", playerID),
+	)
+	return nil
+}
+
+/*
+End the game without a result. A player may only abort before making their
+first move.
+*/
+func (session *GameSession) handleAbort(playerID string) error {
 	player, err := session.GetPlayerById(playerID)
 	if err != nil {
 		return err
 	}
+	moved := len(session.Game.Moves()) >= 2 ||
+		(len(session.Game.Moves()) == 1 && session.colorOf(player) == chess.White)
+	if moved {
+		return errors.New("the game can't be aborted after your first move")
+	}
+	session.method = protocol.MethodAborted
+
+	logging.Info("game aborted",
+		zap.String("session_id", session.ID),
+		zap.String("id", playerID),
+	)
+	return nil
+}
+
+/*
+End the game with the given result regardless of the position, or abort it
+for chess.NoOutcome
+*/
+func (session *GameSession) handleForceEnd(outcome chess.Outcome) error {
+	switch outcome {
+	case chess.NoOutcome:
+		session.method = protocol.MethodAborted
+	case chess.WhiteWon, chess.BlackWon, chess.Draw:
+		session.method = protocol.MethodAdjudication
+		session.outcome = outcome
+	default:
+		return errors.New("unknown outcome " + string(outcome))
+	}
+
+	logging.Info("game ended by an admin",
+		zap.String("session_id", session.ID),
+		zap.String("outcome", outcome.String()),
+	)
+	return nil
+}
+
+/*
+Attach the player's new connection and bring it up to date. With lastSeq the
+player receives the events it missed, otherwise just the current state.
+*/
+func (session *GameSession) handleJoin(player *Player, lastSeq *int64) error {
+	p, err := session.GetPlayerById(player.ID)
+	if err != nil {
+		return errors.New("player id not in the session")
+	}
+	p.Conn = player.Conn
+	p.ConnID = player.ConnID
+	logging.Info("Player rejoined session", zap.String("sessionID", session.ID))
+
+	p.send(session.matchResponse(p))
+	if lastSeq != nil {
+		session.resume(p, *lastSeq)
+	}
 
+	session.emitTo(session.opponentOf(p), &protocol.OpponentConnectionResponse{
+		Type:      protocol.TypeOpponentConnection,
+		Connected: true,
+	})
+	return nil
+}
+
+/*
+Detach a dead connection from the player. A disconnect of a connection the
+player has already replaced by rejoining is ignored.
+*/
+func (session *GameSession) handleDisconnect(playerID, connID string) error {
+	player, err := session.GetPlayerById(playerID)
+	if err != nil {
+		return err
+	}
+	if player.ConnID != connID {
+		return nil
+	}
 	player.Conn = nil
+
+	session.emitTo(session.opponentOf(player), &protocol.OpponentConnectionResponse{
+		Type:      protocol.TypeOpponentConnection,
+		Connected: false,
+	})
 	return nil
 }
 
-func ProcessMove(sessionID, movingPlayerID, move string) {
-	mu.Lock()
+/*
+Called when the running clock may have reached zero
+*/
+func (session *GameSession) handleTick() {
+	color, remaining, ok := session.Clock.NextFlag(time.Now())
+	if ok && remaining <= 0 {
+		session.flag(color)
+	}
+}
 
-	type errorResponse struct {
-		Type  string `json:"type"`
-		Error string `json:"error"`
+/*
+End the game on time. A player who runs out of time only loses if the
+opponent still has mating material.
+*/
+func (session *GameSession) flag(color chess.Color) {
+	if session.Ended() {
+		return
+	}
+	session.method = protocol.MethodTimeout
+	if !hasMatingMaterial(session.Game.Position().Board(), color.Other()) {
+		session.outcome = chess.Draw
+	} else if color == chess.White {
+		session.outcome = chess.BlackWon
+	} else {
+		session.outcome = chess.WhiteWon
 	}
 
-	session, exists := gameSessions[sessionID]
-	if exists {
+	logging.Info("player flagged",
+		zap.String("session_id", session.ID),
+		zap.String("color", color.Name()),
+	)
+}
 
-		if (session.Game.Position().Turn() == chess.White && session.WhitePlayer.ID != movingPlayerID) ||
-			(session.Game.Position().Turn() == chess.Black && session.BlackPlayer.ID != movingPlayerID) {
-			logging.Warn("Wrong player moving",
-				zap.String("session_id", sessionID),
-				zap.String("id", movingPlayerID),
-				zap.String("move", move),
-			)
-			mu.Unlock()
-			return
+func hasMatingMaterial(board *chess.Board, color chess.Color) bool {
+	for _, piece := range board.SquareMap() {
+		if piece.Color() == color && piece.Type() != chess.King {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Notify players about the new board state
+*/
+func (session *GameSession) broadcastState() {
+	state := session.State()
+	clock := session.clockState()
+	states := [2]*protocol.SessionResponse{}
+	for i, player := range session.GetPlayers() {
+		states[i] = &protocol.SessionResponse{
+			Type:        protocol.TypeSession,
+			GameState:   state.Fen,
+			State:       state,
+			PlayerState: session.playerState(player),
+			Clock:       clock,
 		}
+	}
+	session.emit(states[0], states[1])
+}
 
-		err := session.Game.MoveStr(move)
-		if err != nil {
-			logging.Warn("invalid move",
-				zap.String("session_id", sessionID),
-				zap.String("id", movingPlayerID),
-				zap.String("move", move),
-				zap.String("error", err.Error()),
-			)
-			player, err := session.GetPlayerById(movingPlayerID)
-
-			if err := player.Conn.WriteJSON(errorResponse{
-				Type:  "error",
-				Error: "invalid move: " + err.Error(),
-			}); err != nil {
-				logging.Info("ws write", zap.Error(err))
-			}
-			mu.Unlock()
-			return
+func (session *GameSession) matchResponse(player *Player) protocol.MatchResponse {
+	return protocol.MatchResponse{
+		Type:        protocol.TypeMatched,
+		Sequence:    protocol.Sequence{Seq: session.events.lastSeq},
+		SessionID:   session.ID,
+		GameState:   session.Game.FEN(),
+		State:       session.State(),
+		PlayerState: session.playerState(player),
+	}
+}
+
+/*
+The span the session was started in, to trace what happens to it under
+*/
+func (session *GameSession) Trace() tracing.SpanContext {
+	return session.trace
+}
+
+/*
+Outcome of the game, including outcomes decided outside of the board such as timeouts
+*/
+func (session *GameSession) Outcome() chess.Outcome {
+	if session.outcome != chess.NoOutcome {
+		return session.outcome
+	}
+	return session.Game.Outcome()
+}
+
+/*
+Whether the game is over, with a result or aborted
+*/
+func (session *GameSession) Ended() bool {
+	return session.Outcome() != chess.NoOutcome || session.method == protocol.MethodAborted
+}
+
+/*
+Method by which the game ended, one of the protocol.Method* constants, or
+empty while the game is in progress
+*/
+func (session *GameSession) Method() string {
+	if session.method != "" {
+		return session.method
+	}
+	return methodNames[session.Game.Method()]
+}
+
+func (session *GameSession) opponentOf(player *Player) *Player {
+	if player == session.WhitePlayer {
+		return session.BlackPlayer
+	}
+	return session.WhitePlayer
+}
+
+func (session *GameSession) colorOf(player *Player) chess.Color {
+	if player == session.WhitePlayer {
+		return chess.White
+	}
+	return chess.Black
+}
+
+func (session *GameSession) playerState(player *Player) protocol.PlayerState {
+	return protocol.PlayerState{
+		IsWhiteSide:       player == session.WhitePlayer,
+		OpponentConnected: session.opponentOf(player).Conn != nil,
+	}
+}
+
+func (session *GameSession) GetPlayers() [2]*Player {
+	return [2]*Player{session.WhitePlayer, session.BlackPlayer}
+}
+
+func (session *GameSession) GetPlayerById(playerID string) (*Player, error) {
+	if session.WhitePlayer.ID == playerID {
+		return session.WhitePlayer, nil
+	} else if session.BlackPlayer.ID == playerID {
+		return session.BlackPlayer, nil
+	}
+	return nil, errors.New("player not in session")
+}
+
+func (session *GameSession) GetPlayerSide(playerID string) (bool, error) {
+	if _, err := session.GetPlayerById(playerID); err != nil {
+		return false, err
+	}
+	return session.WhitePlayer.ID == playerID, nil
+}
+
+/*
+Run f on the session goroutine, giving it consistent access to the session state
+*/
+func inspect(sessionID string, f func(*GameSession)) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdInspect, inspect: f})
+}
+
+func GetGameFen(sessionID string) (string, error) {
+	var fen string
+	err := inspect(sessionID, func(session *GameSession) {
+		fen = session.Game.FEN()
+	})
+	return fen, err
+}
+
+func GetPlayerState(sessionID, playerID string) (protocol.PlayerState, error) {
+	var playerState protocol.PlayerState
+	var sideErr error
+	err := inspect(sessionID, func(session *GameSession) {
+		var player *Player
+		player, sideErr = session.GetPlayerById(playerID)
+		if sideErr == nil {
+			playerState = session.playerState(player)
 		}
+	})
+	if err != nil {
+		return protocol.PlayerState{}, err
+	}
+	if sideErr != nil {
+		return protocol.PlayerState{}, errors.New("invalid player id")
+	}
+	return playerState, nil
+}
 
-		logging.Info("valid move",
-			zap.String("session_id", sessionID),
-			zap.String("id", movingPlayerID),
-			zap.String("move", move),
-		)
-
-		mu.Unlock()
-
-		// notify players about the new board state
-		for _, player := range session.GetPlayers() {
-			gameFen, err := GetGameFen(sessionID)
-			if err != nil {
-				logging.Error("invalid session id for game state")
-				if err := player.Conn.WriteJSON(errorResponse{
-					Type:  "error",
-					Error: "coulnd't retrieve game state",
-				}); err != nil {
-					logging.Info("ws write", zap.Error(err))
-				}
-				return
-			}
-
-			if player == nil {
-				continue
-			}
-
-			isWhiteSide, err := session.GetPlayerSide(player.ID)
-			if err != nil {
-				logging.Error("invalid player id")
-				continue
-			}
-
-			if err := player.Conn.WriteJSON(SessionResponse{
-				Type:      "session",
-				GameState: gameFen,
-				PlayerState: PlayerState{
-					IsWhiteSide: isWhiteSide,
-				},
-			}); err != nil {
-				logging.Error("couldn't notify player ", zap.String("id", movingPlayerID))
-			}
+/*
+The matched message for the player, carrying the current state and sequence number
+*/
+func MatchState(sessionID, playerID string) (protocol.MatchResponse, error) {
+	var response protocol.MatchResponse
+	var playerErr error
+	err := inspect(sessionID, func(session *GameSession) {
+		var player *Player
+		player, playerErr = session.GetPlayerById(playerID)
+		if playerErr == nil {
+			response = session.matchResponse(player)
 		}
+	})
+	if err != nil {
+		return protocol.MatchResponse{}, err
+	}
+	if playerErr != nil {
+		return protocol.MatchResponse{}, errors.New("invalid player id")
+	}
+	return response, nil
+}
+
+/*
+Who plays a live session and how it stands
+*/
+type Info struct {
+	ID          string
+	White       Player
+	Black       Player
+	TimeControl TimeControl
+	Pool        string
+	Variant     string
+	Rated       bool
+	StartedAt   time.Time
+	MoveTimes   []time.Time
+	Chat        []protocol.ChatMessage
+	State       *protocol.GameState
+	Clock       *protocol.ClockState // Nil for untimed games
+}
 
-		if session.Game.Outcome() != chess.NoOutcome {
-			gameOverHandler(session, sessionID)
+func GetInfo(sessionID string) (Info, error) {
+	var info Info
+	err := inspect(sessionID, func(session *GameSession) {
+		info = Info{
+			ID:          session.ID,
+			White:       *session.WhitePlayer,
+			Black:       *session.BlackPlayer,
+			TimeControl: session.Clock.TimeControl,
+			Pool:        session.Pool,
+			Variant:     session.Variant,
+			Rated:       session.Rated,
+			StartedAt:   session.StartedAt,
+			MoveTimes:   session.MoveTimes(),
+			Chat:        session.ChatLog(),
+			State:       session.State(),
+			Clock:       session.clockState(),
 		}
+	})
+	return info, err
+}
+
+func PlayerInSession(sessionID string, player *Player) bool {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return false
+	}
+	_, err = session.GetPlayerById(player.ID)
+	return err == nil
+}
+
+/*
+Attach a reconnecting player to its session. The session sends the player the
+matched message and, if lastSeq is set, the events after it.
+*/
+func PlayerRejoinExisting(sessionID string, player *Player, lastSeq *int64) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdJoin, player: player, lastSeq: lastSeq})
+}
+
+func PlayerDisconnect(sessionID, playerID, connID string) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdDisconnect, playerID: playerID, connID: connID})
+}
+
+func Abort(sessionID, playerID string) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdAbort, playerID: playerID})
+}
+
+/*
+End a live game with the outcome, aborting it for chess.NoOutcome
+*/
+func ForceEnd(sessionID string, outcome chess.Outcome) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdForceEnd, outcome: outcome})
+}
+
+func Resign(sessionID, playerID string) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdResign, playerID: playerID})
+}
+
+/*
+Offer, accept or decline a draw, see protocol.DrawOffer and friends
+*/
+func Draw(sessionID, playerID, op string) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdDraw, playerID: playerID, op: op})
+}
+
+func Chat(sessionID, playerID, text string) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
+	}
+	return session.do(command{kind: cmdChat, playerID: playerID, text: text})
+}
+
+/*
+Queue or cancel a premove, see protocol.PremoveSet and friends
+*/
+func Premove(sessionID, playerID, op string, move MoveSubmission) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return err
 	}
+	return session.do(command{kind: cmdPremove, playerID: playerID, op: op, move: move})
 }
@@ -0,0 +1,233 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/notnil/chess"
+)
+
+var openingMoves = []string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Nf6", "d3", "Bc5"}
+
+func newTestSession(t testing.TB, sessionID string, timeControl TimeControl) (chan *GameSession, func()) {
+	over := make(chan *GameSession, 1)
+	SetGameOverHandler(func(s *GameSession, id string) {
+		CloseSession(id)
+		over <- s
+	})
+	InitSession(sessionID, &Player{ID: sessionID + "-white"}, &Player{ID: sessionID + "-black"}, Settings{TimeControl: timeControl})
+	return over, func() { CloseSession(sessionID) }
+}
+
+func TestProcessMoveTurnOrder(t *testing.T) {
+	_, cleanup := newTestSession(t, "turn-order", TimeControl{})
+	defer cleanup()
+
+	if err := ProcessMove("turn-order", "turn-order-black", "e5"); err == nil {
+		t.Error("black moved first")
+	}
+	if err := ProcessMove("turn-order", "turn-order-white", "e5"); err == nil {
+		t.Error("illegal move accepted")
+	}
+	if err := ProcessMove("turn-order", "turn-order-white", "e4"); err != nil {
+		t.Error(err)
+	}
+
+	fen, err := GetGameFen("turn-order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1"; fen != want {
+		t.Errorf("fen: got %s, want %s", fen, want)
+	}
+}
+
+func TestResignEndsGame(t *testing.T) {
+	over, cleanup := newTestSession(t, "resign", TimeControl{})
+	defer cleanup()
+
+	if err := Resign("resign", "resign-white"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-over:
+		if s.Outcome() != chess.BlackWon || s.Method() != protocol.MethodResignation {
+			t.Errorf("got %s by %s, want 0-1 by resignation", s.Outcome(), s.Method())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("game over handler not called")
+	}
+
+	if err := ProcessMove("resign", "resign-white", "e4"); err == nil {
+		t.Error("move accepted after game over")
+	}
+}
+
+func TestForceEnd(t *testing.T) {
+	over, cleanup := newTestSession(t, "force-end", TimeControl{})
+	defer cleanup()
+
+	if err := ForceEnd("force-end", chess.Outcome("2-0")); err == nil {
+		t.Error("ended the game with an unknown outcome")
+	}
+	if err := ForceEnd("force-end", chess.BlackWon); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-over:
+		if s.Outcome() != chess.BlackWon || s.Method() != protocol.MethodAdjudication {
+			t.Errorf("got %s by %s, want 0-1 by adjudication", s.Outcome(), s.Method())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("game over handler not called")
+	}
+}
+
+func TestDrawOffer(t *testing.T) {
+	over, cleanup := newTestSession(t, "draw", TimeControl{})
+	defer cleanup()
+
+	if err := Draw("draw", "draw-black", protocol.DrawAccept); err == nil {
+		t.Error("accepted a draw nobody offered")
+	}
+	if err := Draw("draw", "draw-white", protocol.DrawOffer); err != nil {
+		t.Fatal(err)
+	}
+	if err := Draw("draw", "draw-black", protocol.DrawAccept); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-over:
+		if s.Outcome() != chess.Draw || s.Method() != protocol.MethodDrawAgreement {
+			t.Errorf("got %s by %s, want 1/2-1/2 by draw agreement", s.Outcome(), s.Method())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("game over handler not called")
+	}
+}
+
+func TestInfoKeepsChatAndMoveTimes(t *testing.T) {
+	_, cleanup := newTestSession(t, "info", TimeControl{})
+	defer cleanup()
+
+	if err := ProcessMove("info", "info-white", "e4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Chat("info", "info-black", "  good luck "); err != nil {
+		t.Fatal(err)
+	}
+	if err := Chat("info", "info-black", " "); err == nil {
+		t.Error("sent an empty chat message")
+	}
+
+	info, err := GetInfo("info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.MoveTimes) != 1 || info.MoveTimes[0].Before(info.StartedAt) {
+		t.Errorf("move times %v for a game started at %v", info.MoveTimes, info.StartedAt)
+	}
+	if len(info.Chat) != 1 || info.Chat[0].From != "info-black" || info.Chat[0].Text != "good luck" {
+		t.Errorf("got chat %+v", info.Chat)
+	}
+}
+
+func TestClockFlag(t *testing.T) {
+	over, cleanup := newTestSession(t, "flag", TimeControl{Initial: 50 * time.Millisecond})
+	defer cleanup()
+
+	if err := ProcessMove("flag", "flag-white", "e4"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-over:
+		if s.Outcome() != chess.WhiteWon || s.Method() != protocol.MethodTimeout {
+			t.Errorf("got %s by %s, want 1-0 by timeout", s.Outcome(), s.Method())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("black never flagged")
+	}
+}
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    TimeControl
+		wantErr bool
+	}{
+		{"", TimeControl{}, false},
+		{"5+3", TimeControl{Initial: 5 * time.Minute, Increment: 3 * time.Second}, false},
+		{"0.5+0", TimeControl{Initial: 30 * time.Second}, false},
+		{"10", TimeControl{Initial: 10 * time.Minute}, false},
+		{"x+1", TimeControl{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTimeControl(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error: got %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+Play the same short opening in many games at once. Each game is driven by its
+own goroutine, so this measures move throughput when sessions don't share a lock.
+*/
+func BenchmarkConcurrentGames(b *testing.B) {
+	SetGameOverHandler(func(s *GameSession, id string) { CloseSession(id) })
+
+	for _, games := range []int{1, 100, 1000, 5000} {
+		b.Run(fmt.Sprintf("games=%d", games), func(b *testing.B) {
+			ids := make([]string, games)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("bench-%d-%d", games, i)
+				InitSession(ids[i], &Player{ID: "w"}, &Player{ID: "b"}, Settings{})
+			}
+			defer func() {
+				for _, id := range ids {
+					CloseSession(id)
+				}
+			}()
+
+			movesPerGame := b.N/games + 1
+			b.ResetTimer()
+
+			var wg sync.WaitGroup
+			for _, id := range ids {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					for ply := 0; ply < movesPerGame; ply++ {
+						i := ply % len(openingMoves)
+						if i == 0 && ply > 0 {
+							CloseSession(id)
+							InitSession(id, &Player{ID: "w"}, &Player{ID: "b"}, Settings{})
+						}
+						playerID := "w"
+						if i%2 == 1 {
+							playerID = "b"
+						}
+						if err := ProcessMove(id, playerID, openingMoves[i]); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}(id)
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package session
+
+import (
+	"github.com/bstchow/go-chess-server/pkg/metrics"
+)
+
+var (
+	_ = metrics.NewGaugeFunc("chess_live_sessions", "Sessions being played", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(Count())}}
+	})
+	movesPlayed = metrics.NewCounter("chess_moves_total",
+		"Moves applied, including premoves")
+	moveLatency = metrics.NewHistogram("chess_move_processing_seconds",
+		"Time from a move's submission until it is applied or rejected", metrics.DefBuckets, "result")
+)
@@ -0,0 +1,225 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/bstchow/go-chess-server/pkg/tracing"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+/*
+A move as submitted by a client. MoveID and Ply are optional and make
+resubmissions of an already applied move harmless.
+*/
+type MoveSubmission struct {
+	Move     string
+	Notation string // One of the protocol.Notation* constants, empty to detect it
+	MoveID   string
+	Ply      int // Ply the move should become, 1 for white's first move. Zero skips the check.
+}
+
+/*
+Why a move was not applied, with one of the protocol.Reject* reason codes
+*/
+type MoveError struct {
+	Reason string
+	Err    error
+}
+
+func (e *MoveError) Error() string {
+	return e.Err.Error()
+}
+
+func rejectMove(reason string, err error) *MoveError {
+	return &MoveError{Reason: reason, Err: err}
+}
+
+func ProcessMove(sessionID, movingPlayerID, move string) error {
+	return SubmitMove(sessionID, movingPlayerID, MoveSubmission{Move: move})
+}
+
+/*
+Apply a move. The mover is sent a move_ack once it is applied, including when
+the submission turns out to be a duplicate of an applied move, which is then
+not an error. Rejections come back as a *MoveError.
+*/
+func SubmitMove(sessionID, movingPlayerID string, move MoveSubmission) error {
+	session, err := getSession(sessionID)
+	if err != nil {
+		return rejectMove(protocol.RejectNoSession, err)
+	}
+	span := tracing.StartFrom(session.trace, "ProcessMove",
+		tracing.String("session_id", sessionID),
+		tracing.String("player_id", movingPlayerID),
+		tracing.String("move", move.Move),
+	)
+	defer span.End()
+	start := time.Now()
+	err = session.do(command{kind: cmdMove, playerID: movingPlayerID, move: move})
+	result := "applied"
+	if err != nil {
+		result = "rejected"
+		span.RecordError(err)
+	}
+	moveLatency.Observe(time.Since(start).Seconds(), result)
+	if err == ErrSessionClosed {
+		return rejectMove(protocol.RejectNoSession, err)
+	}
+	return err
+}
+
+func (session *GameSession) handleMove(movingPlayerID string, move MoveSubmission) error {
+	player, err := session.GetPlayerById(movingPlayerID)
+	if err != nil {
+		return rejectMove(protocol.RejectInvalidRequest, err)
+	}
+
+	if session.isDuplicate(player, move) {
+		logging.Info("duplicate move",
+			zap.String("session_id", session.ID),
+			zap.String("id", movingPlayerID),
+			zap.String("move", move.Move),
+			zap.String("move_id", move.MoveID),
+		)
+		return nil
+	}
+
+	now := time.Now()
+	if err := session.applyMove(player, move, now); err != nil {
+		logging.Warn("invalid move",
+			zap.String("session_id", session.ID),
+			zap.String("id", movingPlayerID),
+			zap.String("move", move.Move),
+			zap.String("reason", err.Reason),
+			zap.String("error", err.Error()),
+		)
+		session.sendRejection(player, move, err)
+		return err
+	}
+
+	logging.Info("valid move",
+		zap.String("session_id", session.ID),
+		zap.String("id", movingPlayerID),
+		zap.String("move", move.Move),
+	)
+	session.moveApplied(player, move)
+	session.playPremove(now)
+	return nil
+}
+
+/*
+Acknowledge an applied move to its player and send both sides the new state
+*/
+func (session *GameSession) moveApplied(player *Player, move MoveSubmission) {
+	movesPlayed.Inc()
+	ply := len(session.Game.Moves())
+	if move.MoveID != "" {
+		session.moveIDs[moveKey(player, move.MoveID)] = ply
+	}
+	session.emitTo(player, session.moveAck(move.MoveID, ply))
+	session.broadcastState()
+}
+
+func (session *GameSession) applyMove(player *Player, move MoveSubmission, now time.Time) *MoveError {
+	turn := session.Game.Position().Turn()
+	if session.colorOf(player) != turn {
+		return rejectMove(protocol.RejectNotYourTurn, errors.New("not your turn"))
+	}
+	if expected := len(session.Game.Moves()) + 1; move.Ply != 0 && move.Ply != expected {
+		return rejectMove(protocol.RejectWrongPly, fmt.Errorf("expected ply %d", expected))
+	}
+
+	if session.Clock.Flagged(turn, now) {
+		session.flag(turn)
+		return rejectMove(protocol.RejectOutOfTime, errors.New("out of time"))
+	}
+
+	decoded, moveErr := parseMove(session.Game.Position(), move.Move, move.Notation)
+	if moveErr != nil {
+		return moveErr
+	}
+	if err := session.Game.Move(decoded); err != nil {
+		return rejectMove(protocol.RejectIllegalMove, err)
+	}
+
+	session.Clock.Punch(turn, now)
+	session.moveTimes = append(session.moveTimes, now)
+	// Moving instead of answering declines the opponent's draw offer
+	if session.drawOffer == turn.Other() {
+		session.drawOffer = chess.NoColor
+	}
+	return nil
+}
+
+/*
+Report whether the submission repeats a move that was already applied, either
+by its move id or by naming a ply the player already played with the same move.
+The player is sent the acknowledgement again.
+*/
+func (session *GameSession) isDuplicate(player *Player, move MoveSubmission) bool {
+	ply, seen := session.moveIDs[moveKey(player, move.MoveID)]
+	if move.MoveID == "" || !seen {
+		ply, seen = 0, false
+		moves := session.Game.Moves()
+		if move.Ply > 0 && move.Ply <= len(moves) {
+			positions := session.Game.Positions()
+			previous := positions[move.Ply-1]
+			if previous.Turn() == session.colorOf(player) {
+				decoded, err := parseMove(previous, move.Move, move.Notation)
+				if err == nil && decoded.String() == moves[move.Ply-1].String() {
+					ply, seen = move.Ply, true
+				}
+			}
+		}
+	}
+	if !seen {
+		return false
+	}
+
+	player.send(session.moveAck(move.MoveID, ply))
+	return true
+}
+
+/*
+Acknowledge the move played at the given ply, in its canonical UCI form
+*/
+func (session *GameSession) moveAck(moveID string, ply int) *protocol.MoveAckResponse {
+	move := session.Game.Moves()[ply-1]
+	return &protocol.MoveAckResponse{
+		Type:   protocol.TypeMoveAck,
+		MoveID: moveID,
+		Ply:    ply,
+		Move:   move.String(),
+		San:    chess.AlgebraicNotation{}.Encode(session.Game.Positions()[ply-1], move),
+	}
+}
+
+func moveKey(player *Player, moveID string) string {
+	return player.ID + ":" + moveID
+}
+
+/*
+Clients speaking protocol version 2 get a structured rejection, older ones
+keep getting the error message for illegal moves
+*/
+func (session *GameSession) sendRejection(player *Player, move MoveSubmission, err *MoveError) {
+	if player.protocolVersion() >= protocol.MoveRejectionVersion {
+		player.send(protocol.MoveRejectedResponse{
+			Type:   protocol.TypeMoveRejected,
+			MoveID: move.MoveID,
+			Reason: err.Reason,
+			Error:  err.Error(),
+		})
+		return
+	}
+	switch err.Reason {
+	case protocol.RejectIllegalMove, protocol.RejectUnparseableMove, protocol.RejectAmbiguousMove:
+		player.send(protocol.NewError("invalid move: " + err.Error()))
+	}
+}
@@ -0,0 +1,58 @@
+package session
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+)
+
+func TestSubmitMoveIdempotent(t *testing.T) {
+	_, cleanup := newTestSession(t, "idempotent", TimeControl{})
+	defer cleanup()
+
+	white, black := "idempotent-white", "idempotent-black"
+	steps := []struct {
+		name     string
+		playerID string
+		move     MoveSubmission
+		reason   string
+	}{
+		{"first move", white, MoveSubmission{Move: "e4", MoveID: "w1", Ply: 1}, ""},
+		{"same move id", white, MoveSubmission{Move: "e4", MoveID: "w1", Ply: 1}, ""},
+		{"same ply without id", white, MoveSubmission{Move: "e4", Ply: 1}, ""},
+		{"different move for played ply", white, MoveSubmission{Move: "d4", Ply: 1}, protocol.RejectNotYourTurn},
+		{"ply from the future", black, MoveSubmission{Move: "e5", Ply: 3}, protocol.RejectWrongPly},
+		{"illegal move", black, MoveSubmission{Move: "e4", Ply: 2}, protocol.RejectIllegalMove},
+		{"reply", black, MoveSubmission{Move: "e5", MoveID: "b1", Ply: 2}, ""},
+	}
+
+	for _, step := range steps {
+		err := SubmitMove("idempotent", step.playerID, step.move)
+		var moveErr *MoveError
+		switch {
+		case step.reason == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", step.name, err)
+		case step.reason != "" && !errors.As(err, &moveErr):
+			t.Errorf("%s: got %v, want rejection %s", step.name, err, step.reason)
+		case step.reason != "" && moveErr.Reason != step.reason:
+			t.Errorf("%s: got reason %s, want %s", step.name, moveErr.Reason, step.reason)
+		}
+	}
+
+	fen, err := GetGameFen("idempotent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2"; fen != want {
+		t.Errorf("fen: got %s, want %s", fen, want)
+	}
+}
+
+func TestSubmitMoveNoSession(t *testing.T) {
+	err := SubmitMove("missing", "nobody", MoveSubmission{Move: "e4"})
+	var moveErr *MoveError
+	if !errors.As(err, &moveErr) || moveErr.Reason != protocol.RejectNoSession {
+		t.Errorf("got %v, want %s rejection", err, protocol.RejectNoSession)
+	}
+}
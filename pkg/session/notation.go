@@ -0,0 +1,189 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"github.com/notnil/chess"
+)
+
+var (
+	uciPattern    = regexp.MustCompile(`^([a-h][1-8])([a-h][1-8])([qrbn]?)$`)
+	lanPattern    = regexp.MustCompile(`^([KQRBNP]?)([a-h][1-8])[-x:]?([a-h][1-8])=?([QRBNqrbn]?)$`)
+	sanPattern    = regexp.MustCompile(`^([KQRBN]?)([a-h]?)([1-8]?)[x:]?([a-h][1-8])(?:=?([QRBN]))?$`)
+	castlePattern = regexp.MustCompile(`^[O0]-[O0](-[O0])?$`)
+)
+
+/*
+What a move in some notation says about the move it stands for. Empty fields
+match any legal move.
+*/
+type moveSpec struct {
+	input    string
+	piece    chess.PieceType
+	fromFile string
+	fromRank string
+	to       string
+	promo    chess.PieceType
+	castle   chess.MoveTag
+}
+
+/*
+Resolve a move written in the given notation against the legal moves of the
+position. An empty notation accepts any of them. Input that can't be read in
+the notation is rejected as unparseable, input that names no legal move as
+illegal and input that names several as ambiguous.
+*/
+func parseMove(pos *chess.Position, input, notation string) (*chess.Move, *MoveError) {
+	spec, err := parseMoveSpec(input, notation)
+	if err != nil {
+		return nil, err
+	}
+	return spec.resolve(pos)
+}
+
+/*
+Read a move without a position to resolve it against
+*/
+func parseMoveSpec(input, notation string) (*moveSpec, *MoveError) {
+	input = strings.TrimSpace(input)
+	// Check and annotation suffixes carry no information about the move
+	input = strings.TrimSuffix(input, "e.p.")
+	input = strings.TrimRight(input, "+#!? ")
+
+	var spec *moveSpec
+	switch notation {
+	case protocol.NotationUCI:
+		spec = parseUCI(input)
+	case protocol.NotationLAN:
+		spec = parseLAN(input)
+	case protocol.NotationSAN:
+		spec = parseSAN(input)
+	case "":
+		for _, parse := range []func(string) *moveSpec{parseUCI, parseLAN, parseSAN} {
+			if spec = parse(input); spec != nil {
+				break
+			}
+		}
+	default:
+		return nil, rejectMove(protocol.RejectInvalidRequest, fmt.Errorf("unknown notation %q", notation))
+	}
+	if spec == nil {
+		return nil, rejectMove(protocol.RejectUnparseableMove, fmt.Errorf("can't parse move %q", input))
+	}
+	spec.input = input
+	return spec, nil
+}
+
+func (spec *moveSpec) resolve(pos *chess.Position) (*chess.Move, *MoveError) {
+	var matches []*chess.Move
+	for _, move := range pos.ValidMoves() {
+		if spec.matches(pos, move) {
+			matches = append(matches, move)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, rejectMove(protocol.RejectIllegalMove, fmt.Errorf("%s is not a legal move", spec.input))
+	case 1:
+		return matches[0], nil
+	default:
+		candidates := make([]string, len(matches))
+		for i, move := range matches {
+			candidates[i] = move.String()
+		}
+		return nil, rejectMove(protocol.RejectAmbiguousMove,
+			errors.New(spec.input+" is ambiguous between "+strings.Join(candidates, ", ")))
+	}
+}
+
+func parseUCI(input string) *moveSpec {
+	parts := uciPattern.FindStringSubmatch(strings.ToLower(input))
+	if parts == nil {
+		return nil
+	}
+	return &moveSpec{
+		fromFile: parts[1][:1],
+		fromRank: parts[1][1:],
+		to:       parts[2],
+		promo:    pieceType(parts[3]),
+	}
+}
+
+/*
+Long algebraic notation names both squares, e.g. e2-e4, Ng1-f3, e7xd8=Q
+*/
+func parseLAN(input string) *moveSpec {
+	if spec := parseCastle(input); spec != nil {
+		return spec
+	}
+	parts := lanPattern.FindStringSubmatch(input)
+	if parts == nil {
+		return nil
+	}
+	return &moveSpec{
+		piece:    pieceType(parts[1]),
+		fromFile: parts[2][:1],
+		fromRank: parts[2][1:],
+		to:       parts[3],
+		promo:    pieceType(parts[4]),
+	}
+}
+
+func parseSAN(input string) *moveSpec {
+	if spec := parseCastle(input); spec != nil {
+		return spec
+	}
+	parts := sanPattern.FindStringSubmatch(input)
+	if parts == nil {
+		return nil
+	}
+	piece := chess.Pawn
+	if parts[1] != "" {
+		piece = pieceType(parts[1])
+	}
+	return &moveSpec{
+		piece:    piece,
+		fromFile: parts[2],
+		fromRank: parts[3],
+		to:       parts[4],
+		promo:    pieceType(parts[5]),
+	}
+}
+
+func parseCastle(input string) *moveSpec {
+	parts := castlePattern.FindStringSubmatch(input)
+	if parts == nil {
+		return nil
+	}
+	if parts[1] != "" {
+		return &moveSpec{castle: chess.QueenSideCastle}
+	}
+	return &moveSpec{castle: chess.KingSideCastle}
+}
+
+func (spec *moveSpec) matches(pos *chess.Position, move *chess.Move) bool {
+	if spec.castle != 0 {
+		return move.HasTag(spec.castle)
+	}
+	from := move.S1()
+	return (spec.piece == chess.NoPieceType || pos.Board().Piece(from).Type() == spec.piece) &&
+		(spec.fromFile == "" || from.File().String() == spec.fromFile) &&
+		(spec.fromRank == "" || from.Rank().String() == spec.fromRank) &&
+		move.S2().String() == spec.to &&
+		move.Promo() == spec.promo
+}
+
+func pieceType(letter string) chess.PieceType {
+	letter = strings.ToLower(letter)
+	for _, pt := range chess.PieceTypes() {
+		if letter != "" && pt.String() == letter {
+			return pt
+		}
+	}
+	return chess.NoPieceType
+}
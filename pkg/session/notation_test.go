@@ -0,0 +1,58 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/notnil/chess"
+)
+
+func TestParseMove(t *testing.T) {
+	// White knights on b1 and f3 can both reach d2
+	fen, err := chess.FEN("r1bqkbnr/pppp1ppp/2n5/4p3/4P3/5N2/PPP2PPP/RNBQKB1R w KQkq - 0 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := chess.NewGame(fen).Position()
+
+	tests := []struct {
+		input    string
+		notation string
+		want     string
+		reason   string
+	}{
+		{"f1c4", protocol.NotationUCI, "f1c4", ""},
+		{"F1C4", "", "f1c4", ""},
+		{"Bc4", protocol.NotationSAN, "f1c4", ""},
+		{"Bf1-c4", protocol.NotationLAN, "f1c4", ""},
+		{"f1-c4", "", "f1c4", ""},
+		{"Nbd2", protocol.NotationSAN, "b1d2", ""},
+		{"N3d2", "", "f3d2", ""},
+		{"Nxe5+", "", "f3e5", ""},
+		{"Nd2", protocol.NotationSAN, "", protocol.RejectAmbiguousMove},
+		{"Bc4", protocol.NotationUCI, "", protocol.RejectUnparseableMove},
+		{"e2-e4", protocol.NotationSAN, "", protocol.RejectUnparseableMove},
+		{"hello", "", "", protocol.RejectUnparseableMove},
+		{"Ke3", protocol.NotationSAN, "", protocol.RejectIllegalMove},
+		{"e4", "", "", protocol.RejectIllegalMove},
+		{"e4", "fen", "", protocol.RejectInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notation+"/"+tt.input, func(t *testing.T) {
+			move, moveErr := parseMove(pos, tt.input, tt.notation)
+			if tt.reason != "" {
+				if moveErr == nil || moveErr.Reason != tt.reason {
+					t.Fatalf("got %v, want %s", moveErr, tt.reason)
+				}
+				return
+			}
+			if moveErr != nil {
+				t.Fatal(moveErr)
+			}
+			if move.String() != tt.want {
+				t.Errorf("got %s, want %s", move, tt.want)
+			}
+		})
+	}
+}
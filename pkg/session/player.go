@@ -1,8 +1,47 @@
 package session
 
-import "github.com/gorilla/websocket"
+import (
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"go.uber.org/zap"
+)
+
+/*
+Where a player's messages go. Humans are connected through a *corenet.Conn,
+bots receive the same messages in-process.
+*/
+type Conn interface {
+	WriteJSON(v interface{}) error
+	ProtocolVersion() int
+	Close() error
+}
 
 type Player struct {
-	Conn *websocket.Conn
-	ID   string `json:"id"`
+	Conn   Conn
+	ConnID string
+	ID     string `json:"id"`
+	Bot    bool   `json:"bot"` // Moves are picked by a program
+}
+
+/*
+Protocol version of the player's current connection
+*/
+func (player *Player) protocolVersion() int {
+	if player.Conn == nil {
+		return protocol.DefaultVersion
+	}
+	return player.Conn.ProtocolVersion()
+}
+
+/*
+Write a message to the player, skipping players that are currently disconnected
+*/
+func (player *Player) send(v interface{}) {
+	if player.Conn == nil {
+		return
+	}
+	if err := player.Conn.WriteJSON(v); err != nil {
+		logging.Info("ws write", zap.String("id", player.ID), zap.Error(err))
+	}
 }
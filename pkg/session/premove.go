@@ -0,0 +1,114 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/logging"
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"github.com/notnil/chess"
+	"go.uber.org/zap"
+)
+
+/*
+A move queued by the side not to move, played as soon as the opponent moved
+*/
+type premove struct {
+	color chess.Color
+	move  MoveSubmission
+}
+
+/*
+Only the side waiting for the opponent can queue a premove. Its legality can't
+be known until the opponent moved, so only its notation is checked here.
+Queueing another premove replaces the pending one.
+*/
+func (session *GameSession) handlePremove(playerID, op string, move MoveSubmission) error {
+	player, err := session.GetPlayerById(playerID)
+	if err != nil {
+		return err
+	}
+	color := session.colorOf(player)
+
+	switch op {
+	case protocol.PremoveSet:
+		if color == session.Game.Position().Turn() {
+			return errors.New("it is your turn, send a move instead")
+		}
+		if _, err := parseMoveSpec(move.Move, move.Notation); err != nil {
+			return err
+		}
+		session.premove = &premove{color: color, move: move}
+		session.emitPremove(player, protocol.PremoveQueued, move, nil)
+	case protocol.PremoveCancel:
+		if session.premove == nil || session.premove.color != color {
+			return errors.New("no premove to cancel")
+		}
+		move = session.premove.move
+		session.premove = nil
+		session.emitPremove(player, protocol.PremoveCancelled, move, nil)
+	default:
+		return errors.New("unknown premove operation")
+	}
+	return nil
+}
+
+/*
+Play the pending premove, if any, right after the opponent's move made at now.
+It is applied as of that same instant, so the premover's clock loses no time.
+A premove that isn't legal in the new position is dropped.
+*/
+func (session *GameSession) playPremove(now time.Time) {
+	queued := session.premove
+	session.premove = nil
+	if queued == nil || session.Ended() {
+		return
+	}
+	player := session.WhitePlayer
+	if queued.color == chess.Black {
+		player = session.BlackPlayer
+	}
+
+	if err := session.applyMove(player, queued.move, now); err != nil {
+		logging.Info("premove dropped",
+			zap.String("session_id", session.ID),
+			zap.String("id", player.ID),
+			zap.String("move", queued.move.Move),
+			zap.String("reason", err.Reason),
+		)
+		session.emitPremove(player, protocol.PremoveDropped, queued.move, err)
+		return
+	}
+
+	logging.Info("premove played",
+		zap.String("session_id", session.ID),
+		zap.String("id", player.ID),
+		zap.String("move", queued.move.Move),
+	)
+	session.moveApplied(player, queued.move)
+}
+
+func (session *GameSession) emitPremove(player *Player, status string, move MoveSubmission, err *MoveError) {
+	response := &protocol.PremoveResponse{
+		Type:   protocol.TypePremove,
+		Status: status,
+		Move:   move.Move,
+		MoveID: move.MoveID,
+	}
+	if err != nil {
+		response.Reason = err.Reason
+		response.Error = err.Error()
+	}
+	session.emitTo(player, response)
+}
+
+/*
+The pending premove of the player, empty if there is none
+*/
+func (session *GameSession) premoveOf(player *Player) string {
+	if session.premove == nil || session.premove.color != session.colorOf(player) {
+		return ""
+	}
+	return session.premove.move.Move
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+	"github.com/notnil/chess"
+)
+
+func TestPremove(t *testing.T) {
+	_, cleanup := newTestSession(t, "premove", TimeControl{Initial: time.Minute})
+	defer cleanup()
+
+	white, black := "premove-white", "premove-black"
+	type step struct {
+		name     string
+		playerID string
+		op       string
+		move     string
+		wantErr  bool
+	}
+	play := func(steps []step) {
+		t.Helper()
+		for _, step := range steps {
+			var err error
+			if step.op == "" {
+				err = ProcessMove("premove", step.playerID, step.move)
+			} else {
+				err = Premove("premove", step.playerID, step.op, MoveSubmission{Move: step.move})
+			}
+			if (err != nil) != step.wantErr {
+				t.Fatalf("%s: got error %v, want error %v", step.name, err, step.wantErr)
+			}
+		}
+	}
+
+	play([]step{
+		{"on own turn", white, protocol.PremoveSet, "e4", true},
+		{"move", white, "", "e4", false},
+		{"unparseable", white, protocol.PremoveSet, "hello", true},
+		{"queue", white, protocol.PremoveSet, "Nc3", false},
+		{"cancel", white, protocol.PremoveCancel, "", false},
+		{"cancel again", white, protocol.PremoveCancel, "", true},
+		{"queue again", white, protocol.PremoveSet, "g1f3", false},
+		{"opponent moves", black, "", "e5", false},
+	})
+	err := inspect("premove", func(s *GameSession) {
+		// The premove was played the instant black moved
+		if remaining := s.Clock.Remaining(chess.White, time.Now()); remaining != time.Minute {
+			t.Errorf("white's clock: got %s, want %s", remaining, time.Minute)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	play([]step{
+		{"reply", black, "", "Nc6", false},
+		// Needs a white pawn on d4, so it is dropped when white plays Bc4
+		{"queue capture", black, protocol.PremoveSet, "exd4", false},
+		{"no capture", white, "", "Bc4", false},
+		{"black still to move", black, "", "Nf6", false},
+	})
+	err = inspect("premove", func(s *GameSession) {
+		want := []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6"}
+		if got := s.UCIMoves(); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("moves: got %v, want %v", got, want)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
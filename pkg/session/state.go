@@ -0,0 +1,76 @@
+package session
+
+import (
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+
+	"github.com/notnil/chess"
+)
+
+var methodNames = map[chess.Method]string{
+	chess.Checkmate:            protocol.MethodCheckmate,
+	chess.Resignation:          protocol.MethodResignation,
+	chess.DrawOffer:            protocol.MethodDrawAgreement,
+	chess.Stalemate:            protocol.MethodStalemate,
+	chess.ThreefoldRepetition:  protocol.MethodThreefoldRepetition,
+	chess.FivefoldRepetition:   protocol.MethodFivefoldRepetition,
+	chess.FiftyMoveRule:        protocol.MethodFiftyMoveRule,
+	chess.SeventyFiveMoveRule:  protocol.MethodSeventyFiveMoveRule,
+	chess.InsufficientMaterial: protocol.MethodInsufficientMaterial,
+}
+
+/*
+Snapshot of the board and game for the state payload of session messages
+*/
+func (session *GameSession) State() *protocol.GameState {
+	position := session.Game.Position()
+	positions := session.Game.Positions()
+	moves := session.Game.Moves()
+
+	state := &protocol.GameState{
+		Fen:         session.Game.FEN(),
+		Status:      protocol.StatusActive,
+		IsWhiteTurn: position.Turn() == chess.White,
+		LegalMoves:  []string{},
+		Moves:       make([]protocol.MoveRecord, 0, len(moves)),
+		Outcome:     session.Outcome().String(),
+		Method:      session.Method(),
+	}
+	for i, move := range moves {
+		state.Moves = append(state.Moves, protocol.MoveRecord{
+			Uci: move.String(),
+			San: chess.AlgebraicNotation{}.Encode(positions[i], move),
+		})
+	}
+	if len(moves) > 0 {
+		state.LastMove = &state.Moves[len(moves)-1]
+		state.InCheck = moves[len(moves)-1].HasTag(chess.Check)
+	}
+	if session.Ended() {
+		state.Status = protocol.StatusEnded
+		return state
+	}
+	for _, move := range position.ValidMoves() {
+		state.LegalMoves = append(state.LegalMoves, move.String())
+	}
+	return state
+}
+
+/*
+When each of the moves so far was played
+*/
+func (session *GameSession) MoveTimes() []time.Time {
+	return append([]time.Time{}, session.moveTimes...)
+}
+
+/*
+The moves played so far in UCI, the notation games are stored in
+*/
+func (session *GameSession) UCIMoves() []string {
+	moves := make([]string, 0, len(session.Game.Moves()))
+	for _, move := range session.Game.Moves() {
+		moves = append(moves, move.String())
+	}
+	return moves
+}
@@ -0,0 +1,45 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bstchow/go-chess-server/pkg/protocol"
+)
+
+func TestStateAfterCheckmate(t *testing.T) {
+	over, cleanup := newTestSession(t, "mate", TimeControl{})
+	defer cleanup()
+
+	moves := []string{"e4", "e5", "Bc4", "Nc6", "Qh5", "Nf6", "Qxf7#"}
+	for i, move := range moves {
+		playerID := "mate-white"
+		if i%2 == 1 {
+			playerID = "mate-black"
+		}
+		if err := ProcessMove("mate", playerID, move); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var state *protocol.GameState
+	select {
+	case s := <-over:
+		state = s.State()
+	case <-time.After(time.Second):
+		t.Fatal("game over handler not called")
+	}
+
+	if state.Status != protocol.StatusEnded || state.Outcome != "1-0" || state.Method != protocol.MethodCheckmate {
+		t.Errorf("got %s %s by %s, want ENDED 1-0 by checkmate", state.Status, state.Outcome, state.Method)
+	}
+	if !state.InCheck || state.IsWhiteTurn {
+		t.Errorf("got in_check %v is_white_turn %v, want black in check", state.InCheck, state.IsWhiteTurn)
+	}
+	if state.LastMove == nil || state.LastMove.Uci != "h5f7" || state.LastMove.San != "Qxf7#" {
+		t.Errorf("got last move %+v, want h5f7 Qxf7#", state.LastMove)
+	}
+	if len(state.Moves) != len(moves) || len(state.LegalMoves) != 0 {
+		t.Errorf("got %d moves and %d legal moves, want %d and 0", len(state.Moves), len(state.LegalMoves), len(moves))
+	}
+}
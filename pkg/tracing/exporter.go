@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bstchow/go-chess-server/internal/env"
+)
+
+/*
+An Exporter sends finished spans somewhere, e.g. a file or a collector.
+ExportSpan is called from the goroutine that ended the span, so it must not
+block for long.
+*/
+type Exporter interface {
+	ExportSpan(span SpanData)
+	Shutdown() error
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+	factories  = map[string]func() (Exporter, error){
+		"stdout": func() (Exporter, error) {
+			return NewWriterExporter(os.Stdout), nil
+		},
+		"file": func() (Exporter, error) {
+			file, err := os.OpenFile(env.GetEnv("TRACE_FILE"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			return &writerExporter{w: file, closer: file}, nil
+		},
+	}
+)
+
+/*
+Make an exporter available to TRACE_EXPORTER under the name
+*/
+func RegisterExporter(name string, factory func() (Exporter, error)) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	factories[name] = factory
+}
+
+/*
+Export spans to e, or stop recording them for a nil e. The previous exporter
+is shut down.
+*/
+func SetExporter(e Exporter) error {
+	exporterMu.Lock()
+	previous := exporter
+	exporter = e
+	exporterMu.Unlock()
+	if previous != nil {
+		return previous.Shutdown()
+	}
+	return nil
+}
+
+/*
+Export spans with the exporter named by TRACE_EXPORTER. An empty name
+leaves tracing off.
+*/
+func InitFromEnv() error {
+	name := env.GetEnv("TRACE_EXPORTER")
+	if name == "" {
+		return nil
+	}
+	exporterMu.RLock()
+	factory, ok := factories[name]
+	names := make([]string, 0, len(factories))
+	for known := range factories {
+		names = append(names, known)
+	}
+	exporterMu.RUnlock()
+	if !ok {
+		sort.Strings(names)
+		return fmt.Errorf("unknown trace exporter %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	e, err := factory()
+	if err != nil {
+		return fmt.Errorf("trace exporter %s: %w", name, err)
+	}
+	return SetExporter(e)
+}
+
+/*
+Flush and stop the exporter
+*/
+func Shutdown() error {
+	return SetExporter(nil)
+}
+
+func export(span SpanData) {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	if exporter != nil {
+		exporter.ExportSpan(span)
+	}
+}
+
+type writerExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+/*
+An Exporter writing each span as a line of JSON, for reading traces without
+a collector
+*/
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+func (e *writerExporter) ExportSpan(span SpanData) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(line, '\n'))
+}
+
+func (e *writerExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
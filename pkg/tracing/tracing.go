@@ -0,0 +1,207 @@
+/*
+Package tracing records spans, timed operations that nest into traces, in the
+manner of OpenTelemetry. Spans started with a context carrying another span
+become its children; a span context can also be kept and used as the parent
+of spans started later, e.g. the moves of a game. Finished spans are handed
+to the exporter chosen with TRACE_EXPORTER, nothing records them without one.
+*/
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+/*
+Identifies a span and the trace it belongs to
+*/
+type SpanContext struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+type contextKey struct{}
+
+/*
+Return a context whose spans become children of the span context
+*/
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+/*
+The span context of the latest span started in the context, if any
+*/
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
+
+/*
+A key and value describing a span, e.g. the session it worked on
+*/
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{key, value}
+}
+
+func Int(key string, value int) Attribute {
+	return Attribute{key, value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{key, value}
+}
+
+/*
+A finished span as it is exported
+*/
+type SpanData struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_span_id,omitempty"`
+	Name       string         `json:"name"`
+	Start      time.Time      `json:"start_time"`
+	End        time.Time      `json:"end_time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Links      []SpanContext  `json:"links,omitempty"` // Related spans of other traces
+	Error      string         `json:"error,omitempty"`
+}
+
+/*
+A span being recorded. Its methods may be called from any goroutine and do
+nothing after End.
+*/
+type Span struct {
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+/*
+Start a span, the child of the span in ctx if there is one and the root of a
+new trace otherwise. The returned context carries the new span.
+*/
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	span := StartFrom(SpanContextFromContext(ctx), name, attributes...)
+	return ContextWithSpanContext(ctx, span.Context()), span
+}
+
+/*
+Start a child of the parent span context, or the root of a new trace for an
+invalid parent
+*/
+func StartFrom(parent SpanContext, name string, attributes ...Attribute) *Span {
+	span := &Span{data: SpanData{
+		TraceID:  parent.TraceID,
+		SpanID:   newID(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+	}}
+	if !parent.IsValid() {
+		span.data.TraceID = newID(16)
+		span.data.ParentID = ""
+	}
+	span.SetAttributes(attributes...)
+	return span
+}
+
+func newID(bytes int) string {
+	id := make([]byte, bytes)
+	for i := 0; i < bytes; i += 8 {
+		n := rand.Uint64()
+		for j := i; j < i+8 && j < bytes; j++ {
+			id[j] = byte(n)
+			n >>= 8
+		}
+	}
+	return hex.EncodeToString(id)
+}
+
+func (s *Span) Context() SpanContext {
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID}
+}
+
+/*
+Rename the span, e.g. once the route a request matched is known
+*/
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if len(attributes) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]any{}
+	}
+	for _, attribute := range attributes {
+		s.data.Attributes[attribute.Key] = attribute.Value
+	}
+}
+
+/*
+Relate the span to a span of another trace, e.g. the request that caused it
+*/
+func (s *Span) AddLink(sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Links = append(s.data.Links, sc)
+	}
+}
+
+/*
+Mark the span as failed. A nil error is ignored.
+*/
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+/*
+Finish the span and export it
+*/
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	export(data)
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSpansNestIntoTraces(t *testing.T) {
+	var buf bytes.Buffer
+	SetExporter(NewWriterExporter(&buf))
+	defer SetExporter(nil)
+
+	ctx, root := Start(context.Background(), "matching", String("player_id", "alice"))
+	_, child := Start(ctx, "EnterQueue")
+	child.End()
+	root.End()
+	later := StartFrom(root.Context(), "ProcessMove")
+	later.RecordError(errors.New("illegal move"))
+	later.End()
+	later.SetAttributes(String("ignored", "after end"))
+	later.End()
+	other := StartFrom(SpanContext{}, "findMatch")
+	other.AddLink(root.Context())
+	other.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d spans, want 4:\n%s", len(lines), buf.String())
+	}
+	spans := make([]SpanData, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &spans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enterQueue, matching, processMove, findMatch := spans[0], spans[1], spans[2], spans[3]
+
+	if matching.ParentID != "" || len(matching.TraceID) != 32 || len(matching.SpanID) != 16 {
+		t.Errorf("root span: got %+v", matching)
+	}
+	if matching.Attributes["player_id"] != "alice" {
+		t.Errorf("attributes: got %v", matching.Attributes)
+	}
+	for _, span := range []SpanData{enterQueue, processMove} {
+		if span.TraceID != matching.TraceID || span.ParentID != matching.SpanID {
+			t.Errorf("%s isn't a child of matching: %+v", span.Name, span)
+		}
+	}
+	if processMove.Error != "illegal move" || processMove.Attributes["ignored"] != nil {
+		t.Errorf("ended span changed: %+v", processMove)
+	}
+	if findMatch.TraceID == matching.TraceID {
+		t.Error("a span without parent joined an existing trace")
+	}
+	if len(findMatch.Links) != 1 || findMatch.Links[0] != root.Context() {
+		t.Errorf("links: got %v", findMatch.Links)
+	}
+}